@@ -0,0 +1,688 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: broker/v1/broker.proto
+
+package brokerv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Trade struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Account string                 `protobuf:"bytes,1,opt,name=account,proto3" json:"account,omitempty"`
+	Symbol  string                 `protobuf:"bytes,2,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Volume  float64                `protobuf:"fixed64,3,opt,name=volume,proto3" json:"volume,omitempty"`
+	Open    float64                `protobuf:"fixed64,4,opt,name=open,proto3" json:"open,omitempty"`
+	Close   float64                `protobuf:"fixed64,5,opt,name=close,proto3" json:"close,omitempty"`
+	// "buy" or "sell".
+	Side          string `protobuf:"bytes,6,opt,name=side,proto3" json:"side,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Trade) Reset() {
+	*x = Trade{}
+	mi := &file_broker_v1_broker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Trade) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Trade) ProtoMessage() {}
+
+func (x *Trade) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Trade.ProtoReflect.Descriptor instead.
+func (*Trade) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Trade) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+func (x *Trade) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Trade) GetVolume() float64 {
+	if x != nil {
+		return x.Volume
+	}
+	return 0
+}
+
+func (x *Trade) GetOpen() float64 {
+	if x != nil {
+		return x.Open
+	}
+	return 0
+}
+
+func (x *Trade) GetClose() float64 {
+	if x != nil {
+		return x.Close
+	}
+	return 0
+}
+
+func (x *Trade) GetSide() string {
+	if x != nil {
+		return x.Side
+	}
+	return ""
+}
+
+type SubmitTradeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Trade         *Trade                 `protobuf:"bytes,1,opt,name=trade,proto3" json:"trade,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitTradeRequest) Reset() {
+	*x = SubmitTradeRequest{}
+	mi := &file_broker_v1_broker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitTradeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitTradeRequest) ProtoMessage() {}
+
+func (x *SubmitTradeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitTradeRequest.ProtoReflect.Descriptor instead.
+func (*SubmitTradeRequest) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubmitTradeRequest) GetTrade() *Trade {
+	if x != nil {
+		return x.Trade
+	}
+	return nil
+}
+
+type SubmitTradeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Set when the idempotency key was used before and nothing was queued.
+	Replayed      bool `protobuf:"varint,2,opt,name=replayed,proto3" json:"replayed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitTradeResponse) Reset() {
+	*x = SubmitTradeResponse{}
+	mi := &file_broker_v1_broker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitTradeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitTradeResponse) ProtoMessage() {}
+
+func (x *SubmitTradeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitTradeResponse.ProtoReflect.Descriptor instead.
+func (*SubmitTradeResponse) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SubmitTradeResponse) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *SubmitTradeResponse) GetReplayed() bool {
+	if x != nil {
+		return x.Replayed
+	}
+	return false
+}
+
+type SubmitTradesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Trade         *Trade                 `protobuf:"bytes,1,opt,name=trade,proto3" json:"trade,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitTradesRequest) Reset() {
+	*x = SubmitTradesRequest{}
+	mi := &file_broker_v1_broker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitTradesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitTradesRequest) ProtoMessage() {}
+
+func (x *SubmitTradesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitTradesRequest.ProtoReflect.Descriptor instead.
+func (*SubmitTradesRequest) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SubmitTradesRequest) GetTrade() *Trade {
+	if x != nil {
+		return x.Trade
+	}
+	return nil
+}
+
+type SubmitTradesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []int64                `protobuf:"varint,1,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+	Replayed      bool                   `protobuf:"varint,2,opt,name=replayed,proto3" json:"replayed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitTradesResponse) Reset() {
+	*x = SubmitTradesResponse{}
+	mi := &file_broker_v1_broker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitTradesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitTradesResponse) ProtoMessage() {}
+
+func (x *SubmitTradesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitTradesResponse.ProtoReflect.Descriptor instead.
+func (*SubmitTradesResponse) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SubmitTradesResponse) GetIds() []int64 {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+func (x *SubmitTradesResponse) GetReplayed() bool {
+	if x != nil {
+		return x.Replayed
+	}
+	return false
+}
+
+type GetStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Account       string                 `protobuf:"bytes,1,opt,name=account,proto3" json:"account,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_broker_v1_broker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetStatsRequest) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+type GetStatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Stats         *Stats                 `protobuf:"bytes,1,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsResponse) Reset() {
+	*x = GetStatsResponse{}
+	mi := &file_broker_v1_broker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsResponse) ProtoMessage() {}
+
+func (x *GetStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsResponse.ProtoReflect.Descriptor instead.
+func (*GetStatsResponse) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetStatsResponse) GetStats() *Stats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type WatchStatsRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Account string                 `protobuf:"bytes,1,opt,name=account,proto3" json:"account,omitempty"`
+	// How often to check for changes; defaults to one second.
+	Interval      *durationpb.Duration `protobuf:"bytes,2,opt,name=interval,proto3" json:"interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchStatsRequest) Reset() {
+	*x = WatchStatsRequest{}
+	mi := &file_broker_v1_broker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStatsRequest) ProtoMessage() {}
+
+func (x *WatchStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStatsRequest.ProtoReflect.Descriptor instead.
+func (*WatchStatsRequest) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchStatsRequest) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+func (x *WatchStatsRequest) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+type WatchStatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Stats         *Stats                 `protobuf:"bytes,1,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchStatsResponse) Reset() {
+	*x = WatchStatsResponse{}
+	mi := &file_broker_v1_broker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStatsResponse) ProtoMessage() {}
+
+func (x *WatchStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStatsResponse.ProtoReflect.Descriptor instead.
+func (*WatchStatsResponse) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchStatsResponse) GetStats() *Stats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type Stats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Account       string                 `protobuf:"bytes,1,opt,name=account,proto3" json:"account,omitempty"`
+	Trades        int64                  `protobuf:"varint,2,opt,name=trades,proto3" json:"trades,omitempty"`
+	Profit        float64                `protobuf:"fixed64,3,opt,name=profit,proto3" json:"profit,omitempty"`
+	OpenPositions int64                  `protobuf:"varint,4,opt,name=open_positions,json=openPositions,proto3" json:"open_positions,omitempty"`
+	Unrealized    float64                `protobuf:"fixed64,5,opt,name=unrealized,proto3" json:"unrealized,omitempty"`
+	Equity        float64                `protobuf:"fixed64,6,opt,name=equity,proto3" json:"equity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_broker_v1_broker_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_v1_broker_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_broker_v1_broker_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Stats) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+func (x *Stats) GetTrades() int64 {
+	if x != nil {
+		return x.Trades
+	}
+	return 0
+}
+
+func (x *Stats) GetProfit() float64 {
+	if x != nil {
+		return x.Profit
+	}
+	return 0
+}
+
+func (x *Stats) GetOpenPositions() int64 {
+	if x != nil {
+		return x.OpenPositions
+	}
+	return 0
+}
+
+func (x *Stats) GetUnrealized() float64 {
+	if x != nil {
+		return x.Unrealized
+	}
+	return 0
+}
+
+func (x *Stats) GetEquity() float64 {
+	if x != nil {
+		return x.Equity
+	}
+	return 0
+}
+
+var File_broker_v1_broker_proto protoreflect.FileDescriptor
+
+const file_broker_v1_broker_proto_rawDesc = "" +
+	"\n" +
+	"\x16broker/v1/broker.proto\x12\tbroker.v1\x1a\x1egoogle/protobuf/duration.proto\"\x8f\x01\n" +
+	"\x05Trade\x12\x18\n" +
+	"\aaccount\x18\x01 \x01(\tR\aaccount\x12\x16\n" +
+	"\x06symbol\x18\x02 \x01(\tR\x06symbol\x12\x16\n" +
+	"\x06volume\x18\x03 \x01(\x01R\x06volume\x12\x12\n" +
+	"\x04open\x18\x04 \x01(\x01R\x04open\x12\x14\n" +
+	"\x05close\x18\x05 \x01(\x01R\x05close\x12\x12\n" +
+	"\x04side\x18\x06 \x01(\tR\x04side\"<\n" +
+	"\x12SubmitTradeRequest\x12&\n" +
+	"\x05trade\x18\x01 \x01(\v2\x10.broker.v1.TradeR\x05trade\"A\n" +
+	"\x13SubmitTradeResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1a\n" +
+	"\breplayed\x18\x02 \x01(\bR\breplayed\"=\n" +
+	"\x13SubmitTradesRequest\x12&\n" +
+	"\x05trade\x18\x01 \x01(\v2\x10.broker.v1.TradeR\x05trade\"D\n" +
+	"\x14SubmitTradesResponse\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\x03R\x03ids\x12\x1a\n" +
+	"\breplayed\x18\x02 \x01(\bR\breplayed\"+\n" +
+	"\x0fGetStatsRequest\x12\x18\n" +
+	"\aaccount\x18\x01 \x01(\tR\aaccount\":\n" +
+	"\x10GetStatsResponse\x12&\n" +
+	"\x05stats\x18\x01 \x01(\v2\x10.broker.v1.StatsR\x05stats\"d\n" +
+	"\x11WatchStatsRequest\x12\x18\n" +
+	"\aaccount\x18\x01 \x01(\tR\aaccount\x125\n" +
+	"\binterval\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\binterval\"<\n" +
+	"\x12WatchStatsResponse\x12&\n" +
+	"\x05stats\x18\x01 \x01(\v2\x10.broker.v1.StatsR\x05stats\"\xb0\x01\n" +
+	"\x05Stats\x12\x18\n" +
+	"\aaccount\x18\x01 \x01(\tR\aaccount\x12\x16\n" +
+	"\x06trades\x18\x02 \x01(\x03R\x06trades\x12\x16\n" +
+	"\x06profit\x18\x03 \x01(\x01R\x06profit\x12%\n" +
+	"\x0eopen_positions\x18\x04 \x01(\x03R\ropenPositions\x12\x1e\n" +
+	"\n" +
+	"unrealized\x18\x05 \x01(\x01R\n" +
+	"unrealized\x12\x16\n" +
+	"\x06equity\x18\x06 \x01(\x01R\x06equity2\xc2\x02\n" +
+	"\rBrokerService\x12L\n" +
+	"\vSubmitTrade\x12\x1d.broker.v1.SubmitTradeRequest\x1a\x1e.broker.v1.SubmitTradeResponse\x12Q\n" +
+	"\fSubmitTrades\x12\x1e.broker.v1.SubmitTradesRequest\x1a\x1f.broker.v1.SubmitTradesResponse(\x01\x12C\n" +
+	"\bGetStats\x12\x1a.broker.v1.GetStatsRequest\x1a\x1b.broker.v1.GetStatsResponse\x12K\n" +
+	"\n" +
+	"WatchStats\x12\x1c.broker.v1.WatchStatsRequest\x1a\x1d.broker.v1.WatchStatsResponse0\x01B;Z9gitlab.com/digineat/go-broker-test/api/broker/v1;brokerv1b\x06proto3"
+
+var (
+	file_broker_v1_broker_proto_rawDescOnce sync.Once
+	file_broker_v1_broker_proto_rawDescData []byte
+)
+
+func file_broker_v1_broker_proto_rawDescGZIP() []byte {
+	file_broker_v1_broker_proto_rawDescOnce.Do(func() {
+		file_broker_v1_broker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_broker_v1_broker_proto_rawDesc), len(file_broker_v1_broker_proto_rawDesc)))
+	})
+	return file_broker_v1_broker_proto_rawDescData
+}
+
+var file_broker_v1_broker_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_broker_v1_broker_proto_goTypes = []any{
+	(*Trade)(nil),                // 0: broker.v1.Trade
+	(*SubmitTradeRequest)(nil),   // 1: broker.v1.SubmitTradeRequest
+	(*SubmitTradeResponse)(nil),  // 2: broker.v1.SubmitTradeResponse
+	(*SubmitTradesRequest)(nil),  // 3: broker.v1.SubmitTradesRequest
+	(*SubmitTradesResponse)(nil), // 4: broker.v1.SubmitTradesResponse
+	(*GetStatsRequest)(nil),      // 5: broker.v1.GetStatsRequest
+	(*GetStatsResponse)(nil),     // 6: broker.v1.GetStatsResponse
+	(*WatchStatsRequest)(nil),    // 7: broker.v1.WatchStatsRequest
+	(*WatchStatsResponse)(nil),   // 8: broker.v1.WatchStatsResponse
+	(*Stats)(nil),                // 9: broker.v1.Stats
+	(*durationpb.Duration)(nil),  // 10: google.protobuf.Duration
+}
+var file_broker_v1_broker_proto_depIdxs = []int32{
+	0,  // 0: broker.v1.SubmitTradeRequest.trade:type_name -> broker.v1.Trade
+	0,  // 1: broker.v1.SubmitTradesRequest.trade:type_name -> broker.v1.Trade
+	9,  // 2: broker.v1.GetStatsResponse.stats:type_name -> broker.v1.Stats
+	10, // 3: broker.v1.WatchStatsRequest.interval:type_name -> google.protobuf.Duration
+	9,  // 4: broker.v1.WatchStatsResponse.stats:type_name -> broker.v1.Stats
+	1,  // 5: broker.v1.BrokerService.SubmitTrade:input_type -> broker.v1.SubmitTradeRequest
+	3,  // 6: broker.v1.BrokerService.SubmitTrades:input_type -> broker.v1.SubmitTradesRequest
+	5,  // 7: broker.v1.BrokerService.GetStats:input_type -> broker.v1.GetStatsRequest
+	7,  // 8: broker.v1.BrokerService.WatchStats:input_type -> broker.v1.WatchStatsRequest
+	2,  // 9: broker.v1.BrokerService.SubmitTrade:output_type -> broker.v1.SubmitTradeResponse
+	4,  // 10: broker.v1.BrokerService.SubmitTrades:output_type -> broker.v1.SubmitTradesResponse
+	6,  // 11: broker.v1.BrokerService.GetStats:output_type -> broker.v1.GetStatsResponse
+	8,  // 12: broker.v1.BrokerService.WatchStats:output_type -> broker.v1.WatchStatsResponse
+	9,  // [9:13] is the sub-list for method output_type
+	5,  // [5:9] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_broker_v1_broker_proto_init() }
+func file_broker_v1_broker_proto_init() {
+	if File_broker_v1_broker_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_broker_v1_broker_proto_rawDesc), len(file_broker_v1_broker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_broker_v1_broker_proto_goTypes,
+		DependencyIndexes: file_broker_v1_broker_proto_depIdxs,
+		MessageInfos:      file_broker_v1_broker_proto_msgTypes,
+	}.Build()
+	File_broker_v1_broker_proto = out.File
+	file_broker_v1_broker_proto_goTypes = nil
+	file_broker_v1_broker_proto_depIdxs = nil
+}
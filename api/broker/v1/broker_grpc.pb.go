@@ -0,0 +1,256 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.0
+// - protoc             (unknown)
+// source: broker/v1/broker.proto
+
+package brokerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	BrokerService_SubmitTrade_FullMethodName  = "/broker.v1.BrokerService/SubmitTrade"
+	BrokerService_SubmitTrades_FullMethodName = "/broker.v1.BrokerService/SubmitTrades"
+	BrokerService_GetStats_FullMethodName     = "/broker.v1.BrokerService/GetStats"
+	BrokerService_WatchStats_FullMethodName   = "/broker.v1.BrokerService/WatchStats"
+)
+
+// BrokerServiceClient is the client API for BrokerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// BrokerService queues closed trades and reports account statistics, like
+// POST /trades and GET /stats/{acc} on the HTTP API.
+//
+// Credentials are sent as "authorization" metadata in the same schemes as the
+// HTTP Authorization header ("ApiKey <key>", "Bearer <jwt>") or as a client
+// certificate. Submissions take an optional "idempotency-key" metadata entry
+// with the semantics of the Idempotency-Key header.
+type BrokerServiceClient interface {
+	SubmitTrade(ctx context.Context, in *SubmitTradeRequest, opts ...grpc.CallOption) (*SubmitTradeResponse, error)
+	// SubmitTrades queues every streamed trade in one transaction when the
+	// client closes the stream; if any trade is rejected, none is queued.
+	SubmitTrades(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SubmitTradesRequest, SubmitTradesResponse], error)
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error)
+	// WatchStats sends the account's statistics, then again whenever they
+	// change, until the client cancels.
+	WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchStatsResponse], error)
+}
+
+type brokerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBrokerServiceClient(cc grpc.ClientConnInterface) BrokerServiceClient {
+	return &brokerServiceClient{cc}
+}
+
+func (c *brokerServiceClient) SubmitTrade(ctx context.Context, in *SubmitTradeRequest, opts ...grpc.CallOption) (*SubmitTradeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitTradeResponse)
+	err := c.cc.Invoke(ctx, BrokerService_SubmitTrade_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *brokerServiceClient) SubmitTrades(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SubmitTradesRequest, SubmitTradesResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BrokerService_ServiceDesc.Streams[0], BrokerService_SubmitTrades_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubmitTradesRequest, SubmitTradesResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BrokerService_SubmitTradesClient = grpc.ClientStreamingClient[SubmitTradesRequest, SubmitTradesResponse]
+
+func (c *brokerServiceClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatsResponse)
+	err := c.cc.Invoke(ctx, BrokerService_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *brokerServiceClient) WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchStatsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BrokerService_ServiceDesc.Streams[1], BrokerService_WatchStats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchStatsRequest, WatchStatsResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BrokerService_WatchStatsClient = grpc.ServerStreamingClient[WatchStatsResponse]
+
+// BrokerServiceServer is the server API for BrokerService service.
+// All implementations must embed UnimplementedBrokerServiceServer
+// for forward compatibility.
+//
+// BrokerService queues closed trades and reports account statistics, like
+// POST /trades and GET /stats/{acc} on the HTTP API.
+//
+// Credentials are sent as "authorization" metadata in the same schemes as the
+// HTTP Authorization header ("ApiKey <key>", "Bearer <jwt>") or as a client
+// certificate. Submissions take an optional "idempotency-key" metadata entry
+// with the semantics of the Idempotency-Key header.
+type BrokerServiceServer interface {
+	SubmitTrade(context.Context, *SubmitTradeRequest) (*SubmitTradeResponse, error)
+	// SubmitTrades queues every streamed trade in one transaction when the
+	// client closes the stream; if any trade is rejected, none is queued.
+	SubmitTrades(grpc.ClientStreamingServer[SubmitTradesRequest, SubmitTradesResponse]) error
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error)
+	// WatchStats sends the account's statistics, then again whenever they
+	// change, until the client cancels.
+	WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[WatchStatsResponse]) error
+	mustEmbedUnimplementedBrokerServiceServer()
+}
+
+// UnimplementedBrokerServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBrokerServiceServer struct{}
+
+func (UnimplementedBrokerServiceServer) SubmitTrade(context.Context, *SubmitTradeRequest) (*SubmitTradeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method SubmitTrade not implemented")
+}
+func (UnimplementedBrokerServiceServer) SubmitTrades(grpc.ClientStreamingServer[SubmitTradesRequest, SubmitTradesResponse]) error {
+	return status.Error(codes.Unimplemented, "method SubmitTrades not implemented")
+}
+func (UnimplementedBrokerServiceServer) GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedBrokerServiceServer) WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[WatchStatsResponse]) error {
+	return status.Error(codes.Unimplemented, "method WatchStats not implemented")
+}
+func (UnimplementedBrokerServiceServer) mustEmbedUnimplementedBrokerServiceServer() {}
+func (UnimplementedBrokerServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeBrokerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BrokerServiceServer will
+// result in compilation errors.
+type UnsafeBrokerServiceServer interface {
+	mustEmbedUnimplementedBrokerServiceServer()
+}
+
+func RegisterBrokerServiceServer(s grpc.ServiceRegistrar, srv BrokerServiceServer) {
+	// If the following call panics, it indicates UnimplementedBrokerServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&BrokerService_ServiceDesc, srv)
+}
+
+func _BrokerService_SubmitTrade_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitTradeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BrokerServiceServer).SubmitTrade(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BrokerService_SubmitTrade_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BrokerServiceServer).SubmitTrade(ctx, req.(*SubmitTradeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BrokerService_SubmitTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BrokerServiceServer).SubmitTrades(&grpc.GenericServerStream[SubmitTradesRequest, SubmitTradesResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BrokerService_SubmitTradesServer = grpc.ClientStreamingServer[SubmitTradesRequest, SubmitTradesResponse]
+
+func _BrokerService_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BrokerServiceServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BrokerService_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BrokerServiceServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BrokerService_WatchStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BrokerServiceServer).WatchStats(m, &grpc.GenericServerStream[WatchStatsRequest, WatchStatsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BrokerService_WatchStatsServer = grpc.ServerStreamingServer[WatchStatsResponse]
+
+// BrokerService_ServiceDesc is the grpc.ServiceDesc for BrokerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BrokerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "broker.v1.BrokerService",
+	HandlerType: (*BrokerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitTrade",
+			Handler:    _BrokerService_SubmitTrade_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _BrokerService_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubmitTrades",
+			Handler:       _BrokerService_SubmitTrades_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchStats",
+			Handler:       _BrokerService_WatchStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "broker/v1/broker.proto",
+}
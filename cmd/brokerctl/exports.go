@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/export"
+)
+
+// runExport writes trades or account stats to a file or stdout, like
+// GET /exports/trades and GET /exports/stats.
+func runExport(db *sql.DB, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || (args[0] != "trades" && args[0] != "stats") {
+		return fmt.Errorf("%w: export needs trades or stats", errUsage)
+	}
+	table := args[0]
+
+	fs := flag.NewFlagSet("export "+table, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", export.FormatCSV, "csv, ndjson or parquet")
+	out := fs.String("o", "", "file to write (default stdout)")
+	account := fs.String("account", "", "only this account")
+	var symbol, status, from, to, at *string
+	if table == "trades" {
+		symbol = fs.String("symbol", "", "only this symbol")
+		status = fs.String("status", "", "comma-separated statuses: pending, processed, rejected")
+		from = fs.String("from", "", "queued at or after, as YYYY-MM-DD or RFC 3339")
+		to = fs.String("to", "", "queued before, as YYYY-MM-DD or RFC 3339")
+	} else {
+		at = fs.String("at", "", "totals as of this time instead of now, as YYYY-MM-DD or RFC 3339")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+	if export.ContentType(*format) == "" {
+		return fmt.Errorf("%w: %v", errUsage, export.ErrFormat)
+	}
+	parseTime := func(name, v string) (t time.Time, err error) {
+		if v == "" {
+			return t, nil
+		}
+		if t, err = export.ParseTime(v); err != nil {
+			return t, fmt.Errorf("%w: -%s: %v", errUsage, name, err)
+		}
+		return t, nil
+	}
+
+	var run func(io.Writer) (int, error)
+	if table == "trades" {
+		f := dbm.TradeFilter{Account: *account, Symbol: *symbol}
+		var err error
+		if *status != "" {
+			if f.States, err = export.ParseStatuses(*status); err != nil {
+				return fmt.Errorf("%w: %v", errUsage, err)
+			}
+		}
+		if f.From, err = parseTime("from", *from); err != nil {
+			return err
+		}
+		if f.To, err = parseTime("to", *to); err != nil {
+			return err
+		}
+		run = func(w io.Writer) (int, error) { return export.Trades(w, *format, db, f) }
+	} else {
+		f := dbm.StatsFilter{Account: *account}
+		var err error
+		if f.At, err = parseTime("at", *at); err != nil {
+			return err
+		}
+		run = func(w io.Writer) (int, error) { return export.Stats(w, *format, db, f) }
+	}
+
+	w := stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+	n, err := run(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("export failed: %v", err)
+	}
+	if *out != "" {
+		fmt.Fprintf(stdout, "exported %d %s rows to %s\n", n, table, *out)
+	}
+	return nil
+}
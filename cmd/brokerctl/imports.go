@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/importer"
+)
+
+// runImport queues the trades in a CSV file or MetaTrader statement, like
+// POST /imports but reading the file before returning.
+func runImport(db *sql.DB, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	account := fs.String("account", "", "account the trades belong to")
+	format := fs.String("format", importer.FormatCSV, "file format: csv or metatrader")
+	columns := fs.String("columns", "", "CSV columns holding trade fields, as field=Column,...")
+	name := fs.String("name", "", "name to record for the import (default the file name)")
+	maxPending := fs.Int("max-pending", 0, "wait between batches while more rows are pending (0 disables)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *account == "" || fs.NArg() != 1 {
+		return fmt.Errorf("%w: import needs -account and a file", errUsage)
+	}
+	if *format != importer.FormatCSV && *format != importer.FormatMetaTrader {
+		return fmt.Errorf("%w: -format must be csv or metatrader", errUsage)
+	}
+	mapping, err := importer.ParseMapping(*columns)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	path := fs.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %v", err)
+	}
+	src := &importer.CountingReader{R: f}
+	rows, err := importer.NewReader(src, *format, mapping, *account)
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = filepath.Base(path)
+	}
+
+	imp, err := dbm.CreateImport(db, *account, *format, *name, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to create import: %v", err)
+	}
+	runErr := importer.Run(db, imp.ID, rows, func() int64 { return src.N }, *maxPending)
+	if imp, err = dbm.GetImport(db, imp.ID); err != nil {
+		return fmt.Errorf("failed to get import: %v", err)
+	}
+	fmt.Fprintf(stdout, "import %d: %d rows, %d queued, %d duplicates, %d rejected\n",
+		imp.ID, imp.Rows, imp.Queued, imp.Duplicates, imp.Rejected)
+	for _, e := range imp.Errors {
+		fmt.Fprintf(stdout, "line %d: ticket %q: %s\n", e.Line, e.Ticket, e.Error)
+	}
+	if runErr != nil {
+		return fmt.Errorf("import %d failed: %v", imp.ID, runErr)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func runKeys(db *sql.DB, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: keys needs a subcommand", errUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		name := fs.String("name", "", "name of the key's owner")
+		accounts := fs.String("accounts", "", `comma-separated accounts the key may use, or "*" for all`)
+		perms := fs.String("perms", dbm.PermRead, "comma-separated permissions: read, write")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		if *name == "" || *accounts == "" {
+			return fmt.Errorf("%w: keys create needs -name and -accounts", errUsage)
+		}
+		permissions := splitFlag(*perms)
+		for _, p := range permissions {
+			if p != dbm.PermRead && p != dbm.PermWrite {
+				return fmt.Errorf("%w: unknown permission %q", errUsage, p)
+			}
+		}
+
+		key, k, err := dbm.CreateAPIKey(db, *name, splitFlag(*accounts), permissions)
+		if err != nil {
+			return fmt.Errorf("failed to create key: %v", err)
+		}
+		fmt.Fprintf(stdout, "created key %d for %s; it is shown only once:\n%s\n", k.ID, k.Name, key)
+		return nil
+
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: keys revoke needs a key id", errUsage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("%w: invalid key id %q", errUsage, args[1])
+		}
+		if err := dbm.RevokeAPIKey(db, id); errors.Is(err, dbm.ErrAPIKeyNotFound) {
+			return fmt.Errorf("no active key with id %d", id)
+		} else if err != nil {
+			return fmt.Errorf("failed to revoke key: %v", err)
+		}
+		fmt.Fprintf(stdout, "revoked key %d\n", id)
+		return nil
+
+	case "list":
+		keys, err := dbm.ListAPIKeys(db)
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %v", err)
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tACCOUNTS\tPERMISSIONS\tCREATED\tREVOKED")
+		for _, k := range keys {
+			revoked := "-"
+			if k.RevokedAt != nil {
+				revoked = k.RevokedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name,
+				strings.Join(k.Accounts, ","), strings.Join(k.Permissions, ","),
+				k.CreatedAt.Format("2006-01-02 15:04:05"), revoked)
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("%w: unknown keys subcommand %q", errUsage, args[0])
+}
+
+func splitFlag(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
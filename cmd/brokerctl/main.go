@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+const usage = `usage: brokerctl [-db path] <command> [arguments]
+
+commands:
+  keys create -name NAME -accounts ACC[,ACC...] [-perms read,write]
+  keys revoke ID
+  keys list
+  webhooks create -url URL [-events TYPE[,TYPE...]] [-accounts ACC[,ACC...]]
+  webhooks disable ID
+  webhooks list
+  import -account ACC [-format csv|metatrader] [-columns field=Column,...] [-name NAME] [-max-pending N] FILE
+  export trades [-format csv|ndjson|parquet] [-o FILE] [-account ACC] [-symbol SYM] [-status S[,S...]] [-from T] [-to T]
+  export stats [-format csv|ndjson|parquet] [-o FILE] [-account ACC] [-at T]
+  queue stats
+  queue list [-account ACC] [-symbol SYM] [-status S[,S...]] [-after ID] [-limit N]
+  queue show ID
+  queue requeue ID
+  queue cancel [-reason TEXT] ID
+  queue purge -days N
+  stats show [ACC]
+  stats reset ACC
+
+queue stats counts pending, processed and rejected (dead letter) trades. The
+worker applies a pending trade in one transaction without claiming it first,
+so the queue has no in-flight state to count.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func OpenDatabase(dbPath string) (*sql.DB, error) {
+	db, err := dbm.Open(dbPath, dbm.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+	if err := dbm.InitDB(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	return db, nil
+}
+
+// Run executes one brokerctl command and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("brokerctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	dbPath := fs.String("db", "data.db", "path to SQLite database")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	db, err := OpenDatabase(*dbPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "keys":
+		err = runKeys(db, rest, stdout, stderr)
+	case "webhooks":
+		err = runWebhooks(db, rest, stdout, stderr)
+	case "import":
+		err = runImport(db, rest, stdout, stderr)
+	case "export":
+		err = runExport(db, rest, stdout, stderr)
+	case "queue":
+		err = runQueue(db, rest, stdout, stderr)
+	case "stats":
+		err = runStats(db, rest, stdout, stderr)
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, cmd)
+	}
+
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(stderr, err)
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(Run(os.Args[1:], os.Stdout, os.Stderr))
+}
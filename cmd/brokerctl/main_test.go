@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func runCmd(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ctl.db")
+	if code, _, _ := runCmd(t, "-db", dbPath); code != 2 {
+		t.Errorf("no command: exit %d, want 2", code)
+	}
+	if code, _, stderr := runCmd(t, "-db", dbPath, "bogus"); code != 2 || !strings.Contains(stderr, "unknown command") {
+		t.Errorf("unknown command: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, _ := runCmd(t, "-db", "/invalid/path/to/db", "keys", "list"); code != 1 {
+		t.Errorf("invalid db: exit %d, want 1", code)
+	}
+}
+
+func TestKeysCommands(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ctl.db")
+
+	code, _, stderr := runCmd(t, "-db", dbPath, "keys", "create", "-name", "gw")
+	if code != 2 {
+		t.Errorf("create without accounts: exit %d, stderr %q", code, stderr)
+	}
+	code, _, _ = runCmd(t, "-db", dbPath, "keys", "create", "-name", "gw", "-accounts", "acc1", "-perms", "admin")
+	if code != 2 {
+		t.Errorf("create with unknown permission: exit %d", code)
+	}
+
+	code, stdout, stderr := runCmd(t, "-db", dbPath, "keys", "create", "-name", "gw", "-accounts", "acc1,acc2", "-perms", "read,write")
+	if code != 0 {
+		t.Fatalf("create: exit %d, stderr %q", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	key := lines[len(lines)-1]
+
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	k, err := dbm.LookupAPIKey(db, key)
+	db.Close()
+	if err != nil || k.Name != "gw" || len(k.Accounts) != 2 || len(k.Permissions) != 2 {
+		t.Fatalf("created key lookup = %+v, %v", k, err)
+	}
+
+	code, stdout, _ = runCmd(t, "-db", dbPath, "keys", "list")
+	if code != 0 || !strings.Contains(stdout, "acc1,acc2") {
+		t.Errorf("list: exit %d, output %q", code, stdout)
+	}
+
+	if code, _, stderr := runCmd(t, "-db", dbPath, "keys", "revoke", "1"); code != 0 {
+		t.Errorf("revoke: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "keys", "revoke", "1"); code != 1 {
+		t.Errorf("second revoke: exit %d, want 1", code)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "keys", "revoke", "x"); code != 2 {
+		t.Errorf("revoke with bad id: exit %d, want 2", code)
+	}
+}
+
+func TestWebhooksCommands(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ctl.db")
+
+	if code, _, _ := runCmd(t, "-db", dbPath, "webhooks", "create"); code != 2 {
+		t.Errorf("create without url: exit %d, want 2", code)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "webhooks", "create", "-url", "ftp://crm.example/hook"); code != 2 {
+		t.Errorf("create with ftp url: exit %d, want 2", code)
+	}
+
+	code, stdout, stderr := runCmd(t, "-db", dbPath, "webhooks", "create", "-url", "https://crm.example/hook", "-accounts", "acc1")
+	if code != 0 {
+		t.Fatalf("create: exit %d, stderr %q", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	secret := lines[len(lines)-1]
+
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	w, err := dbm.GetWebhook(db, 1)
+	db.Close()
+	if err != nil || w.Secret != secret || w.Accounts[0] != "acc1" || len(w.Events) != 2 {
+		t.Fatalf("created webhook = %+v, %v", w, err)
+	}
+
+	code, stdout, _ = runCmd(t, "-db", dbPath, "webhooks", "list")
+	if code != 0 || !strings.Contains(stdout, "https://crm.example/hook") || strings.Contains(stdout, secret) {
+		t.Errorf("list: exit %d, output %q", code, stdout)
+	}
+	if code, _, stderr := runCmd(t, "-db", dbPath, "webhooks", "disable", "1"); code != 0 {
+		t.Errorf("disable: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "webhooks", "disable", "1"); code != 1 {
+		t.Errorf("second disable: exit %d, want 1", code)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ctl.db")
+	file := filepath.Join(dir, "history.csv")
+	csv := "Order;Symbol;Side;Volume;Open;Close\n" +
+		"1001;EURUSD;buy;1;1.1;1.2\n" +
+		"1002;GBPUSD;hold;1;1.3;1.2\n"
+	if err := os.WriteFile(file, []byte(csv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, _ := runCmd(t, "-db", dbPath, "import", file); code != 2 {
+		t.Errorf("import without account: exit %d, want 2", code)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "import", "-account", "acc1", file); code != 1 {
+		t.Errorf("import without ticket column: exit %d, want 1", code)
+	}
+
+	code, stdout, stderr := runCmd(t, "-db", dbPath, "import", "-account", "acc1", "-columns", "ticket=Order", file)
+	if code != 0 {
+		t.Fatalf("import: exit %d, stderr %q", code, stderr)
+	}
+	if !strings.Contains(stdout, "2 rows, 1 queued, 0 duplicates, 1 rejected") || !strings.Contains(stdout, `line 3: ticket "1002"`) {
+		t.Errorf("import output %q", stdout)
+	}
+	code, stdout, _ = runCmd(t, "-db", dbPath, "import", "-account", "acc1", "-columns", "ticket=Order", file)
+	if code != 0 || !strings.Contains(stdout, "0 queued, 1 duplicates") {
+		t.Errorf("second import: exit %d, output %q", code, stdout)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ctl.db")
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc2", Symbol: "GBPUSD", Volume: 1, Open: 1.3, Close: 1.2, Side: "sell"},
+	}, "", "")
+	if err == nil {
+		err = dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1"}, 10)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, _ := runCmd(t, "-db", dbPath, "export"); code != 2 {
+		t.Errorf("export without table: exit %d, want 2", code)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "export", "trades", "-format", "xlsx"); code != 2 {
+		t.Errorf("export with unknown format: exit %d, want 2", code)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "export", "stats", "-status", "processed"); code != 2 {
+		t.Errorf("export stats with -status: exit %d, want 2", code)
+	}
+
+	code, stdout, stderr := runCmd(t, "-db", dbPath, "export", "trades", "-status", "processed")
+	if code != 0 || !strings.HasPrefix(stdout, "id,account,symbol,") || strings.Count(stdout, "\n") != 2 || !strings.Contains(stdout, ",processed,") {
+		t.Errorf("export trades: exit %d, stderr %q, output %q", code, stderr, stdout)
+	}
+
+	out := filepath.Join(dir, "stats.ndjson")
+	code, stdout, stderr = runCmd(t, "-db", dbPath, "export", "stats", "-format", "ndjson", "-o", out)
+	if code != 0 || !strings.Contains(stdout, "exported 1 stats rows") {
+		t.Fatalf("export stats: exit %d, stderr %q, output %q", code, stderr, stdout)
+	}
+	if b, err := os.ReadFile(out); err != nil || !strings.HasPrefix(string(b), `{"account":"acc1","trades":1,"profit":10,`) {
+		t.Errorf("stats file = %q, %v", b, err)
+	}
+}
+
+func TestQueueCommands(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ctl.db")
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc1", Symbol: "GBPUSD", Volume: 1, Open: 1.3, Close: 1.2, Side: "sell"},
+		{Account: "acc2", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+	}, "", "")
+	if err == nil {
+		err = dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1"}, 10)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, _ := runCmd(t, "-db", dbPath, "queue", "stats")
+	if code != 0 || !strings.Contains(stdout, "pending                  2") || !strings.Contains(stdout, "processed                1") {
+		t.Errorf("queue stats: exit %d, output %q", code, stdout)
+	}
+
+	if code, stdout, stderr := runCmd(t, "-db", dbPath, "queue", "cancel", "-reason", "duplicate fill", "2"); code != 0 || stdout != "cancelled trade 2\n" {
+		t.Errorf("cancel: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if code, _, stderr := runCmd(t, "-db", dbPath, "queue", "cancel", "2"); code != 1 || !strings.Contains(stderr, "not pending") {
+		t.Errorf("second cancel: exit %d, stderr %q", code, stderr)
+	}
+	code, stdout, _ = runCmd(t, "-db", dbPath, "queue", "list", "-status", "rejected")
+	if code != 0 || strings.Count(stdout, "\n") != 2 || !strings.Contains(stdout, "duplicate fill") {
+		t.Errorf("list rejected: exit %d, output %q", code, stdout)
+	}
+	code, stdout, _ = runCmd(t, "-db", dbPath, "queue", "show", "2")
+	if code != 0 || !strings.Contains(stdout, "status     rejected") || !strings.Contains(stdout, "reason     duplicate fill") {
+		t.Errorf("show: exit %d, output %q", code, stdout)
+	}
+	if code, _, _ := runCmd(t, "-db", dbPath, "queue", "requeue", "2"); code != 0 {
+		t.Errorf("requeue: exit %d", code)
+	}
+	if code, _, stderr := runCmd(t, "-db", dbPath, "queue", "requeue", "1"); code != 1 || !strings.Contains(stderr, "not rejected") {
+		t.Errorf("requeue of a processed trade: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, stderr := runCmd(t, "-db", dbPath, "queue", "show", "9"); code != 1 || !strings.Contains(stderr, "no trade with id 9") {
+		t.Errorf("show missing trade: exit %d, stderr %q", code, stderr)
+	}
+	for _, args := range [][]string{{"queue", "show"}, {"queue", "requeue", "x"}, {"queue", "purge"}, {"queue", "list", "-status", "dead"}, {"queue", "bogus"}} {
+		if code, _, _ := runCmd(t, append([]string{"-db", dbPath}, args...)...); code != 2 {
+			t.Errorf("%v: exit %d, want 2", args, code)
+		}
+	}
+	if code, stdout, _ := runCmd(t, "-db", dbPath, "queue", "purge", "-days", "30"); code != 0 || !strings.HasPrefix(stdout, "purged 0 trades") {
+		t.Errorf("purge: exit %d, output %q", code, stdout)
+	}
+
+	code, stdout, _ = runCmd(t, "-db", dbPath, "stats", "show")
+	if code != 0 || !strings.Contains(stdout, "acc1     1       10.00") {
+		t.Errorf("stats show: exit %d, output %q", code, stdout)
+	}
+	if code, stdout, _ := runCmd(t, "-db", dbPath, "stats", "reset", "acc1"); code != 0 || stdout != "reset acc1 from trades=1 profit=10.00\n" {
+		t.Errorf("stats reset: exit %d, output %q", code, stdout)
+	}
+	if code, stdout, _ := runCmd(t, "-db", dbPath, "stats", "show", "acc1"); code != 0 || !strings.Contains(stdout, "acc1     0       0.00") {
+		t.Errorf("stats show after reset: exit %d, output %q", code, stdout)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/export"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+func runQueue(db *sql.DB, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: queue needs a subcommand", errUsage)
+	}
+
+	switch args[0] {
+	case "stats":
+		s, err := dbm.GetQueueStats(db)
+		if err != nil {
+			return fmt.Errorf("failed to count trades: %v", err)
+		}
+		oldest := "-"
+		if !s.OldestPending.IsZero() {
+			oldest = time.Since(s.OldestPending).Truncate(time.Second).String()
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "pending\t%d\n", s.Pending)
+		fmt.Fprintf(tw, "oldest pending\t%s\n", oldest)
+		fmt.Fprintf(tw, "processed\t%d\n", s.Processed)
+		fmt.Fprintf(tw, "rejected\t%d\n", s.Rejected)
+		fmt.Fprintf(tw, "pending position events\t%d\n", s.PendingPositionEvents)
+		return tw.Flush()
+
+	case "list":
+		fs := flag.NewFlagSet("queue list", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		account := fs.String("account", "", "only this account")
+		symbol := fs.String("symbol", "", "only this symbol")
+		status := fs.String("status", "", "comma-separated statuses: pending, processed, rejected")
+		after := fs.Int("after", 0, "only trades with a higher id")
+		limit := fs.Int("limit", 50, "most trades to list")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		if *limit <= 0 {
+			return fmt.Errorf("%w: -limit must be positive", errUsage)
+		}
+		f := dbm.TradeFilter{Account: *account, Symbol: *symbol}
+		if *status != "" {
+			var err error
+			if f.States, err = export.ParseStatuses(*status); err != nil {
+				return fmt.Errorf("%w: %v", errUsage, err)
+			}
+		}
+		trades, err := dbm.ListTrades(db, f, *after, *limit)
+		if err != nil {
+			return fmt.Errorf("failed to list trades: %v", err)
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tACCOUNT\tSYMBOL\tSIDE\tVOLUME\tOPEN\tCLOSE\tSTATUS\tQUEUED\tREASON")
+		for _, t := range trades {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%g\t%g\t%g\t%s\t%s\t%s\n", t.ID, t.Account, t.Symbol, t.Side,
+				t.Volume, t.Open, t.Close, dbm.StateName(t.State), formatTime(t.CreatedAt), t.Reason)
+		}
+		return tw.Flush()
+
+	case "show":
+		id, err := tradeID(args)
+		if err != nil {
+			return err
+		}
+		t, err := dbm.GetTrade(db, id)
+		if errors.Is(err, dbm.ErrTradeNotFound) {
+			return fmt.Errorf("no trade with id %d", id)
+		} else if err != nil {
+			return fmt.Errorf("failed to get trade: %v", err)
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "id\t%d\n", t.ID)
+		fmt.Fprintf(tw, "account\t%s\n", t.Account)
+		fmt.Fprintf(tw, "symbol\t%s\n", t.Symbol)
+		fmt.Fprintf(tw, "side\t%s\n", t.Side)
+		fmt.Fprintf(tw, "volume\t%g\n", t.Volume)
+		fmt.Fprintf(tw, "open\t%g\n", t.Open)
+		fmt.Fprintf(tw, "close\t%g\n", t.Close)
+		fmt.Fprintf(tw, "status\t%s\n", dbm.StateName(t.State))
+		if t.Reason != "" {
+			fmt.Fprintf(tw, "reason\t%s\n", t.Reason)
+		}
+		fmt.Fprintf(tw, "queued\t%s\n", formatTime(t.CreatedAt))
+		fmt.Fprintf(tw, "processed\t%s\n", formatTime(t.ProcessedAt))
+		if t.RequestID != "" {
+			fmt.Fprintf(tw, "request id\t%s\n", t.RequestID)
+		}
+		return tw.Flush()
+
+	case "requeue":
+		id, err := tradeID(args)
+		if err != nil {
+			return err
+		}
+		switch err := dbm.RequeueTrade(db, id); {
+		case errors.Is(err, dbm.ErrTradeNotFound):
+			return fmt.Errorf("no trade with id %d", id)
+		case errors.Is(err, dbm.ErrNotRejected):
+			return fmt.Errorf("trade %d is not rejected; only rejected trades can be requeued", id)
+		case err != nil:
+			return fmt.Errorf("failed to requeue trade: %v", err)
+		}
+		fmt.Fprintf(stdout, "requeued trade %d\n", id)
+		return nil
+
+	case "cancel":
+		fs := flag.NewFlagSet("queue cancel", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		reason := fs.String("reason", "cancelled by operator", "reason recorded on the trade")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		id, err := tradeID(append([]string{"cancel"}, fs.Args()...))
+		if err != nil {
+			return err
+		}
+		switch err := dbm.RejectTrade(db, id, *reason); {
+		case errors.Is(err, dbm.ErrNotPending):
+			if _, err := dbm.GetTrade(db, id); errors.Is(err, dbm.ErrTradeNotFound) {
+				return fmt.Errorf("no trade with id %d", id)
+			}
+			return fmt.Errorf("trade %d is not pending", id)
+		case err != nil:
+			return fmt.Errorf("failed to cancel trade: %v", err)
+		}
+		fmt.Fprintf(stdout, "cancelled trade %d\n", id)
+		return nil
+
+	case "purge":
+		fs := flag.NewFlagSet("queue purge", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		days := fs.Int("days", 0, "purge trades processed or rejected more than this many days ago")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		if *days <= 0 {
+			return fmt.Errorf("%w: queue purge needs -days greater than 0", errUsage)
+		}
+		before := time.Now().AddDate(0, 0, -*days)
+		n, err := dbm.PurgeTrades(db, before, dbm.Trade.Profit)
+		if err != nil {
+			return fmt.Errorf("failed to purge trades: %v", err)
+		}
+		fmt.Fprintf(stdout, "purged %d trades finished before %s\n", n, before.UTC().Format(timeFormat))
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown queue subcommand %q", errUsage, args[0])
+}
+
+func runStats(db *sql.DB, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: stats needs a subcommand", errUsage)
+	}
+
+	switch args[0] {
+	case "show":
+		var stats []dbm.Stats
+		switch len(args) {
+		case 1:
+			var err error
+			if stats, err = dbm.ListStats(db); err != nil {
+				return fmt.Errorf("failed to list stats: %v", err)
+			}
+		case 2:
+			s, err := dbm.GetStats(db, args[1])
+			if err != nil {
+				return fmt.Errorf("failed to get stats: %v", err)
+			}
+			stats = []dbm.Stats{s}
+		default:
+			return fmt.Errorf("%w: stats show takes at most one account", errUsage)
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACCOUNT\tTRADES\tPROFIT")
+		for _, s := range stats {
+			fmt.Fprintf(tw, "%s\t%d\t%.2f\n", s.Account, s.Trades, s.Profit)
+		}
+		return tw.Flush()
+
+	case "reset":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: stats reset needs an account", errUsage)
+		}
+		s, err := dbm.ResetStats(db, args[1])
+		if err != nil {
+			return fmt.Errorf("failed to reset stats: %v", err)
+		}
+		fmt.Fprintf(stdout, "reset %s from trades=%d profit=%.2f\n", s.Account, s.Trades, s.Profit)
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown stats subcommand %q", errUsage, args[0])
+}
+
+// tradeID reads the trade id argument of a queue subcommand.
+func tradeID(args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("%w: queue %s needs a trade id", errUsage, args[0])
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: invalid trade id %q", errUsage, args[1])
+	}
+	return id, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeFormat)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func runWebhooks(db *sql.DB, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: webhooks needs a subcommand", errUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("webhooks create", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		rawURL := fs.String("url", "", "http or https URL to post events to")
+		events := fs.String("events", strings.Join(dbm.DefaultWebhookEvents, ","), "comma-separated event types")
+		accounts := fs.String("accounts", dbm.AllAccounts, `comma-separated accounts, or "*" for all`)
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		u, err := url.Parse(*rawURL)
+		if *rawURL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: webhooks create needs an http or https -url", errUsage)
+		}
+
+		w, err := dbm.CreateWebhook(db, *rawURL, splitFlag(*events), splitFlag(*accounts))
+		if err != nil {
+			return fmt.Errorf("failed to create webhook: %v", err)
+		}
+		fmt.Fprintf(stdout, "created webhook %d for %s; payloads are signed with:\n%s\n", w.ID, w.URL, w.Secret)
+		return nil
+
+	case "disable":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: webhooks disable needs a webhook id", errUsage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("%w: invalid webhook id %q", errUsage, args[1])
+		}
+		if err := dbm.DisableWebhook(db, id); errors.Is(err, dbm.ErrWebhookNotFound) {
+			return fmt.Errorf("no active webhook with id %d", id)
+		} else if err != nil {
+			return fmt.Errorf("failed to disable webhook: %v", err)
+		}
+		fmt.Fprintf(stdout, "disabled webhook %d\n", id)
+		return nil
+
+	case "list":
+		hooks, err := dbm.ListWebhooks(db)
+		if err != nil {
+			return fmt.Errorf("failed to list webhooks: %v", err)
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tURL\tEVENTS\tACCOUNTS\tCREATED\tDISABLED")
+		for _, w := range hooks {
+			disabled := "-"
+			if w.DisabledAt != nil {
+				disabled = w.DisabledAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", w.ID, w.URL,
+				strings.Join(w.Events, ","), strings.Join(w.Accounts, ","),
+				w.CreatedAt.Format("2006-01-02 15:04:05"), disabled)
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("%w: unknown webhooks subcommand %q", errUsage, args[0])
+}
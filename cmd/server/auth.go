@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/jwt"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Identity is the authenticated caller and what it may do.
+type Identity struct {
+	Subject     string
+	Accounts    []string
+	Permissions []string
+}
+
+// Allows reports whether the identity holds perm on account. The account
+// dbm.AllAccounts asks for access to every account at once.
+func (id *Identity) Allows(account, perm string) bool {
+	if !slices.Contains(id.Permissions, perm) {
+		return false
+	}
+	return slices.Contains(id.Accounts, dbm.AllAccounts) || (account != dbm.AllAccounts && slices.Contains(id.Accounts, account))
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Authenticator resolves the credentials of one scheme. It returns a nil
+// identity and nil error when the request carries no credentials it
+// understands, and ErrInvalidCredentials when it does but they don't check out.
+type Authenticator func(r *http.Request) (*Identity, error)
+
+// APIKeyAuthenticator accepts "Authorization: ApiKey <key>" checked against
+// the api_keys table.
+func APIKeyAuthenticator(db *sql.DB) Authenticator {
+	return func(r *http.Request) (*Identity, error) {
+		scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "ApiKey") {
+			return nil, nil
+		}
+		var k dbm.APIKey
+		err := tracing.DB(r.Context(), "LookupAPIKey", func() (err error) {
+			k, err = dbm.LookupAPIKey(db, strings.TrimSpace(key))
+			return err
+		})
+		if errors.Is(err, dbm.ErrAPIKeyNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Identity{
+			Subject:     "key:" + k.Name,
+			Accounts:    k.Accounts,
+			Permissions: k.Permissions,
+		}, nil
+	}
+}
+
+// JWTAuthenticator accepts "Authorization: Bearer <token>" verified by v.
+// Tokens grant read access to the accounts listed in accountsClaim.
+func JWTAuthenticator(v *jwt.Verifier, accountsClaim string) Authenticator {
+	return func(r *http.Request) (*Identity, error) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, nil
+		}
+		claims, err := v.Verify(strings.TrimSpace(token))
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+		return &Identity{
+			Subject:     "jwt:" + claims.String("sub"),
+			Accounts:    claims.Strings(accountsClaim),
+			Permissions: []string{dbm.PermRead},
+		}, nil
+	}
+}
+
+// LoadJWTKeys collects verification keys from a JWKS file, a PEM public key
+// and a file holding an HS256 secret; empty paths are skipped.
+func LoadJWTKeys(jwksPath, publicKeyPath, secretPath string) ([]jwt.Key, error) {
+	var keys []jwt.Key
+	if jwksPath != "" {
+		ks, err := jwt.LoadJWKS(jwksPath)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ks...)
+	}
+	if publicKeyPath != "" {
+		k, err := jwt.LoadPEM(publicKeyPath, "")
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if secretPath != "" {
+		b, err := os.ReadFile(secretPath)
+		if err != nil {
+			return nil, err
+		}
+		secret := bytes.TrimSpace(b)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("%s: empty secret", secretPath)
+		}
+		keys = append(keys, jwt.Key{Secret: secret})
+	}
+	return keys, nil
+}
+
+// RequireAuth rejects requests no authenticator accepts with 401 and requests
+// whose identity lacks the method's permission on every account with 403.
+// Handlers check the specific account with authorize. readAuthns only issue
+// read-only identities, so when authns is empty writes without credentials
+// are served unauthenticated rather than refused.
+func RequireAuth(authns, readAuthns []Authenticator, next http.Handler) http.Handler {
+	all := append(slices.Clip(authns), readAuthns...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := authenticate(r, all)
+		if errors.Is(err, ErrInvalidCredentials) {
+			unauthorized(w)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		perm := requiredPermission(r)
+		if id == nil && perm == dbm.PermWrite && len(authns) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if id == nil {
+			unauthorized(w)
+			return
+		}
+
+		if !slices.Contains(id.Permissions, perm) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// authenticate returns the identity from the first of authns that recognizes
+// r's credentials, or nil if none does.
+func authenticate(r *http.Request, authns []Authenticator) (*Identity, error) {
+	for _, authn := range authns {
+		id, err := authn(r)
+		if err != nil || id != nil {
+			return id, err
+		}
+	}
+	return nil, nil
+}
+
+func requiredPermission(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return dbm.PermRead
+	}
+	return dbm.PermWrite
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `ApiKey realm="broker"`)
+	w.Header().Add("WWW-Authenticate", `Bearer realm="broker"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// authorize writes 403 and returns false if the request's identity may not
+// use perm on account. Requests served without authentication are allowed.
+func authorize(w http.ResponseWriter, r *http.Request, account, perm string) bool {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok || id.Allows(account, perm) {
+		return true
+	}
+	http.Error(w, "forbidden", http.StatusForbidden)
+	return false
+}
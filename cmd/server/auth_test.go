@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/jwt"
+)
+
+func TestIdentityAllows(t *testing.T) {
+	id := &Identity{Accounts: []string{"acc1"}, Permissions: []string{dbm.PermRead}}
+	if !id.Allows("acc1", dbm.PermRead) {
+		t.Error("expected read on acc1")
+	}
+	if id.Allows("acc1", dbm.PermWrite) || id.Allows("acc2", dbm.PermRead) || id.Allows(dbm.AllAccounts, dbm.PermRead) {
+		t.Error("identity allowed more than its scope")
+	}
+	all := &Identity{Accounts: []string{dbm.AllAccounts}, Permissions: []string{dbm.PermWrite}}
+	if !all.Allows("acc9", dbm.PermWrite) || !all.Allows(dbm.AllAccounts, dbm.PermWrite) {
+		t.Error("expected wildcard identity to cover every account")
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	reader, _, err := dbm.CreateAPIKey(db, "reader", []string{"acc1"}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	writer, _, err := dbm.CreateAPIKey(db, "writer", []string{"acc1"}, []string{dbm.PermRead, dbm.PermWrite})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	revoked, k, _ := dbm.CreateAPIKey(db, "old", []string{"acc1"}, []string{dbm.PermRead})
+	dbm.RevokeAPIKey(db, k.ID)
+
+	srv := httptest.NewServer(SetupRouter(db, WithAuthenticators(APIKeyAuthenticator(db))))
+	defer srv.Close()
+
+	trade := `{"account":"acc1","symbol":"EURUSD","volume":1.0,"open":1.1,"close":1.2,"side":"buy"}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		auth   string
+		status int
+	}{
+		{"healthz is public", "GET", "/healthz", "", "", http.StatusOK},
+		{"missing key", "GET", "/stats/acc1", "", "", http.StatusUnauthorized},
+		{"unknown key", "GET", "/stats/acc1", "", "ApiKey bk_nope", http.StatusUnauthorized},
+		{"revoked key", "GET", "/stats/acc1", "", "ApiKey " + revoked, http.StatusUnauthorized},
+		{"other scheme", "GET", "/stats/acc1", "", "Basic Zm9vOmJhcg==", http.StatusUnauthorized},
+		{"read own account", "GET", "/stats/acc1", "", "ApiKey " + reader, http.StatusOK},
+		{"read other account", "GET", "/stats/acc2", "", "ApiKey " + reader, http.StatusForbidden},
+		{"write with read key", "POST", "/trades", trade, "ApiKey " + reader, http.StatusForbidden},
+		{"write own account", "POST", "/trades", trade, "ApiKey " + writer, http.StatusAccepted},
+		{"write other account", "POST", "/trades", strings.Replace(trade, "acc1", "acc2", 1), "ApiKey " + writer, http.StatusForbidden},
+		{"prices need all accounts", "POST", "/prices", `{"symbol":"EURUSD","bid":1,"ask":1,"timestamp":"2024-01-01T00:00:00Z"}`, "ApiKey " + writer, http.StatusForbidden},
+		{"list other account positions", "GET", "/positions?account=acc2", "", "ApiKey " + reader, http.StatusForbidden},
+		{"close unknown position", "POST", "/positions/nope/close", `{"close":1.2}`, "ApiKey " + writer, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tc.status {
+				t.Errorf("status = %d, want %d", res.StatusCode, tc.status)
+			}
+			if res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestClosePositionRequiresOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	dbm.EnqueuePositionEvent(db, dbm.PositionEvent{Position: "p1", Kind: dbm.PositionOpen, Account: "acc1", Symbol: "EURUSD", Side: "buy", Volume: 1, Price: 1.1})
+	other, _, _ := dbm.CreateAPIKey(db, "other", []string{"acc2"}, []string{dbm.PermWrite})
+	owner, _, _ := dbm.CreateAPIKey(db, "owner", []string{"acc1"}, []string{dbm.PermWrite})
+
+	router := SetupRouter(db, WithAuthenticators(APIKeyAuthenticator(db)))
+	for key, want := range map[string]int{other: http.StatusForbidden, owner: http.StatusAccepted} {
+		req := httptest.NewRequest("POST", "/positions/p1/close", strings.NewReader(`{"close":1.2}`))
+		req.Header.Set("Authorization", "ApiKey "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("close with key %s: status %d, want %d", key[:8], w.Code, want)
+		}
+	}
+}
+
+func hs256Token(secret []byte, claims map[string]any) string {
+	enc := base64.RawURLEncoding
+	h, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secretPath, []byte("portal-secret\n"), 0o600)
+	keys, err := LoadJWTKeys("", "", secretPath)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("LoadJWTKeys() = %v, %v", keys, err)
+	}
+	v := &jwt.Verifier{Keys: keys, Audience: "broker"}
+	router := SetupRouter(db, WithAuthenticators(APIKeyAuthenticator(db), JWTAuthenticator(v, "accounts")))
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	token := hs256Token([]byte("portal-secret"), map[string]any{"sub": "u1", "aud": "broker", "exp": exp, "accounts": []string{"acc1", "acc2"}})
+	wrongAud := hs256Token([]byte("portal-secret"), map[string]any{"sub": "u1", "aud": "other", "exp": exp, "accounts": []string{"acc1"}})
+	forged := hs256Token([]byte("guess"), map[string]any{"sub": "u1", "aud": "broker", "exp": exp, "accounts": []string{"acc1"}})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"permitted account", "GET", "/stats/acc2", token, http.StatusOK},
+		{"other account", "GET", "/stats/acc3", token, http.StatusForbidden},
+		{"tokens are read-only", "POST", "/trades", token, http.StatusForbidden},
+		{"wrong audience", "GET", "/stats/acc1", wrongAud, http.StatusUnauthorized},
+		{"forged signature", "GET", "/stats/acc1", forged, http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"account":"acc1"}`))
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+		})
+	}
+
+	if _, err := LoadJWTKeys(filepath.Join(t.TempDir(), "missing.json"), "", ""); err == nil {
+		t.Error("expected error for missing JWKS file")
+	}
+}
+
+// With bearer tokens as the only authentication, as for a read-only portal,
+// reads need a token and writes are served as without authentication.
+func TestJWTOnlyAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	v := &jwt.Verifier{Keys: []jwt.Key{{Secret: []byte("portal-secret")}}}
+	router := SetupRouter(db, WithReadAuthenticators(JWTAuthenticator(v, "accounts")))
+	token := hs256Token([]byte("portal-secret"), map[string]any{"sub": "u1", "exp": float64(time.Now().Add(time.Hour).Unix()), "accounts": []string{"acc1"}})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"trade without token", "POST", "/trades", "", http.StatusAccepted},
+		{"position without token", "POST", "/positions", "", http.StatusAccepted},
+		{"stats without token", "GET", "/stats/acc1", "", http.StatusUnauthorized},
+		{"stats with token", "GET", "/stats/acc1", token, http.StatusOK},
+		{"trade with read-only token", "POST", "/trades", token, http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"account":"acc1","symbol":"EURUSD","volume":1.0,"open":1.1,"close":1.2,"side":"buy"}`))
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tc.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, _, err := dbm.CreateAPIKey(db, "gw", []string{"acc1"}, []string{dbm.PermRead, dbm.PermWrite})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	key2, _, err := dbm.CreateAPIKey(db, "gw2", []string{"acc1"}, []string{dbm.PermRead, dbm.PermWrite})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	srv := httptest.NewServer(SetupRouter(db,
+		WithAuthenticators(APIKeyAuthenticator(db)),
+		WithSignedTrades(NewSignatureVerifier(map[string]string{"gw": "s3cret"}, time.Minute)),
+	))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL, client.WithAPIKey(key), client.WithSigning("gw", []byte("s3cret")))
+	if err := c.Health(ctx); err != nil {
+		t.Fatalf("Health failed: %v", err)
+	}
+
+	trade := client.Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}
+	keyed := client.WithIdempotencyKey(ctx, "order-1")
+	first, err := c.SubmitTrade(keyed, trade)
+	if err != nil {
+		t.Fatalf("SubmitTrade failed: %v", err)
+	}
+	again, err := c.SubmitTrade(keyed, trade)
+	if err != nil || again.ID != first.ID || !again.Replayed {
+		t.Errorf("resubmitted trade = %+v, %v; want replay of id %d", again, err, first.ID)
+	}
+	other := trade
+	other.Volume = 2
+	if _, err := c.SubmitTrade(keyed, other); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("reused key with another trade: err = %v, want ErrConflict", err)
+	}
+	// keys belong to the caller; another one may use the same key
+	c2 := client.New(srv.URL, client.WithAPIKey(key2), client.WithSigning("gw", []byte("s3cret")))
+	if own, err := c2.SubmitTrade(keyed, trade); err != nil || own.ID == first.ID || own.Replayed {
+		t.Errorf("same key from another caller = %+v, %v", own, err)
+	}
+
+	ids, err := c.SubmitBatch(ctx, []client.Trade{trade, trade})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("SubmitBatch() = %v, %v", ids, err)
+	}
+
+	st, err := c.GetTrade(ctx, ids[1])
+	if err != nil {
+		t.Fatalf("GetTrade failed: %v", err)
+	}
+	if st.ID != ids[1] || st.Status != client.StatusPending || st.Account != "acc1" {
+		t.Errorf("GetTrade() = %+v", st)
+	}
+	if _, err := c.GetTrade(ctx, 9999); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("unknown trade: err = %v, want ErrNotFound", err)
+	}
+
+	pos, err := c.SubmitTrade(ctx, client.Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Side: "buy"})
+	if err != nil || pos.Position == "" {
+		t.Errorf("opening a position = %+v, %v", pos, err)
+	}
+
+	if _, err := c.GetStats(ctx, "acc1"); err != nil {
+		t.Errorf("GetStats failed: %v", err)
+	}
+	if _, err := c.GetStats(ctx, "acc2"); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("other account: err = %v, want ErrForbidden", err)
+	}
+
+	unsigned := client.New(srv.URL, client.WithAPIKey(key))
+	if _, err := unsigned.SubmitTrade(ctx, trade); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("unsigned submission: err = %v, want ErrUnauthorized", err)
+	}
+}
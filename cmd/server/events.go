@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	maxEventWait      = 60 * time.Second
+)
+
+// EventResponse is one change from the event log. Offset increases with
+// every change and orders them as they were committed.
+type EventResponse struct {
+	Offset    int64           `json:"offset"`
+	Type      string          `json:"type"`
+	Account   string          `json:"account"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventsResponse is a page of the event log. Next is the after to pass for
+// the following page; it equals the request's after when nothing was new.
+type EventsResponse struct {
+	Events []EventResponse `json:"events"`
+	Next   int64           `json:"next"`
+}
+
+// HandleEvents serves GET /events?after=&limit=&account=&wait=, the changes
+// recorded after an offset. With wait, in seconds, a request that finds
+// nothing new is held until a change is recorded or the wait is over, so
+// consumers can tail the log without polling it in a tight loop. Without
+// account the caller must be able to read every account.
+func HandleEvents(w http.ResponseWriter, r *http.Request, db *sql.DB, hub *EventHub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	var after int64
+	if v := q.Get("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "after must be an offset", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	limit := defaultEventLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxEventLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxEventLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var wait time.Duration
+	if v := q.Get("wait"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > maxEventWait {
+			http.Error(w, "wait must be between 0 and "+strconv.Itoa(int(maxEventWait.Seconds()))+" seconds", http.StatusBadRequest)
+			return
+		}
+		wait = time.Duration(n) * time.Second
+	}
+	account := q.Get("account")
+	scope := account
+	if scope == "" {
+		scope = dbm.AllAccounts
+	}
+	if !authorize(w, r, scope, dbm.PermRead) {
+		return
+	}
+
+	list := func() ([]dbm.Event, error) {
+		var events []dbm.Event
+		err := tracing.DB(r.Context(), "ListEvents", func() (err error) {
+			events, err = dbm.ListEvents(db, after, account, limit)
+			return err
+		})
+		return events, err
+	}
+
+	events, err := list()
+	if err == nil && len(events) == 0 && wait > 0 {
+		events, err = awaitEvents(r, hub, account, wait, list)
+	}
+	if err != nil {
+		http.Error(w, "failed to list events", http.StatusInternalServerError)
+		return
+	}
+
+	resp := EventsResponse{Events: make([]EventResponse, len(events)), Next: after}
+	for i, ev := range events {
+		resp.Events[i] = EventResponse{
+			Offset:    ev.ID,
+			Type:      ev.Type,
+			Account:   ev.Account,
+			Payload:   ev.Payload,
+			CreatedAt: ev.CreatedAt,
+		}
+		resp.Next = ev.ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// awaitEvents lists events again once the hub sees one for account, or
+// returns nothing when wait is over or the client goes away.
+func awaitEvents(r *http.Request, hub *EventHub, account string, wait time.Duration, list func() ([]dbm.Event, error)) ([]dbm.Event, error) {
+	ch, cancel, err := hub.Subscribe(account)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// An event recorded before the subscription started is not announced.
+	if events, err := list(); err != nil || len(events) > 0 {
+		return events, err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return list()
+	case <-timer.C:
+		return nil, nil
+	case <-r.Context().Done():
+		return nil, nil
+	}
+}
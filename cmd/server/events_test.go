@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestEventLog(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	reader, _, err := dbm.CreateAPIKey(db, "crm", []string{"acc1"}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := dbm.CreateAPIKey(db, "cdc", []string{dbm.AllAccounts}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(SetupRouter(db,
+		WithAuthenticators(APIKeyAuthenticator(db)),
+		WithStreaming(5*time.Millisecond, time.Second),
+	))
+	t.Cleanup(srv.Close)
+
+	apply := func(acc string, profit float64) {
+		t.Helper()
+		ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{{Account: acc, Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: acc}, profit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(key, query string) (int, EventsResponse) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+"/events?"+query, nil)
+		req.Header.Set("Authorization", "ApiKey "+key)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var page EventsResponse
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode, page
+	}
+
+	apply("acc1", 10)
+	apply("acc2", 20)
+
+	if code, _ := get(reader, "after=0"); code != http.StatusForbidden {
+		t.Errorf("whole log with a single-account key: status %d, want 403", code)
+	}
+	code, page := get(reader, "account=acc1")
+	if code != http.StatusOK || len(page.Events) != 2 || page.Events[0].Type != dbm.EventTradeProcessed || page.Events[1].Type != dbm.EventStatsUpdated {
+		t.Fatalf("acc1 log = %d %+v", code, page)
+	}
+	var totals dbm.StatsChange
+	json.Unmarshal(page.Events[1].Payload, &totals)
+	if totals.Trades != 1 || totals.Profit != 10 {
+		t.Errorf("stats.updated payload = %+v", totals)
+	}
+
+	// Paging through the whole log resumes from next.
+	_, first := get(admin, "limit=3")
+	_, rest := get(admin, "after="+strconv.FormatInt(first.Next, 10))
+	if len(first.Events) != 3 || len(rest.Events) != 1 || rest.Events[0].Offset <= first.Next || rest.Events[0].Account != "acc2" {
+		t.Fatalf("pages = %+v then %+v", first, rest)
+	}
+	if _, empty := get(admin, "after="+strconv.FormatInt(rest.Next, 10)); len(empty.Events) != 0 || empty.Next != rest.Next {
+		t.Errorf("caught-up page = %+v, want no events and next %d", empty, rest.Next)
+	}
+
+	// A long poll is answered as soon as a change is recorded...
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}}, "", "")
+		if err == nil {
+			err = dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1"}, 5)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	start := time.Now()
+	_, woke := get(reader, "account=acc1&wait=10&after="+strconv.FormatInt(page.Next, 10))
+	if len(woke.Events) != 2 || woke.Events[0].Account != "acc1" || time.Since(start) > 5*time.Second {
+		t.Fatalf("long poll = %+v after %v", woke, time.Since(start))
+	}
+	// ...and otherwise ends empty once the wait is over.
+	start = time.Now()
+	_, timedOut := get(reader, "account=acc1&wait=1&after="+strconv.FormatInt(woke.Next, 10))
+	if len(timedOut.Events) != 0 || timedOut.Next != woke.Next || time.Since(start) < time.Second {
+		t.Errorf("idle long poll = %+v after %v", timedOut, time.Since(start))
+	}
+}
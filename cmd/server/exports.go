@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/export"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+// HandleExports serves GET /exports/trades?format=&account=&symbol=&status=&from=&to=
+// and GET /exports/stats?format=&account=&at=, which stream trades_q rows
+// and account totals as CSV, NDJSON or Parquet. Without account the caller
+// must be able to read every account.
+func HandleExports(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	table := strings.TrimPrefix(r.URL.Path, "/exports/")
+	if table != "trades" && table != "stats" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if export.ContentType(format) == "" {
+		http.Error(w, export.ErrFormat.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var run func(*exportWriter) (int, error)
+	switch table {
+	case "trades":
+		f := dbm.TradeFilter{Account: q.Get("account"), Symbol: q.Get("symbol")}
+		var err error
+		if v := q.Get("status"); v != "" {
+			if f.States, err = export.ParseStatuses(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("from"); v != "" {
+			if f.From, err = export.ParseTime(v); err != nil {
+				http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if f.To, err = export.ParseTime(v); err != nil {
+				http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		run = func(ew *exportWriter) (int, error) { return export.Trades(ew, format, db, f) }
+	case "stats":
+		f := dbm.StatsFilter{Account: q.Get("account")}
+		if v := q.Get("at"); v != "" {
+			var err error
+			if f.At, err = export.ParseTime(v); err != nil {
+				http.Error(w, "at: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		run = func(ew *exportWriter) (int, error) { return export.Stats(ew, format, db, f) }
+	}
+
+	scope := q.Get("account")
+	if scope == "" {
+		scope = dbm.AllAccounts
+	}
+	if !authorize(w, r, scope, dbm.PermRead) {
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+table+"."+format+`"`)
+	ew := &exportWriter{ResponseWriter: w}
+	var rows int
+	err := tracing.DB(r.Context(), "Export", func() (err error) {
+		rows, err = run(ew)
+		return err
+	})
+	if err == nil {
+		slog.Info("export finished", "table", table, "format", format, "rows", rows)
+		return
+	}
+	if !ew.written {
+		http.Error(w, "failed to export "+table, http.StatusInternalServerError)
+		return
+	}
+	// The status is sent; cut the response short so the client cannot
+	// mistake a partial file for a complete one.
+	slog.Error("export failed", "table", table, "format", format, "rows", rows, "err", err)
+	panic(http.ErrAbortHandler)
+}
+
+// exportWriter records whether any of the export reached the client.
+type exportWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.written = true
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestExports(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	reader, _, err := dbm.CreateAPIKey(db, "finance", []string{"acc1"}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := dbm.CreateAPIKey(db, "finance-all", []string{dbm.AllAccounts}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRouter(db, WithAuthenticators(APIKeyAuthenticator(db)))
+
+	ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc1", Symbol: "GBPUSD", Volume: 2, Open: 1.3, Close: 1.2, Side: "sell"},
+		{Account: "acc2", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1"}, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(key, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "ApiKey "+key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(reader, "/exports/trades?account=acc1")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" ||
+		rr.Header().Get("Content-Disposition") != `attachment; filename="trades.csv"` {
+		t.Fatalf("CSV export: status %d, headers %v: %s", rr.Code, rr.Header(), rr.Body.String())
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 3 || records[1][7] != "processed" || records[2][7] != "pending" {
+		t.Errorf("CSV export = %q, %v", records, err)
+	}
+
+	rr = get(reader, "/exports/trades?account=acc1&format=ndjson&status=processed&symbol=EURUSD&from=2020-01-01")
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	var row map[string]any
+	if rr.Code != http.StatusOK || len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &row) != nil || row["id"] != float64(ids[0]) {
+		t.Errorf("NDJSON export: status %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := get(reader, "/exports/trades?account=acc1&format=ndjson&to=2020-01-01"); rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Errorf("export before any trade: status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = get(admin, "/exports/stats?format=parquet")
+	if rr.Code != http.StatusOK || !bytes.HasPrefix(rr.Body.Bytes(), []byte("PAR1")) || rr.Header().Get("Content-Type") != "application/vnd.apache.parquet" {
+		t.Errorf("Parquet stats export: status %d, %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	for _, tt := range []struct {
+		key, path string
+		status    int
+	}{
+		{reader, "/exports/trades", http.StatusForbidden},
+		{reader, "/exports/stats?account=acc2", http.StatusForbidden},
+		{admin, "/exports/trades?format=xlsx", http.StatusBadRequest},
+		{admin, "/exports/trades?status=dead", http.StatusBadRequest},
+		{admin, "/exports/trades?from=last+month", http.StatusBadRequest},
+		{admin, "/exports/stats?at=yesterday", http.StatusBadRequest},
+		{admin, "/exports/positions", http.StatusNotFound},
+	} {
+		if rr := get(tt.key, tt.path); rr.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d: %s", tt.path, rr.Code, tt.status, rr.Body.String())
+		}
+	}
+}
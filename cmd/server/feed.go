@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+const feedWriteTimeout = 10 * time.Second
+
+// FeedRequest is a message from a /trades/feed client. "subscribe" replaces
+// the filter; empty lists match every symbol and every account the caller
+// may read. With After set, trades processed after that offset, 0 for all,
+// are sent first. "unsubscribe" stops the feed until the next subscribe.
+type FeedRequest struct {
+	Type     string   `json:"type"`
+	Accounts []string `json:"accounts,omitempty"`
+	Symbols  []string `json:"symbols,omitempty"`
+	After    *int64   `json:"after,omitempty"`
+}
+
+// FeedMessage is a message to a /trades/feed client: a processed trade, the
+// acknowledgement of a request, or an error.
+type FeedMessage struct {
+	Type string `json:"type"`
+	*FeedTrade
+	Accounts []string `json:"accounts,omitempty"`
+	Symbols  []string `json:"symbols,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// FeedTrade is a trade the worker processed. Offset is the position of the
+// event in the events table, for resubscribing with After.
+type FeedTrade struct {
+	Offset      int64     `json:"offset"`
+	ID          int       `json:"id"`
+	Account     string    `json:"account"`
+	Symbol      string    `json:"symbol"`
+	Side        string    `json:"side"`
+	Volume      float64   `json:"volume"`
+	Profit      float64   `json:"profit"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// feedFilter selects the trades a feed client receives.
+type feedFilter struct {
+	active   bool
+	accounts []string
+	symbols  []string
+	// readable limits an empty account list for callers that may not read
+	// every account; nil means no limit.
+	readable []string
+}
+
+func (f *feedFilter) match(t dbm.TradeEvent) bool {
+	if !f.active {
+		return false
+	}
+	accounts := f.accounts
+	if len(accounts) == 0 {
+		accounts = f.readable
+	}
+	if accounts != nil && !slices.Contains(accounts, t.Account) {
+		return false
+	}
+	return len(f.symbols) == 0 || slices.Contains(f.symbols, t.Symbol)
+}
+
+// HandleTradeFeed serves GET /trades/feed, a WebSocket tape of trades as
+// the worker processes them. Clients choose what they get with FeedRequest
+// messages; nothing is sent before the first subscribe. The connection is
+// closed with StatusTryAgainLater if the client can't keep up; it should
+// resubscribe with the last offset it received.
+func HandleTradeFeed(w http.ResponseWriter, r *http.Request, db *sql.DB, hub *EventHub, heartbeat time.Duration) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	events, unsubscribe, err := hub.Subscribe("")
+	if err != nil {
+		conn.Close(websocket.StatusInternalError, "failed to subscribe")
+		return
+	}
+	defer unsubscribe()
+
+	requests := make(chan FeedRequest)
+	go func() {
+		defer cancel()
+		for {
+			var req FeedRequest
+			if err := wsjson.Read(ctx, conn, &req); err != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	send := func(m FeedMessage) error {
+		ctx, cancel := context.WithTimeout(ctx, feedWriteTimeout)
+		defer cancel()
+		return wsjson.Write(ctx, conn, m)
+	}
+
+	var filter feedFilter
+	var sent int64
+	// deliver sends ev if it is a processed trade the filter matches.
+	deliver := func(ev dbm.Event) error {
+		if ev.ID <= sent {
+			return nil
+		}
+		sent = ev.ID
+		if ev.Type != dbm.EventTradeProcessed {
+			return nil
+		}
+		var t dbm.TradeEvent
+		if err := json.Unmarshal(ev.Payload, &t); err != nil {
+			return err
+		}
+		if !filter.match(t) {
+			return nil
+		}
+		return send(FeedMessage{Type: "trade", FeedTrade: &FeedTrade{
+			Offset:      ev.ID,
+			ID:          t.ID,
+			Account:     t.Account,
+			Symbol:      t.Symbol,
+			Side:        t.Side,
+			Volume:      t.Volume,
+			Profit:      t.Profit,
+			ProcessedAt: t.ProcessedAt,
+		}})
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			pctx, pcancel := context.WithTimeout(ctx, feedWriteTimeout)
+			err := conn.Ping(pctx)
+			pcancel()
+			if err != nil {
+				return
+			}
+
+		case req := <-requests:
+			switch req.Type {
+			case "subscribe":
+				next, err := feedSubscription(r, req)
+				if err != nil {
+					if send(FeedMessage{Type: "error", Error: err.Error()}) != nil {
+						return
+					}
+					continue
+				}
+				filter = next
+				if err := send(FeedMessage{Type: "subscribed", Accounts: req.Accounts, Symbols: req.Symbols}); err != nil {
+					return
+				}
+				if req.After != nil {
+					if err := replayFeed(db, *req.After, &sent, deliver); err != nil {
+						conn.Close(websocket.StatusInternalError, "failed to read events")
+						return
+					}
+				}
+			case "unsubscribe":
+				filter = feedFilter{}
+				if err := send(FeedMessage{Type: "unsubscribed"}); err != nil {
+					return
+				}
+			default:
+				if err := send(FeedMessage{Type: "error", Error: "unknown message type " + req.Type}); err != nil {
+					return
+				}
+			}
+
+		case ev, ok := <-events:
+			if !ok {
+				conn.Close(websocket.StatusTryAgainLater, "client too slow")
+				return
+			}
+			if err := deliver(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// feedSubscription checks req against the caller's identity.
+func feedSubscription(r *http.Request, req FeedRequest) (feedFilter, error) {
+	f := feedFilter{active: true, accounts: req.Accounts, symbols: req.Symbols}
+	id, ok := IdentityFromContext(r.Context())
+	if !ok {
+		return f, nil
+	}
+	for _, acc := range req.Accounts {
+		if !id.Allows(acc, dbm.PermRead) {
+			return feedFilter{}, errors.New("forbidden: " + acc)
+		}
+	}
+	if !id.Allows(dbm.AllAccounts, dbm.PermRead) {
+		f.readable = id.Accounts
+		if f.readable == nil {
+			f.readable = []string{}
+		}
+	}
+	return f, nil
+}
+
+// replayFeed delivers the events recorded after the given offset, picking
+// up where a reconnecting client left off. sent is rewound so events the
+// connection already saw under an older filter are considered again.
+func replayFeed(db *sql.DB, after int64, sent *int64, deliver func(dbm.Event) error) error {
+	live := *sent
+	*sent = after
+	for {
+		events, err := dbm.ListEvents(db, *sent, "", hubBatch)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			if err := deliver(ev); err != nil {
+				return err
+			}
+		}
+		if len(events) < hubBatch {
+			break
+		}
+	}
+	*sent = max(*sent, live)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestTradeFeed(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	reader, _, err := dbm.CreateAPIKey(db, "ui", []string{"acc1", "acc2"}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	srv := httptest.NewServer(SetupRouter(db,
+		WithAuthenticators(APIKeyAuthenticator(db)),
+		WithStreaming(5*time.Millisecond, time.Second),
+	))
+	t.Cleanup(srv.Close)
+
+	process := func(acc, symbol string, profit float64) {
+		t.Helper()
+		tr := dbm.Trade{Account: acc, Symbol: symbol, Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}
+		ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{tr}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.ID = ids[0]
+		if err := dbm.ApplyTrade(db, tr, profit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/trades/feed"
+	if _, _, err := websocket.Dial(ctx, url, nil); err == nil {
+		t.Fatal("dialed without credentials")
+	}
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {"ApiKey " + reader}},
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+
+	read := func() FeedMessage {
+		t.Helper()
+		var m FeedMessage
+		if err := wsjson.Read(ctx, conn, &m); err != nil {
+			t.Fatalf("reading feed: %v", err)
+		}
+		return m
+	}
+	request := func(req FeedRequest) {
+		t.Helper()
+		if err := wsjson.Write(ctx, conn, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request(FeedRequest{Type: "subscribe", Accounts: []string{"acc3"}})
+	if m := read(); m.Type != "error" || !strings.Contains(m.Error, "acc3") {
+		t.Errorf("subscribing to an unreadable account = %+v, want error", m)
+	}
+
+	request(FeedRequest{Type: "subscribe", Symbols: []string{"EURUSD"}})
+	if m := read(); m.Type != "subscribed" {
+		t.Fatalf("subscribe = %+v", m)
+	}
+	process("acc3", "EURUSD", 1) // not readable by the key
+	process("acc1", "GBPUSD", 2) // filtered by symbol
+	process("acc2", "EURUSD", 0)
+	m := read()
+	if m.Type != "trade" || m.FeedTrade == nil || m.Account != "acc2" || m.Symbol != "EURUSD" || m.ProcessedAt.IsZero() {
+		t.Fatalf("first trade = %+v", m)
+	}
+	first := *m.FeedTrade
+
+	// Resubscribing from the start replays what the new filter matches.
+	after := int64(0)
+	request(FeedRequest{Type: "subscribe", Accounts: []string{"acc1"}, After: &after})
+	if m := read(); m.Type != "subscribed" {
+		t.Fatalf("resubscribe = %+v", m)
+	}
+	if m := read(); m.Type != "trade" || m.Account != "acc1" || m.Symbol != "GBPUSD" || m.Profit != 2 || m.Offset >= first.Offset {
+		t.Errorf("replayed trade = %+v", m)
+	}
+	process("acc1", "EURUSD", 3)
+	if m := read(); m.Type != "trade" || m.Account != "acc1" || m.Profit != 3 || m.Offset <= first.Offset {
+		t.Errorf("live trade after replay = %+v", m)
+	}
+
+	request(FeedRequest{Type: "unsubscribe"})
+	if m := read(); m.Type != "unsubscribed" {
+		t.Errorf("unsubscribe = %+v", m)
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/fix"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+// FIXTagOpenPx is the user-defined tag in which liquidity providers report
+// the opening price of the position a fill closes. LastPx is the close.
+const FIXTagOpenPx = 7001
+
+const (
+	defaultFIXLogonTimeout = 10 * time.Second
+	fixWriteTimeout        = 10 * time.Second
+	// maxFIXHeartBtInt bounds the HeartBtInt(108) a Logon may ask for, in
+	// seconds, well below where the heartbeat interval would overflow.
+	maxFIXHeartBtInt = 3600
+)
+
+var errFIXLogout = errors.New("session logged out")
+
+// FIXAcceptor accepts FIX 4.4 sessions from the counterparties listed in
+// Sessions, keyed by their SenderCompID, and queues the fills they report
+// in ExecutionReports as trades. A Logon must carry the counterparty's
+// Password(554), or come over TLS with a verified client certificate whose
+// common name is the SenderCompID. Sequence numbers are stored in the database
+// after every message, so a session resumes where it stopped across
+// reconnects and restarts. Nothing sent to a counterparty needs replaying,
+// so resend requests are answered with a gap fill.
+type FIXAcceptor struct {
+	DB           *sql.DB
+	CompID       string
+	Sessions     map[string]FIXCounterparty
+	LogonTimeout time.Duration
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+// FIXCounterparty is what a FIX counterparty may do and the password its
+// Logon carries; without one it must log on with a client certificate.
+type FIXCounterparty struct {
+	ClientIdentity
+	Password string `json:"password"`
+}
+
+// LoadFIXSessions reads a JSON object mapping counterparty SenderCompIDs to
+// their accounts, permissions and password.
+func LoadFIXSessions(path string) (map[string]FIXCounterparty, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sessions map[string]FIXCounterparty
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		return nil, fmt.Errorf("invalid FIX sessions file %s: %v", path, err)
+	}
+	return sessions, nil
+}
+
+func NewFIXAcceptor(db *sql.DB, compID string, sessions map[string]FIXCounterparty) *FIXAcceptor {
+	return &FIXAcceptor{
+		DB:           db,
+		CompID:       compID,
+		Sessions:     sessions,
+		LogonTimeout: defaultFIXLogonTimeout,
+		active:       make(map[string]bool),
+	}
+}
+
+// Serve accepts connections on l until it is closed.
+func (a *FIXAcceptor) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *FIXAcceptor) serveConn(conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+
+	// Messages are read on their own goroutine so the session can keep
+	// heartbeats going while it waits.
+	msgs := make(chan fix.Message)
+	readErr := make(chan error, 1)
+	go func() {
+		r := fix.NewReader(conn)
+		for {
+			m, err := r.Read()
+			if errors.Is(err, fix.ErrGarbled) {
+				slog.Warn("ignoring garbled FIX message", "remote", conn.RemoteAddr(), "err", err)
+				continue
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case msgs <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var s *fixSession
+	timer := time.NewTimer(a.LogonTimeout)
+	select {
+	case m := <-msgs:
+		timer.Stop()
+		var err error
+		if s, err = a.logon(conn, m); err != nil {
+			slog.Warn("FIX logon refused", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+	case err := <-readErr:
+		slog.Debug("FIX connection closed before logon", "remote", conn.RemoteAddr(), "err", err)
+		return
+	case <-timer.C:
+		slog.Warn("no FIX logon", "remote", conn.RemoteAddr())
+		return
+	}
+	defer a.release(s.state.Remote)
+	slog.Info("FIX session logged on", "session", s.state.Remote, "remote", conn.RemoteAddr(), "next_in", s.state.NextIn, "next_out", s.state.NextOut)
+
+	err := s.run(msgs, readErr)
+	if errors.Is(err, errFIXLogout) {
+		slog.Info("FIX session logged out", "session", s.state.Remote)
+	} else {
+		slog.Warn("FIX session ended", "session", s.state.Remote, "err", err)
+	}
+}
+
+// logon checks the counterparty's Logon and answers it.
+func (a *FIXAcceptor) logon(conn net.Conn, m fix.Message) (*fixSession, error) {
+	if m.Type() != fix.MsgLogon {
+		return nil, fmt.Errorf("first message is %q, not a Logon", m.Type())
+	}
+	remote := m.Get(fix.TagSenderCompID)
+	ident, ok := a.Sessions[remote]
+	if !ok {
+		return nil, fmt.Errorf("unknown SenderCompID %q", remote)
+	}
+	if !fixAuthenticated(conn, remote, ident, m) {
+		return nil, fmt.Errorf("%s sent no valid password or client certificate", remote)
+	}
+	if target := m.Get(fix.TagTargetCompID); target != a.CompID {
+		return nil, fmt.Errorf("TargetCompID %q is not %q", target, a.CompID)
+	}
+	seq, err := m.Int(fix.TagMsgSeqNum)
+	if err != nil {
+		return nil, err
+	}
+	hb, err := m.Int(fix.TagHeartBtInt)
+	if err != nil || hb <= 0 || hb > maxFIXHeartBtInt {
+		return nil, fmt.Errorf("invalid HeartBtInt %q", m.Get(fix.TagHeartBtInt))
+	}
+	if e := m.Get(fix.TagEncryptMethod); e != "" && e != "0" {
+		return nil, fmt.Errorf("unsupported EncryptMethod %q", e)
+	}
+	if !a.acquire(remote) {
+		return nil, fmt.Errorf("%s is already logged on", remote)
+	}
+
+	state, err := dbm.GetFIXSession(a.DB, a.CompID, remote)
+	if err != nil {
+		a.release(remote)
+		return nil, fmt.Errorf("failed to load session: %v", err)
+	}
+	reset := m.Bool(fix.TagResetSeqNumFlag)
+	if reset {
+		state.NextIn, state.NextOut = 1, 1
+	}
+	now := time.Now()
+	s := &fixSession{
+		conn:     conn,
+		db:       a.DB,
+		identity: &Identity{Subject: "fix:" + remote, Accounts: ident.Accounts, Permissions: ident.Permissions},
+		state:    state,
+		hb:       time.Duration(hb) * time.Second,
+		lastSent: now,
+		lastRecv: now,
+	}
+
+	if seq < state.NextIn {
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", state.NextIn, seq))
+		a.release(remote)
+		return nil, fmt.Errorf("logon MsgSeqNum %d is below %d", seq, state.NextIn)
+	}
+	reply := s.message(fix.MsgLogon)
+	reply.Set(fix.TagEncryptMethod, "0")
+	reply.SetInt(fix.TagHeartBtInt, hb)
+	if reset {
+		reply.Set(fix.TagResetSeqNumFlag, "Y")
+	}
+	err = s.send(reply)
+	if err == nil && seq > state.NextIn {
+		err = s.requestResend()
+	} else if err == nil {
+		err = s.received(seq, seq+1)
+	}
+	if err != nil {
+		a.release(remote)
+		return nil, err
+	}
+	return s, nil
+}
+
+// fixAuthenticated reports whether a Logon from remote is proven by a
+// verified client certificate issued to remote or by cp's password.
+func fixAuthenticated(conn net.Conn, remote string, cp FIXCounterparty, m fix.Message) bool {
+	if tc, ok := conn.(*tls.Conn); ok {
+		chains := tc.ConnectionState().VerifiedChains
+		if len(chains) > 0 && chains[0][0].Subject.CommonName == remote {
+			return true
+		}
+	}
+	return cp.Password != "" && subtle.ConstantTimeCompare([]byte(m.Get(fix.TagPassword)), []byte(cp.Password)) == 1
+}
+
+func (a *FIXAcceptor) acquire(remote string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active[remote] {
+		return false
+	}
+	a.active[remote] = true
+	return true
+}
+
+func (a *FIXAcceptor) release(remote string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.active, remote)
+}
+
+// fixSession is a logged on session. It is only used from the goroutine
+// serving its connection.
+type fixSession struct {
+	conn     net.Conn
+	db       *sql.DB
+	identity *Identity
+	state    dbm.FIXSession
+	hb       time.Duration
+
+	lastSent, lastRecv time.Time
+	// testReqSent is when a TestRequest went unanswered so far.
+	testReqSent time.Time
+	// resending is set while a ResendRequest is outstanding.
+	resending bool
+}
+
+func (s *fixSession) run(msgs <-chan fix.Message, readErr <-chan error) error {
+	ticker := time.NewTicker(s.hb / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-msgs:
+			s.lastRecv = time.Now()
+			s.testReqSent = time.Time{}
+			if err := s.handle(m); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case now := <-ticker.C:
+			if err := s.heartbeat(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// heartbeat sends a Heartbeat when the line has been quiet for an interval,
+// and probes a silent counterparty with a TestRequest before giving up.
+func (s *fixSession) heartbeat(now time.Time) error {
+	if now.Sub(s.lastSent) >= s.hb {
+		if err := s.send(s.message(fix.MsgHeartbeat)); err != nil {
+			return err
+		}
+	}
+	if now.Sub(s.lastRecv) <= s.hb+s.hb/5 {
+		return nil
+	}
+	if s.testReqSent.IsZero() {
+		s.testReqSent = now
+		m := s.message(fix.MsgTestRequest)
+		m.Set(fix.TagTestReqID, strconv.FormatInt(now.UnixNano(), 10))
+		return s.send(m)
+	}
+	if now.Sub(s.testReqSent) > s.hb {
+		s.logout("heartbeat timeout")
+		return errors.New("no answer to TestRequest")
+	}
+	return nil
+}
+
+func (s *fixSession) handle(m fix.Message) error {
+	if m.Get(fix.TagSenderCompID) != s.state.Remote || m.Get(fix.TagTargetCompID) != s.state.Local {
+		s.logout("CompID problem")
+		return fmt.Errorf("message for %s from %s", m.Get(fix.TagTargetCompID), m.Get(fix.TagSenderCompID))
+	}
+	seq, err := m.Int(fix.TagMsgSeqNum)
+	if err != nil {
+		s.logout("MsgSeqNum missing")
+		return err
+	}
+
+	// A SequenceReset without GapFillFlag moves the expected number
+	// whatever MsgSeqNum it carries.
+	if m.Type() == fix.MsgSequenceReset && !m.Bool(fix.TagGapFillFlag) {
+		next, err := m.Int(fix.TagNewSeqNo)
+		if err != nil || next < s.state.NextIn {
+			return s.reject(seq, "NewSeqNo must not decrease the expected MsgSeqNum")
+		}
+		s.resending = false
+		s.state.NextIn = next
+		return s.save()
+	}
+
+	switch {
+	case seq > s.state.NextIn:
+		// Later messages are dropped until the gap is resent; a resend
+		// request from the counterparty is answered meanwhile.
+		if m.Type() == fix.MsgResendRequest {
+			if err := s.resend(m); err != nil {
+				return err
+			}
+		}
+		if s.resending {
+			return nil
+		}
+		return s.requestResend()
+	case seq < s.state.NextIn:
+		if m.Bool(fix.TagPossDupFlag) {
+			return nil
+		}
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.state.NextIn, seq))
+		return fmt.Errorf("MsgSeqNum %d is below %d", seq, s.state.NextIn)
+	}
+	s.resending = false
+
+	next := seq + 1
+	switch m.Type() {
+	case fix.MsgHeartbeat, fix.MsgReject:
+		if m.Type() == fix.MsgReject {
+			slog.Warn("FIX message rejected by counterparty", "session", s.state.Remote, "ref_seq", m.Get(fix.TagRefSeqNum), "text", m.Get(fix.TagText))
+		}
+	case fix.MsgTestRequest:
+		hb := s.message(fix.MsgHeartbeat)
+		hb.Set(fix.TagTestReqID, m.Get(fix.TagTestReqID))
+		err = s.send(hb)
+	case fix.MsgResendRequest:
+		err = s.resend(m)
+	case fix.MsgSequenceReset:
+		if n, nerr := m.Int(fix.TagNewSeqNo); nerr == nil && n > next {
+			next = n
+		}
+	case fix.MsgLogout:
+		if err := s.received(seq, next); err != nil {
+			return err
+		}
+		s.send(s.message(fix.MsgLogout))
+		return errFIXLogout
+	case fix.MsgLogon:
+		err = s.reject(seq, "already logged on")
+	case fix.MsgExecutionReport:
+		err = s.executionReport(m, seq)
+	default:
+		err = s.businessReject(m, seq, 3, "", "unsupported message type")
+	}
+	if err != nil {
+		return err
+	}
+	return s.received(seq, next)
+}
+
+// executionReport queues a fill. Fills are keyed by ExecID, so one the
+// counterparty sends again is not queued twice. Only a failure to store
+// it ends the session: the message is then not counted as received, and
+// the counterparty resends it after logging on again.
+func (s *fixSession) executionReport(m fix.Message, seq int) error {
+	if m.Get(fix.TagExecType) != "F" {
+		return nil
+	}
+	execID := m.Get(fix.TagExecID)
+	if execID == "" {
+		return s.businessReject(m, seq, 5, "", "ExecID missing")
+	}
+	req, err := fixTradeRequest(m)
+	if err == nil {
+		err = ValidateTradeRequest(req)
+	}
+	if err != nil {
+		return s.businessReject(m, seq, 0, execID, err.Error())
+	}
+	if !s.identity.Allows(req.Account, dbm.PermWrite) {
+		return s.businessReject(m, seq, 6, execID, "not authorized for account "+req.Account)
+	}
+
+	key := "fix:" + s.state.Remote + ":" + execID
+	ids, replayed, err := queueTrades(context.Background(), s.db, []TradeRequest{req}, key)
+	if errors.Is(err, dbm.ErrIdempotencyConflict) {
+		return s.businessReject(m, seq, 0, execID, "ExecID was used for a different fill")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enqueue fill %s: %v", execID, err)
+	}
+	slog.Debug("queued FIX fill", "session", s.state.Remote, "exec_id", execID, "trade", ids[0], "replayed", replayed)
+	return nil
+}
+
+// fixTradeRequest maps a fill to a trade. LastQty is in units of the base
+// currency and is converted to lots; a symbol may be written EUR/USD.
+func fixTradeRequest(m fix.Message) (TradeRequest, error) {
+	req := TradeRequest{
+		Account: m.Get(fix.TagAccount),
+		Symbol:  strings.ReplaceAll(m.Get(fix.TagSymbol), "/", ""),
+	}
+	switch side := m.Get(fix.TagSide); side {
+	case "1":
+		req.Side = trade.Buy
+	case "2":
+		req.Side = trade.Sell
+	default:
+		return req, fmt.Errorf("%w: unsupported Side %q", trade.ErrInvalid, side)
+	}
+	qty, err := m.Float(fix.TagLastQty)
+	if err != nil {
+		return req, fmt.Errorf("%w: %v", trade.ErrInvalid, err)
+	}
+	req.Volume = qty / trade.Lot
+	if req.Open, err = m.Float(FIXTagOpenPx); err != nil {
+		return req, fmt.Errorf("%w: %v", trade.ErrInvalid, err)
+	}
+	if req.Close, err = m.Float(fix.TagLastPx); err != nil {
+		return req, fmt.Errorf("%w: %v", trade.ErrInvalid, err)
+	}
+	return req, nil
+}
+
+// requestResend asks for every message from the expected one on.
+func (s *fixSession) requestResend() error {
+	s.resending = true
+	rr := s.message(fix.MsgResendRequest)
+	rr.SetInt(fix.TagBeginSeqNo, s.state.NextIn)
+	rr.SetInt(fix.TagEndSeqNo, 0)
+	return s.send(rr)
+}
+
+// resend answers a ResendRequest by filling the whole range with one
+// SequenceReset: nothing the acceptor sends has to be delivered again.
+func (s *fixSession) resend(m fix.Message) error {
+	begin, err := m.Int(fix.TagBeginSeqNo)
+	if err != nil || begin < 1 || begin >= s.state.NextOut {
+		return nil
+	}
+	gf := s.message(fix.MsgSequenceReset)
+	gf.Set(fix.TagPossDupFlag, "Y")
+	gf.SetTime(fix.TagOrigSendingTime, time.Now())
+	gf.Set(fix.TagGapFillFlag, "Y")
+	gf.SetInt(fix.TagNewSeqNo, s.state.NextOut)
+	return s.write(gf, begin)
+}
+
+// reject sends a session level Reject of message seq.
+func (s *fixSession) reject(seq int, text string) error {
+	r := s.message(fix.MsgReject)
+	r.SetInt(fix.TagRefSeqNum, seq)
+	r.Set(fix.TagText, text)
+	return s.send(r)
+}
+
+// businessReject refuses an application message; reason is a
+// BusinessRejectReason and refID, when set, the id of what was refused.
+func (s *fixSession) businessReject(m fix.Message, seq, reason int, refID, text string) error {
+	slog.Warn("FIX message refused", "session", s.state.Remote, "seq", seq, "type", m.Type(), "ref_id", refID, "reason", text)
+	r := s.message(fix.MsgBusinessMessageReject)
+	r.SetInt(fix.TagRefSeqNum, seq)
+	r.Set(fix.TagRefMsgType, m.Type())
+	if refID != "" {
+		r.Set(fix.TagBusinessRejectRefID, refID)
+	}
+	r.SetInt(fix.TagBusinessRejectReason, reason)
+	r.Set(fix.TagText, text)
+	return s.send(r)
+}
+
+// logout tells the counterparty why the session is about to end.
+func (s *fixSession) logout(text string) {
+	m := s.message(fix.MsgLogout)
+	m.Set(fix.TagText, text)
+	if err := s.send(m); err != nil {
+		slog.Debug("sending FIX logout", "session", s.state.Remote, "err", err)
+	}
+}
+
+// message starts a message with the standard header, whose MsgSeqNum and
+// SendingTime are filled in when it is written.
+func (s *fixSession) message(typ string) fix.Message {
+	m := fix.New(typ)
+	m.Set(fix.TagSenderCompID, s.state.Local)
+	m.Set(fix.TagTargetCompID, s.state.Remote)
+	m.Set(fix.TagMsgSeqNum, "")
+	m.Set(fix.TagSendingTime, "")
+	return m
+}
+
+// send writes m with the next outgoing sequence number.
+func (s *fixSession) send(m fix.Message) error {
+	if err := s.write(m, s.state.NextOut); err != nil {
+		return err
+	}
+	s.state.NextOut++
+	return s.save()
+}
+
+func (s *fixSession) write(m fix.Message, seq int) error {
+	now := time.Now()
+	m.SetInt(fix.TagMsgSeqNum, seq)
+	m.SetTime(fix.TagSendingTime, now)
+	s.conn.SetWriteDeadline(now.Add(fixWriteTimeout))
+	if _, err := s.conn.Write(m.Bytes()); err != nil {
+		return err
+	}
+	s.lastSent = now
+	return nil
+}
+
+// received records message seq as processed; next is the number expected
+// after it.
+func (s *fixSession) received(seq, next int) error {
+	if seq != s.state.NextIn {
+		return nil
+	}
+	s.state.NextIn = next
+	return s.save()
+}
+
+func (s *fixSession) save() error {
+	if err := dbm.SaveFIXSession(s.db, s.state); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/fix"
+)
+
+// fixInitiator is the counterparty side of a FIX session.
+type fixInitiator struct {
+	t    *testing.T
+	conn net.Conn
+	r    *fix.Reader
+	seq  int
+}
+
+func dialFIX(t *testing.T, addr string, seq int) *fixInitiator {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &fixInitiator{t: t, conn: conn, r: fix.NewReader(conn), seq: seq}
+}
+
+func (c *fixInitiator) message(typ string) fix.Message {
+	m := fix.New(typ)
+	m.Set(fix.TagSenderCompID, "LP1")
+	m.Set(fix.TagTargetCompID, "BROKER")
+	m.Set(fix.TagMsgSeqNum, "")
+	m.SetTime(fix.TagSendingTime, time.Now())
+	return m
+}
+
+// send writes m with the next sequence number.
+func (c *fixInitiator) send(m fix.Message) {
+	c.t.Helper()
+	c.sendAt(m, c.seq)
+	c.seq++
+}
+
+func (c *fixInitiator) sendAt(m fix.Message, seq int) {
+	c.t.Helper()
+	m.SetInt(fix.TagMsgSeqNum, seq)
+	if _, err := c.conn.Write(m.Bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *fixInitiator) read() fix.Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := c.r.Read()
+	if err != nil {
+		c.t.Fatalf("reading from acceptor: %v", err)
+	}
+	return m
+}
+
+// expect reads the next message and checks its type and fields.
+func (c *fixInitiator) expect(typ string, fields map[int]string) fix.Message {
+	c.t.Helper()
+	m := c.read()
+	if m.Type() != typ {
+		c.t.Fatalf("got %s, want MsgType %s", m, typ)
+	}
+	for tag, v := range fields {
+		if m.Get(tag) != v {
+			c.t.Fatalf("got %s, want %d=%s", m, tag, v)
+		}
+	}
+	return m
+}
+
+func (c *fixInitiator) logon(hb string, reset bool) fix.Message {
+	c.t.Helper()
+	m := c.message(fix.MsgLogon)
+	m.Set(fix.TagEncryptMethod, "0")
+	m.Set(fix.TagHeartBtInt, hb)
+	m.Set(fix.TagPassword, "lp1-secret")
+	if reset {
+		m.Set(fix.TagResetSeqNumFlag, "Y")
+	}
+	c.send(m)
+	return c.expect(fix.MsgLogon, map[int]string{fix.TagHeartBtInt: hb})
+}
+
+func (c *fixInitiator) fill(execID, account, symbol, side, qty, open, close string) fix.Message {
+	m := c.message(fix.MsgExecutionReport)
+	m.Set(fix.TagOrderID, "O-"+execID)
+	m.Set(fix.TagExecID, execID)
+	m.Set(fix.TagExecType, "F")
+	m.Set(fix.TagOrdStatus, "2")
+	m.Set(fix.TagAccount, account)
+	m.Set(fix.TagSymbol, symbol)
+	m.Set(fix.TagSide, side)
+	m.Set(fix.TagLastQty, qty)
+	m.Set(fix.TagLastPx, close)
+	m.Set(FIXTagOpenPx, open)
+	return m
+}
+
+// sync makes the acceptor answer a TestRequest, so everything sent before
+// it has been handled.
+func (c *fixInitiator) sync() fix.Message {
+	c.t.Helper()
+	m := c.message(fix.MsgTestRequest)
+	m.Set(fix.TagTestReqID, "sync")
+	c.send(m)
+	return c.expect(fix.MsgHeartbeat, map[int]string{fix.TagTestReqID: "sync"})
+}
+
+func (c *fixInitiator) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if m, err := c.r.Read(); !errors.Is(err, io.EOF) {
+		c.t.Fatalf("read %v, %v; want the connection closed", m, err)
+	}
+}
+
+func startFIXAcceptor(t *testing.T) (*FIXAcceptor, string) {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	a := NewFIXAcceptor(db, "BROKER", map[string]FIXCounterparty{
+		"LP1": {ClientIdentity{Accounts: []string{"acc1"}, Permissions: []string{dbm.PermWrite}}, "lp1-secret"},
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go a.Serve(l)
+	return a, l.Addr().String()
+}
+
+func countTrades(t *testing.T, a *FIXAcceptor) int {
+	t.Helper()
+	var n int
+	if err := a.DB.QueryRow(`SELECT COUNT(*) FROM trades_q`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestFIXAcceptor(t *testing.T) {
+	a, addr := startFIXAcceptor(t)
+
+	c := dialFIX(t, addr, 1)
+	c.logon("30", true)
+
+	c.send(c.fill("E1", "acc1", "EUR/USD", "1", "100000", "1.1", "1.2"))
+	c.sync()
+	got, err := dbm.GetTrade(a.DB, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Account != "acc1" || got.Symbol != "EURUSD" || got.Volume != 1 || got.Open != 1.1 || got.Close != 1.2 || got.Side != "buy" || got.State != dbm.StatePending {
+		t.Errorf("queued fill = %+v", got)
+	}
+
+	// Fills the session may not book, or that fail validation, are
+	// refused one by one without ending the session.
+	c.send(c.fill("E2", "acc2", "EURUSD", "2", "100000", "1.1", "1.2"))
+	c.expect(fix.MsgBusinessMessageReject, map[int]string{fix.TagRefSeqNum: "4", fix.TagBusinessRejectRefID: "E2", fix.TagBusinessRejectReason: "6"})
+	c.send(c.fill("E3", "acc1", "EURUSD", "2", "100000", "1.1", "-1"))
+	if r := c.expect(fix.MsgBusinessMessageReject, map[int]string{fix.TagBusinessRejectRefID: "E3"}); !strings.Contains(r.Get(fix.TagText), "close must be > 0") {
+		t.Errorf("reject text = %q", r.Get(fix.TagText))
+	}
+	// A fill sent again under a new sequence number is not queued twice.
+	c.send(c.fill("E1", "acc1", "EURUSD", "1", "100000", "1.1", "1.2"))
+	c.sync()
+	if n := countTrades(t, a); n != 1 {
+		t.Errorf("%d trades queued, want 1", n)
+	}
+
+	logout := c.message(fix.MsgLogout)
+	c.send(logout)
+	c.expect(fix.MsgLogout, nil)
+	c.expectClosed()
+
+	// Sequence numbers carry over to the next connection; a gap is
+	// requested again and later messages wait for it.
+	s, err := dbm.GetFIXSession(a.DB, "BROKER", "LP1")
+	if err != nil || s.NextIn != c.seq {
+		t.Fatalf("stored session = %+v, %v; want next_in %d", s, err, c.seq)
+	}
+	c = dialFIX(t, addr, c.seq)
+	reply := c.logon("30", false)
+	if reply.Get(fix.TagMsgSeqNum) != strconv.Itoa(s.NextOut) {
+		t.Errorf("logon reply %s, want MsgSeqNum %d", reply, s.NextOut)
+	}
+	missed := c.seq
+	c.seq++
+	c.send(c.fill("E4", "acc1", "GBPUSD", "2", "50000", "1.3", "1.2"))
+	c.expect(fix.MsgResendRequest, map[int]string{fix.TagBeginSeqNo: strconv.Itoa(missed), fix.TagEndSeqNo: "0"})
+	if n := countTrades(t, a); n != 1 {
+		t.Fatalf("fill after a gap was queued before the gap was filled")
+	}
+	gap := c.message(fix.MsgSequenceReset)
+	gap.Set(fix.TagPossDupFlag, "Y")
+	gap.Set(fix.TagGapFillFlag, "Y")
+	gap.SetInt(fix.TagNewSeqNo, missed+1)
+	c.sendAt(gap, missed)
+	resent := c.fill("E4", "acc1", "GBPUSD", "2", "50000", "1.3", "1.2")
+	resent.Set(fix.TagPossDupFlag, "Y")
+	c.sendAt(resent, missed+1)
+	c.sync()
+	if got, err := dbm.GetTrade(a.DB, 2); err != nil || got.Symbol != "GBPUSD" || got.Volume != 0.5 || got.Side != "sell" {
+		t.Errorf("resent fill = %+v, %v", got, err)
+	}
+
+	// Everything the acceptor sent is gap filled on request.
+	rr := c.message(fix.MsgResendRequest)
+	rr.Set(fix.TagBeginSeqNo, "1")
+	rr.Set(fix.TagEndSeqNo, "0")
+	c.send(rr)
+	gf := c.expect(fix.MsgSequenceReset, map[int]string{fix.TagMsgSeqNum: "1", fix.TagGapFillFlag: "Y", fix.TagPossDupFlag: "Y"})
+	if next := c.sync().Get(fix.TagMsgSeqNum); gf.Get(fix.TagNewSeqNo) != next {
+		t.Errorf("gap fill %s, want NewSeqNo %s", gf, next)
+	}
+
+	// A number below the expected one without PossDupFlag ends the session.
+	c.sendAt(c.message(fix.MsgHeartbeat), 2)
+	if m := c.expect(fix.MsgLogout, nil); !strings.Contains(m.Get(fix.TagText), "MsgSeqNum too low") {
+		t.Errorf("logout text = %q", m.Get(fix.TagText))
+	}
+	c.expectClosed()
+}
+
+func TestFIXLogonRefused(t *testing.T) {
+	_, addr := startFIXAcceptor(t)
+
+	for name, set := range map[string]func(fix.Message) fix.Message{
+		"unknown sender": func(m fix.Message) fix.Message { m.Set(fix.TagSenderCompID, "LP9"); return m },
+		"wrong target":   func(m fix.Message) fix.Message { m.Set(fix.TagTargetCompID, "OTHER"); return m },
+		"not a logon":    func(m fix.Message) fix.Message { m.Set(fix.TagMsgType, fix.MsgHeartbeat); return m },
+		"no password": func(m fix.Message) fix.Message {
+			return slices.DeleteFunc(m, func(f fix.Field) bool { return f.Tag == fix.TagPassword })
+		},
+		"wrong password": func(m fix.Message) fix.Message { m.Set(fix.TagPassword, "guess"); return m },
+		"no heartbeat":   func(m fix.Message) fix.Message { m.Set(fix.TagHeartBtInt, "0"); return m },
+		"huge heartbeat": func(m fix.Message) fix.Message { m.Set(fix.TagHeartBtInt, "9300000000"); return m },
+	} {
+		c := dialFIX(t, addr, 1)
+		m := c.message(fix.MsgLogon)
+		m.Set(fix.TagHeartBtInt, "30")
+		m.Set(fix.TagPassword, "lp1-secret")
+		c.send(set(m))
+		t.Run(name, func(t *testing.T) {
+			c.t = t
+			c.expectClosed()
+		})
+	}
+
+	// A second connection for a logged on session is refused.
+	c := dialFIX(t, addr, 1)
+	c.logon("30", true)
+	dup := dialFIX(t, addr, 1)
+	m := dup.message(fix.MsgLogon)
+	m.Set(fix.TagHeartBtInt, "30")
+	m.Set(fix.TagPassword, "lp1-secret")
+	dup.send(m)
+	dup.expectClosed()
+	c.sync()
+}
+
+func TestFIXLogonClientCert(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	a := NewFIXAcceptor(db, "BROKER", map[string]FIXCounterparty{
+		"LP1": {ClientIdentity: ClientIdentity{Accounts: []string{"acc1"}, Permissions: []string{dbm.PermWrite}}},
+	})
+	ca := newTestCert(t, "test-ca", nil, 1)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "localhost", ca, 2).tlsCertificate()},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go a.Serve(l)
+
+	dial := func(t *testing.T, client *testCert) *fixInitiator {
+		cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		if client != nil {
+			cfg.Certificates = []tls.Certificate{client.tlsCertificate()}
+		}
+		conn, err := tls.Dial("tcp", l.Addr().String(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &fixInitiator{t: t, conn: conn, r: fix.NewReader(conn), seq: 1}
+	}
+
+	// LP1 has no password, so only a certificate issued to LP1 logs on.
+	c := dial(t, newTestCert(t, "LP1", ca, 3))
+	c.logon("30", true)
+	c.sync()
+
+	for name, client := range map[string]*testCert{
+		"no certificate":    nil,
+		"other certificate": newTestCert(t, "LP2", ca, 4),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := dial(t, client)
+			m := c.message(fix.MsgLogon)
+			m.Set(fix.TagHeartBtInt, "30")
+			c.send(m)
+			c.expectClosed()
+		})
+	}
+}
+
+func TestFIXHeartbeat(t *testing.T) {
+	_, addr := startFIXAcceptor(t)
+	c := dialFIX(t, addr, 1)
+	c.logon("1", true)
+
+	// A quiet acceptor sends heartbeats, then tests a silent counterparty
+	// and drops it when the test goes unanswered.
+	c.expect(fix.MsgHeartbeat, nil)
+	for {
+		m := c.read()
+		if m.Type() == fix.MsgTestRequest {
+			break
+		}
+		if m.Type() != fix.MsgHeartbeat {
+			t.Fatalf("got %s, want a Heartbeat or TestRequest", m)
+		}
+	}
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		m, err := c.r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("waiting for the acceptor to give up: %v", err)
+		}
+		if m.Type() == fix.MsgLogout {
+			c.expectClosed()
+			break
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	brokerv1 "gitlab.com/digineat/go-broker-test/api/broker/v1"
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Metadata keys read by the gRPC API, the counterparts of the HTTP headers.
+const (
+	metadataAuthorization  = "authorization"
+	metadataIdempotencyKey = "idempotency-key"
+	metadataRequestID      = "x-request-id"
+)
+
+const (
+	defaultWatchInterval = time.Second
+	minWatchInterval     = 100 * time.Millisecond
+)
+
+// NewGRPCServer returns a gRPC server exposing BrokerService, the standard
+// health service and reflection. It takes the same options as SetupRouter;
+// the authenticators, read pool, queue guard, rate limits and logger apply,
+// while HMAC signing is HTTP only. creds may be nil for plaintext.
+func NewGRPCServer(db *sql.DB, creds credentials.TransportCredentials, opts ...RouterOption) (*grpc.Server, *health.Server) {
+	cfg := routerConfig{readDB: db, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	svc := &brokerService{db: db, readDB: cfg.readDB}
+	if cfg.maxPending > 0 {
+		svc.guard = NewQueueGuard(cfg.readDB, cfg.maxPending)
+	}
+	in := &rpcInterceptor{authns: cfg.authns, readAuthns: cfg.readAuthns, limiters: cfg.limiters, logger: cfg.logger}
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(in.unary),
+		grpc.ChainStreamInterceptor(in.stream),
+	}
+	if creds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+
+	srv := grpc.NewServer(serverOpts...)
+	brokerv1.RegisterBrokerServiceServer(srv, svc)
+	hs := health.NewServer()
+	hs.SetServingStatus(brokerv1.BrokerService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	return srv, hs
+}
+
+// WatchHealth pings db every interval and reports the result through hs
+// until ctx is done.
+func WatchHealth(ctx context.Context, db *sql.DB, hs *health.Server, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		st := healthpb.HealthCheckResponse_SERVING
+		if err := db.PingContext(ctx); err != nil {
+			st = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", st)
+		hs.SetServingStatus(brokerv1.BrokerService_ServiceDesc.ServiceName, st)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type brokerService struct {
+	brokerv1.UnimplementedBrokerServiceServer
+	db     *sql.DB
+	readDB *sql.DB
+	guard  *QueueGuard
+}
+
+func (s *brokerService) SubmitTrade(ctx context.Context, req *brokerv1.SubmitTradeRequest) (*brokerv1.SubmitTradeResponse, error) {
+	t := tradeFromProto(req.GetTrade())
+	if err := checkTrade(ctx, t); err != nil {
+		return nil, err
+	}
+	ids, replayed, err := s.queue(ctx, []TradeRequest{t})
+	if err != nil {
+		return nil, err
+	}
+	return &brokerv1.SubmitTradeResponse{Id: int64(ids[0]), Replayed: replayed}, nil
+}
+
+func (s *brokerService) SubmitTrades(stream grpc.ClientStreamingServer[brokerv1.SubmitTradesRequest, brokerv1.SubmitTradesResponse]) error {
+	ctx := stream.Context()
+	var trades []TradeRequest
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(trades) == maxBatchTrades {
+			return status.Errorf(codes.InvalidArgument, "a batch holds 1 to %d trades", maxBatchTrades)
+		}
+		t := tradeFromProto(req.GetTrade())
+		if err := checkTrade(ctx, t); err != nil {
+			st := status.Convert(err)
+			return status.Errorf(st.Code(), "trade %d: %s", len(trades), st.Message())
+		}
+		trades = append(trades, t)
+	}
+	if len(trades) == 0 {
+		return status.Errorf(codes.InvalidArgument, "a batch holds 1 to %d trades", maxBatchTrades)
+	}
+
+	ids, replayed, err := s.queue(ctx, trades)
+	if err != nil {
+		return err
+	}
+	resp := &brokerv1.SubmitTradesResponse{Replayed: replayed}
+	for _, id := range ids {
+		resp.Ids = append(resp.Ids, int64(id))
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (s *brokerService) GetStats(ctx context.Context, req *brokerv1.GetStatsRequest) (*brokerv1.GetStatsResponse, error) {
+	st, err := s.stats(ctx, req.GetAccount())
+	if err != nil {
+		return nil, err
+	}
+	return &brokerv1.GetStatsResponse{Stats: st}, nil
+}
+
+func (s *brokerService) WatchStats(req *brokerv1.WatchStatsRequest, stream grpc.ServerStreamingServer[brokerv1.WatchStatsResponse]) error {
+	ctx := stream.Context()
+	every := defaultWatchInterval
+	if req.GetInterval() != nil {
+		every = max(req.GetInterval().AsDuration(), minWatchInterval)
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	var last *brokerv1.Stats
+	for {
+		st, err := s.stats(ctx, req.GetAccount())
+		if err != nil {
+			return err
+		}
+		if !proto.Equal(st, last) {
+			if err := stream.Send(&brokerv1.WatchStatsResponse{Stats: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *brokerService) stats(ctx context.Context, acc string) (*brokerv1.Stats, error) {
+	if acc == "" {
+		return nil, status.Error(codes.InvalidArgument, "account not specified")
+	}
+	if err := authorizeRPC(ctx, acc, dbm.PermRead); err != nil {
+		return nil, err
+	}
+	st, err := accountStats(ctx, s.readDB, acc)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get stats")
+	}
+	return &brokerv1.Stats{
+		Account:       st.Account,
+		Trades:        int64(st.Trades),
+		Profit:        st.Profit,
+		OpenPositions: int64(st.OpenPositions),
+		Unrealized:    st.Unrealized,
+		Equity:        st.Equity,
+	}, nil
+}
+
+// queue enqueues checked trades under the call's idempotency key, shedding
+// them like the HTTP queue guard while the queues are full.
+func (s *brokerService) queue(ctx context.Context, trades []TradeRequest) ([]int, bool, error) {
+	if s.guard != nil {
+		full, err := s.guard.Full()
+		if err != nil {
+			return nil, false, status.Error(codes.Internal, "failed to check queue depth")
+		}
+		if full {
+			return nil, false, status.Error(codes.Unavailable, "queue is full")
+		}
+	}
+	key := firstMetadata(ctx, metadataIdempotencyKey)
+	if key != "" && !validRequestID(key) {
+		return nil, false, status.Error(codes.InvalidArgument, "invalid "+metadataIdempotencyKey)
+	}
+
+	ids, replayed, err := queueTrades(ctx, s.db, trades, key)
+	if errors.Is(err, dbm.ErrIdempotencyConflict) {
+		return nil, false, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if err != nil {
+		return nil, false, status.Error(codes.Internal, "failed to enqueue trade")
+	}
+	return ids, replayed, nil
+}
+
+func tradeFromProto(t *brokerv1.Trade) TradeRequest {
+	return TradeRequest{
+		Account: t.GetAccount(),
+		Symbol:  t.GetSymbol(),
+		Volume:  t.GetVolume(),
+		Open:    t.GetOpen(),
+		Close:   t.GetClose(),
+		Side:    t.GetSide(),
+	}
+}
+
+func checkTrade(ctx context.Context, t TradeRequest) error {
+	if err := authorizeRPC(ctx, t.Account, dbm.PermWrite); err != nil {
+		return err
+	}
+	if err := ValidateTradeRequest(t); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// authorizeRPC is authorize for gRPC calls.
+func authorizeRPC(ctx context.Context, account, perm string) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Allows(account, perm) {
+		return nil
+	}
+	return status.Error(codes.PermissionDenied, "forbidden")
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// rpcInterceptor gives gRPC calls what AccessLog and RequireAuth give HTTP
+// requests: a request ID, an identity and a log line.
+type rpcInterceptor struct {
+	authns     []Authenticator
+	readAuthns []Authenticator
+	limiters   map[string]*RateLimiter
+	logger     *slog.Logger
+}
+
+// rpcRoutes maps BrokerService methods to the HTTP routes whose rate limits
+// they share.
+var rpcRoutes = map[string]string{
+	brokerv1.BrokerService_SubmitTrade_FullMethodName:  "/trades",
+	brokerv1.BrokerService_SubmitTrades_FullMethodName: "/trades/",
+	brokerv1.BrokerService_GetStats_FullMethodName:     "/stats/",
+	brokerv1.BrokerService_WatchStats_FullMethodName:   "/stats/",
+}
+
+// rpcWrites are the BrokerService methods that need write permission.
+var rpcWrites = map[string]bool{
+	brokerv1.BrokerService_SubmitTrade_FullMethodName:  true,
+	brokerv1.BrokerService_SubmitTrades_FullMethodName: true,
+}
+
+func (in *rpcInterceptor) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, err := in.begin(ctx, info.FullMethod)
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	in.log(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (in *rpcInterceptor) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := in.begin(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+	in.log(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// begin assigns the call a request ID, echoed in the response header, then
+// authenticates and rate limits calls to BrokerService. Health checks and
+// reflection stay public like /healthz.
+func (in *rpcInterceptor) begin(ctx context.Context, method string) (context.Context, error) {
+	id := firstMetadata(ctx, metadataRequestID)
+	if !validRequestID(id) {
+		id = NewRequestID()
+	}
+	ctx = WithRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs(metadataRequestID, id))
+
+	if !strings.HasPrefix(method, "/"+brokerv1.BrokerService_ServiceDesc.ServiceName+"/") {
+		return ctx, nil
+	}
+	ctx, err := in.authenticate(ctx, method)
+	if err != nil {
+		return ctx, err
+	}
+	if l, ok := in.limiters[rpcRoutes[method]]; ok {
+		if ok, wait := l.Allow(rpcClientKey(ctx)); !ok {
+			return ctx, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry in %s", wait.Round(time.Millisecond))
+		}
+	}
+	return ctx, nil
+}
+
+func (in *rpcInterceptor) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if len(in.authns)+len(in.readAuthns) == 0 {
+		return ctx, nil
+	}
+	caller, err := authenticateRPC(ctx, append(slices.Clip(in.authns), in.readAuthns...))
+	switch {
+	case err != nil:
+		return ctx, err
+	case caller != nil:
+		return WithIdentity(ctx, caller), nil
+	case rpcWrites[method] && len(in.authns) == 0:
+		// as in RequireAuth, read-only authenticators alone don't gate writes
+		return ctx, nil
+	}
+	return ctx, status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// rpcClientKey is clientKey for gRPC calls.
+func rpcClientKey(ctx context.Context) string {
+	if id, ok := IdentityFromContext(ctx); ok {
+		return id.Subject
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "ip:"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:" + host
+}
+
+func (in *rpcInterceptor) log(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	}
+	in.logger.LogAttrs(ctx, level, "rpc",
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("request_id", RequestIDFromContext(ctx)),
+	)
+}
+
+// authenticateRPC runs the HTTP authenticators against the call's metadata
+// and peer certificate. It returns nil if no authenticator recognizes them.
+func authenticateRPC(ctx context.Context, authns []Authenticator) (*Identity, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(metadataAuthorization) {
+		r.Header.Add("Authorization", v)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	id, err := authenticate(r, authns)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+	return id, nil
+}
+
+// serverStream overrides the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
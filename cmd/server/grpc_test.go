@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+
+	brokerv1 "gitlab.com/digineat/go-broker-test/api/broker/v1"
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func dialTestGRPC(t *testing.T, db *sql.DB, opts ...RouterOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv, _ := NewGRPCServer(db, nil, opts...)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testTrade(account string) *brokerv1.Trade {
+	return &brokerv1.Trade{Account: account, Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}
+}
+
+func TestGRPCSubmitTrade(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	c := brokerv1.NewBrokerServiceClient(dialTestGRPC(t, db))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), metadataIdempotencyKey, "order-1")
+	first, err := c.SubmitTrade(ctx, &brokerv1.SubmitTradeRequest{Trade: testTrade("acc1")})
+	if err != nil || first.Id == 0 || first.Replayed {
+		t.Fatalf("SubmitTrade() = %v, %v", first, err)
+	}
+	again, err := c.SubmitTrade(ctx, &brokerv1.SubmitTradeRequest{Trade: testTrade("acc1")})
+	if err != nil || again.Id != first.Id || !again.Replayed {
+		t.Errorf("resubmitted trade = %v, %v; want replay of id %d", again, err, first.Id)
+	}
+	changed := testTrade("acc1")
+	changed.Volume = 2
+	if _, err := c.SubmitTrade(ctx, &brokerv1.SubmitTradeRequest{Trade: changed}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("reused key: code = %v, want AlreadyExists", status.Code(err))
+	}
+	// without an identity keys are scoped to the account
+	other, err := c.SubmitTrade(ctx, &brokerv1.SubmitTradeRequest{Trade: testTrade("acc2")})
+	if err != nil || other.Id == first.Id || other.Replayed {
+		t.Errorf("same key for another account = %v, %v", other, err)
+	}
+
+	bad := testTrade("acc1")
+	bad.Side = "hold"
+	if _, err := c.SubmitTrade(context.Background(), &brokerv1.SubmitTradeRequest{Trade: bad}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid trade: code = %v, want InvalidArgument", status.Code(err))
+	}
+
+	trades, err := dbm.FetchPendingTrades(db)
+	if err != nil || len(trades) != 2 {
+		t.Errorf("pending trades = %d, %v; want 2", len(trades), err)
+	}
+}
+
+func TestGRPCSubmitTrades(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	c := brokerv1.NewBrokerServiceClient(dialTestGRPC(t, db))
+
+	submit := func(trades ...*brokerv1.Trade) (*brokerv1.SubmitTradesResponse, error) {
+		stream, err := c.SubmitTrades(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range trades {
+			if err := stream.Send(&brokerv1.SubmitTradesRequest{Trade: tr}); err != nil {
+				break
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+
+	resp, err := submit(testTrade("acc1"), testTrade("acc2"))
+	if err != nil || len(resp.Ids) != 2 {
+		t.Fatalf("SubmitTrades() = %v, %v", resp, err)
+	}
+
+	bad := testTrade("acc1")
+	bad.Volume = 0
+	_, err = submit(testTrade("acc1"), bad)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("batch with invalid trade: code = %v, want InvalidArgument", status.Code(err))
+	}
+	if _, err := submit(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty batch: code = %v, want InvalidArgument", status.Code(err))
+	}
+
+	trades, err := dbm.FetchPendingTrades(db)
+	if err != nil || len(trades) != 2 {
+		t.Errorf("pending trades = %d, %v; want 2 (a rejected batch queues nothing)", len(trades), err)
+	}
+}
+
+func TestGRPCStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	c := brokerv1.NewBrokerServiceClient(dialTestGRPC(t, db))
+
+	if err := dbm.UpdateStats(db, "acc1", 100); err != nil {
+		t.Fatalf("UpdateStats failed: %v", err)
+	}
+	resp, err := c.GetStats(context.Background(), &brokerv1.GetStatsRequest{Account: "acc1"})
+	if err != nil || resp.Stats.Trades != 1 || resp.Stats.Profit != 100 {
+		t.Fatalf("GetStats() = %v, %v", resp, err)
+	}
+	if _, err := c.GetStats(context.Background(), &brokerv1.GetStatsRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("no account: code = %v, want InvalidArgument", status.Code(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := c.WatchStats(ctx, &brokerv1.WatchStatsRequest{Account: "acc1", Interval: durationpb.New(10 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("WatchStats failed: %v", err)
+	}
+	first, err := stream.Recv()
+	if err != nil || first.Stats.Trades != 1 {
+		t.Fatalf("first update = %v, %v", first, err)
+	}
+	if err := dbm.UpdateStats(db, "acc1", 50); err != nil {
+		t.Fatalf("UpdateStats failed: %v", err)
+	}
+	next, err := stream.Recv()
+	if err != nil || next.Stats.Trades != 2 || next.Stats.Profit != 150 {
+		t.Errorf("next update = %v, %v; want 2 trades, profit 150", next, err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	reader, _, err := dbm.CreateAPIKey(db, "reader", []string{"acc1"}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	conn := dialTestGRPC(t, db, WithAuthenticators(APIKeyAuthenticator(db)))
+	c := brokerv1.NewBrokerServiceClient(conn)
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), metadataAuthorization, key)
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		acc  string
+		want codes.Code
+	}{
+		{"missing key", context.Background(), "acc1", codes.Unauthenticated},
+		{"unknown key", withKey("ApiKey bk_nope"), "acc1", codes.Unauthenticated},
+		{"own account", withKey("ApiKey " + reader), "acc1", codes.OK},
+		{"other account", withKey("ApiKey " + reader), "acc2", codes.PermissionDenied},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := c.GetStats(tc.ctx, &brokerv1.GetStatsRequest{Account: tc.acc})
+			if status.Code(err) != tc.want {
+				t.Errorf("code = %v, want %v", status.Code(err), tc.want)
+			}
+		})
+	}
+
+	_, err = c.SubmitTrade(withKey("ApiKey "+reader), &brokerv1.SubmitTradeRequest{Trade: testTrade("acc1")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("write with read key: code = %v, want PermissionDenied", status.Code(err))
+	}
+
+	// Read-only authenticators alone gate reads but not submissions.
+	c = brokerv1.NewBrokerServiceClient(dialTestGRPC(t, db, WithReadAuthenticators(APIKeyAuthenticator(db))))
+	if _, err := c.SubmitTrade(context.Background(), &brokerv1.SubmitTradeRequest{Trade: testTrade("acc1")}); err != nil {
+		t.Errorf("write without read-only credentials: %v", err)
+	}
+	if _, err := c.GetStats(context.Background(), &brokerv1.GetStatsRequest{Account: "acc1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("read without credentials: code = %v, want Unauthenticated", status.Code(err))
+	}
+
+	// Health checks stay public, like /healthz.
+	hc, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{
+		Service: brokerv1.BrokerService_ServiceDesc.ServiceName,
+	})
+	if err != nil || hc.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health check = %v, %v", hc, err)
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	a, _, _ := dbm.CreateAPIKey(db, "a", []string{"acc1"}, []string{dbm.PermRead})
+	b, _, _ := dbm.CreateAPIKey(db, "b", []string{"acc1"}, []string{dbm.PermRead})
+	c := brokerv1.NewBrokerServiceClient(dialTestGRPC(t, db,
+		WithAuthenticators(APIKeyAuthenticator(db)),
+		WithRateLimits(map[string]RateLimit{"/stats/": {Rate: 0.01, Burst: 1}}),
+	))
+
+	call := func(key string) codes.Code {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), metadataAuthorization, "ApiKey "+key)
+		_, err := c.GetStats(ctx, &brokerv1.GetStatsRequest{Account: "acc1"})
+		return status.Code(err)
+	}
+	if got := call(a); got != codes.OK {
+		t.Fatalf("first call: code = %v", got)
+	}
+	if got := call(a); got != codes.ResourceExhausted {
+		t.Errorf("second call: code = %v, want ResourceExhausted", got)
+	}
+	// limits are per identity
+	if got := call(b); got != codes.OK {
+		t.Errorf("another caller: code = %v, want OK", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+const (
+	hubBatch  = 500
+	hubBuffer = 64
+)
+
+// EventHub tails the events table and fans new events out to subscribers,
+// so the worker's changes reach streaming clients without each of them
+// polling the database. It only polls while someone is subscribed.
+type EventHub struct {
+	db    *sql.DB
+	every time.Duration
+
+	mu   sync.Mutex
+	subs map[*eventSub]struct{}
+	last int64
+	stop chan struct{}
+}
+
+type eventSub struct {
+	account string
+	ch      chan dbm.Event
+}
+
+func NewEventHub(db *sql.DB, every time.Duration) *EventHub {
+	return &EventHub{db: db, every: every, subs: map[*eventSub]struct{}{}}
+}
+
+// Subscribe returns a channel of the events of account, or of every account
+// if it is empty, appended from now on. The channel is closed by cancel, or
+// early if the subscriber falls too far behind; it should then resync.
+func (h *EventHub) Subscribe(account string) (<-chan dbm.Event, func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		last, err := dbm.LatestEventID(h.db, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		h.last = last
+		h.stop = make(chan struct{})
+		go h.run(h.stop)
+	}
+	sub := &eventSub{account: account, ch: make(chan dbm.Event, hubBuffer)}
+	h.subs[sub] = struct{}{}
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(sub)
+	}
+	return sub.ch, cancel, nil
+}
+
+// remove drops sub and stops polling with the last subscriber. h.mu must be
+// held.
+func (h *EventHub) remove(sub *eventSub) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	close(sub.ch)
+	if len(h.subs) == 0 {
+		close(h.stop)
+	}
+}
+
+func (h *EventHub) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(h.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.poll()
+		}
+	}
+}
+
+func (h *EventHub) poll() {
+	h.mu.Lock()
+	after := h.last
+	h.mu.Unlock()
+
+	events, err := dbm.ListEvents(h.db, after, "", hubBatch)
+	if err != nil {
+		slog.Error("polling events", "err", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range events {
+		if ev.ID <= h.last {
+			continue
+		}
+		h.last = ev.ID
+		for sub := range h.subs {
+			if sub.account != "" && sub.account != ev.Account {
+				continue
+			}
+			select {
+			case sub.ch <- ev:
+			default:
+				h.remove(sub)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/importer"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+const maxImportSize = 256 << 20
+
+type ImportErrorResponse struct {
+	Line   int    `json:"line"`
+	Ticket string `json:"ticket,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ImportResponse is the status of an import. Progress is the share of the
+// file read so far; Errors holds the first rejected rows.
+type ImportResponse struct {
+	ID         int                   `json:"id"`
+	Account    string                `json:"account"`
+	Format     string                `json:"format"`
+	Name       string                `json:"name,omitempty"`
+	State      string                `json:"state"`
+	Size       int64                 `json:"size"`
+	BytesRead  int64                 `json:"bytes_read"`
+	Progress   float64               `json:"progress"`
+	Rows       int                   `json:"rows"`
+	Queued     int                   `json:"queued"`
+	Duplicates int                   `json:"duplicates"`
+	Rejected   int                   `json:"rejected"`
+	Error      string                `json:"error,omitempty"`
+	Errors     []ImportErrorResponse `json:"errors"`
+	CreatedAt  time.Time             `json:"created_at"`
+	FinishedAt *time.Time            `json:"finished_at,omitempty"`
+}
+
+func importResponse(imp dbm.Import) ImportResponse {
+	resp := ImportResponse{
+		ID:         imp.ID,
+		Account:    imp.Account,
+		Format:     imp.Format,
+		Name:       imp.Name,
+		State:      imp.State,
+		Size:       imp.Size,
+		BytesRead:  imp.BytesRead,
+		Rows:       imp.Rows,
+		Queued:     imp.Queued,
+		Duplicates: imp.Duplicates,
+		Rejected:   imp.Rejected,
+		Error:      imp.Error,
+		Errors:     make([]ImportErrorResponse, len(imp.Errors)),
+		CreatedAt:  imp.CreatedAt,
+		FinishedAt: imp.FinishedAt,
+	}
+	switch {
+	case imp.State == dbm.ImportDone:
+		resp.Progress = 1
+	case imp.Size > 0:
+		resp.Progress = min(float64(imp.BytesRead)/float64(imp.Size), 1)
+	}
+	for i, e := range imp.Errors {
+		resp.Errors[i] = ImportErrorResponse{Line: e.Line, Ticket: e.Ticket, Error: e.Error}
+	}
+	return resp
+}
+
+// HandleImports serves POST /imports?account=&format=&columns=&name=, which
+// queues the historical trades in the request body for one account: a CSV
+// file whose columns are mapped to trade fields by columns, or a MetaTrader
+// statement. The file is checked and spooled to disk, then read in the
+// background, waiting between batches while more than maxPending rows are
+// pending; the response points at the import's status.
+func HandleImports(w http.ResponseWriter, r *http.Request, db *sql.DB, maxPending int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	account := q.Get("account")
+	if account == "" {
+		http.Error(w, "account is required", http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = importer.FormatCSV
+	}
+	if format != importer.FormatCSV && format != importer.FormatMetaTrader {
+		http.Error(w, "format must be csv or metatrader", http.StatusBadRequest)
+		return
+	}
+	mapping, err := importer.ParseMapping(q.Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, account, dbm.PermWrite) {
+		return
+	}
+
+	f, err := os.CreateTemp("", "broker-import-*")
+	if err != nil {
+		http.Error(w, "failed to store import", http.StatusInternalServerError)
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	size, err := io.Copy(f, http.MaxBytesReader(w, r.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "import files are limited to "+strconv.Itoa(maxImportSize>>20)+" MiB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read import", http.StatusBadRequest)
+		return
+	}
+	if size == 0 {
+		http.Error(w, "import file is empty", http.StatusBadRequest)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "failed to store import", http.StatusInternalServerError)
+		return
+	}
+	src := &importer.CountingReader{R: f}
+	rows, err := importer.NewReader(src, format, mapping, account)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var imp dbm.Import
+	err = tracing.DB(r.Context(), "CreateImport", func() (err error) {
+		imp, err = dbm.CreateImport(db, account, format, q.Get("name"), size)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "failed to create import", http.StatusInternalServerError)
+		return
+	}
+	started = true
+	go func() {
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if err := importer.Run(db, imp.ID, rows, func() int64 { return src.N }, maxPending); err != nil {
+			slog.Error("import failed", "import", imp.ID, "account", account, "err", err)
+			return
+		}
+		slog.Info("import finished", "import", imp.ID, "account", account)
+	}()
+
+	w.Header().Set("Location", "/imports/"+strconv.Itoa(imp.ID))
+	writeJSON(w, http.StatusAccepted, importResponse(imp))
+}
+
+// HandleImportRequest serves GET /imports/{id}.
+func HandleImportRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/imports/"))
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var imp dbm.Import
+	err = tracing.DB(r.Context(), "GetImport", func() (err error) {
+		imp, err = dbm.GetImport(db, id)
+		return err
+	})
+	if errors.Is(err, dbm.ErrImportNotFound) {
+		http.Error(w, "import not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get import", http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, imp.Account, dbm.PermRead) {
+		return
+	}
+	writeJSON(w, http.StatusOK, importResponse(imp))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestImports(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	key, _, err := dbm.CreateAPIKey(db, "onboarding", []string{"acc1"}, []string{dbm.PermRead, dbm.PermWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRouter(db, WithAuthenticators(APIKeyAuthenticator(db)))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "ApiKey "+key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	// wait polls an import until it is no longer running.
+	wait := func(location string) ImportResponse {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rr := do("GET", location, "")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("GET %s: status %d: %s", location, rr.Code, rr.Body.String())
+			}
+			var imp ImportResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &imp); err != nil {
+				t.Fatal(err)
+			}
+			if imp.State != dbm.ImportRunning {
+				return imp
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("import still running: %+v", imp)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	csv := "Order,Instrument,Side,Lots,Entry,Exit\n" +
+		"1001,EURUSD,buy,1,1.1,1.2\n" +
+		"1002,GBPUSD,sell,0.5,1.3,1.2\n" +
+		"1003,GBPUSD,sell,-1,1.3,1.2\n"
+	rr := do("POST", "/imports?account=acc1&name=history.csv&columns=ticket=Order,symbol=Instrument,volume=Lots,open=Entry,close=Exit", csv)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("POST /imports: status %d: %s", rr.Code, rr.Body.String())
+	}
+	imp := wait(rr.Header().Get("Location"))
+	if imp.State != dbm.ImportDone || imp.Rows != 3 || imp.Queued != 2 || imp.Rejected != 1 || imp.Progress != 1 || imp.Name != "history.csv" {
+		t.Errorf("import = %+v", imp)
+	}
+	if len(imp.Errors) != 1 || imp.Errors[0].Line != 4 || imp.Errors[0].Ticket != "1003" {
+		t.Errorf("errors = %+v", imp.Errors)
+	}
+
+	// Importing the same history again queues nothing twice.
+	rr = do("POST", "/imports?account=acc1&columns=ticket=Order,symbol=Instrument,volume=Lots,open=Entry,close=Exit", csv)
+	if again := wait(rr.Header().Get("Location")); again.Queued != 0 || again.Duplicates != 2 {
+		t.Errorf("second import = %+v", again)
+	}
+	var queued int
+	db.QueryRow(`SELECT COUNT(*) FROM trades_q WHERE account = 'acc1'`).Scan(&queued)
+	if queued != 2 {
+		t.Errorf("%d trades queued, want 2", queued)
+	}
+
+	for _, tt := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/imports?account=acc2", csv, http.StatusForbidden},
+		{"/imports", csv, http.StatusBadRequest},
+		{"/imports?account=acc1&format=xlsx", csv, http.StatusBadRequest},
+		{"/imports?account=acc1&columns=price=Entry", csv, http.StatusBadRequest},
+		{"/imports?account=acc1", csv, http.StatusBadRequest}, // no ticket column
+		{"/imports?account=acc1", "", http.StatusBadRequest},
+	} {
+		if rr := do("POST", tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("POST %s: status %d, want %d: %s", tt.path, rr.Code, tt.status, rr.Body.String())
+		}
+	}
+
+	other, err := dbm.CreateImport(db, "acc2", "csv", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := do("GET", "/imports/"+strconv.Itoa(other.ID), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("import of another account: status %d, want 403", rr.Code)
+	}
+	if rr := do("GET", "/imports/99", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("missing import: status %d, want 404", rr.Code)
+	}
+}
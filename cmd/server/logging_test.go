@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestAccessLogRequestID(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	router := SetupRouter(db, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	body := `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"buy"}`
+	req := httptest.NewRequest(http.MethodPost, "/trades", strings.NewReader(body))
+	req.Header.Set(HeaderRequestID, "client-req-1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("status %d", rr.Code)
+	}
+	if got := rr.Header().Get(HeaderRequestID); got != "client-req-1" {
+		t.Errorf("response %s = %q", HeaderRequestID, got)
+	}
+
+	var rec struct {
+		Msg       string `json:"msg"`
+		Method    string `json:"method"`
+		Route     string `json:"route"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+		RequestID string `json:"request_id"`
+		Duration  *int64 `json:"duration"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("access log %q: %v", buf.String(), err)
+	}
+	if rec.Msg != "request" || rec.Method != "POST" || rec.Route != "/trades" ||
+		rec.Status != http.StatusAccepted || rec.RequestID != "client-req-1" || rec.Duration == nil {
+		t.Errorf("access log = %+v", rec)
+	}
+
+	trades, err := dbm.FetchPendingTrades(db)
+	if err != nil || len(trades) != 1 {
+		t.Fatalf("FetchPendingTrades = %v, %v", trades, err)
+	}
+	if trades[0].RequestID != "client-req-1" {
+		t.Errorf("stored request id = %q", trades[0].RequestID)
+	}
+
+	// unusable IDs are replaced
+	buf.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/stats/acc1", nil)
+	req.Header.Set(HeaderRequestID, "bad id\n")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	got := rr.Header().Get(HeaderRequestID)
+	if got == "" || got == "bad id\n" {
+		t.Errorf("response %s = %q, want a generated id", HeaderRequestID, got)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("access log %q: %v", buf.String(), err)
+	}
+	if rec.Route != "/stats/" || rec.RequestID != got || rec.Bytes != rr.Body.Len() {
+		t.Errorf("access log = %+v, body %d bytes", rec, rr.Body.Len())
+	}
+}
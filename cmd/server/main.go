@@ -1,22 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math"
+	"net"
 	"net/http"
-	"regexp"
+	"os"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/jwt"
+	"gitlab.com/digineat/go-broker-test/internal/logging"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+	"google.golang.org/grpc/credentials"
 )
 
-var symbolRe = regexp.MustCompile(`^[A-Z]{6}$`)
-
 type TradeRequest struct {
 	Account string  `json:"account"`
 	Symbol  string  `json:"symbol"`
@@ -26,10 +36,19 @@ type TradeRequest struct {
 	Side    string  `json:"side"`
 }
 
+func (req TradeRequest) Trade() trade.Trade {
+	return trade.Trade{
+		Account: req.Account,
+		Symbol:  req.Symbol,
+		Volume:  req.Volume,
+		Open:    req.Open,
+		Close:   req.Close,
+		Side:    req.Side,
+	}
+}
+
 func InitDatabase(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
+	db, err := dbm.Open(dbPath, dbm.Options{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -44,18 +63,11 @@ func InitDatabase(dbPath string) (*sql.DB, error) {
 }
 
 func ValidateTradeRequest(req TradeRequest) error {
-	if req.Account == "" || !symbolRe.MatchString(req.Symbol) || req.Volume <= 0 || req.Open <= 0 || req.Close <= 0 || (req.Side != "buy" && req.Side != "sell") {
-		return fmt.Errorf("invalid trade payload")
-	}
-	return nil
+	return req.Trade().Validate()
 }
 
 func CalculateProfit(close, open, volume float64, side string) float64 {
-	profit := (close - open) * volume * 100000.0
-	if side == "sell" {
-		profit = -profit
-	}
-	return profit
+	return trade.Profit(open, close, volume, side)
 }
 
 func HandleTradeRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -64,30 +76,102 @@ func HandleTradeRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	var req TradeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	// Close is decoded separately to tell a missing close price, which opens
+	// a position, from an invalid zero one.
+	var payload struct {
+		TradeRequest
+		Close *float64 `json:"close"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
+	req := payload.TradeRequest
+	if !authorize(w, r, req.Account, dbm.PermWrite) {
+		return
+	}
+	key, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+	if payload.Close == nil {
+		openTradePosition(w, r, db, req, key)
+		return
+	}
+	req.Close = *payload.Close
 
 	if err := ValidateTradeRequest(req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := dbm.EnqueueTrade(db, dbm.Trade{
+	ids, ok := enqueueTrades(w, r, db, []TradeRequest{req}, key)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusAccepted, TradeAcceptedResponse{ID: ids[0]})
+}
+
+// openTradePosition enqueues a trade submitted without a close price as an
+// open position under a generated id. With an idempotency key the id is
+// derived from the key, so a retried submission finds the position it opened.
+func openTradePosition(w http.ResponseWriter, r *http.Request, db *sql.DB, req TradeRequest, key string) {
+	id := idempotentPositionID(idempotencyScope(r.Context(), req.Account), key)
+	if key == "" {
+		var err error
+		if id, err = NewPositionID(); err != nil {
+			http.Error(w, "failed to generate position id", http.StatusInternalServerError)
+			return
+		}
+	}
+	p := trade.Position{
+		ID:      id,
 		Account: req.Account,
 		Symbol:  req.Symbol,
+		Side:    req.Side,
 		Volume:  req.Volume,
 		Open:    req.Open,
-		Close:   req.Close,
-		Side:    req.Side,
-	}); err != nil {
+	}
+	if err := p.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if key != "" {
+		acc, err := dbm.PositionAccount(db, p.ID)
+		switch {
+		case err == nil && acc == p.Account:
+			w.Header().Set(HeaderIdempotentReplayed, "true")
+			writeJSON(w, http.StatusAccepted, TradeAcceptedResponse{Position: p.ID})
+			return
+		case err == nil:
+			http.Error(w, dbm.ErrIdempotencyConflict.Error(), http.StatusConflict)
+			return
+		case !errors.Is(err, dbm.ErrPositionNotFound):
+			http.Error(w, "failed to get position", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err := enqueuePositionEvent(r, db, dbm.PositionEvent{
+		Position: p.ID,
+		Kind:     dbm.PositionOpen,
+		Account:  p.Account,
+		Symbol:   p.Symbol,
+		Side:     p.Side,
+		Volume:   p.Volume,
+		Price:    p.Open,
+	})
+	if errors.Is(err, dbm.ErrPositionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
 		http.Error(w, "failed to enqueue trade", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, http.StatusAccepted, TradeAcceptedResponse{Position: p.ID})
 }
 
 func HandleStatsRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -101,17 +185,55 @@ func HandleStatsRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, "account not specified", http.StatusBadRequest)
 		return
 	}
+	if !authorize(w, r, acc, dbm.PermRead) {
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	mediaType := negotiate(r.Header.Get("Accept"), statsMediaTypes)
+	if mediaType == "" {
+		http.Error(w, "not acceptable; supported: "+strings.Join(statsMediaTypes, ", "), http.StatusNotAcceptable)
+		return
+	}
 
-	s, err := dbm.GetStats(db, acc)
+	s, err := accountStats(r.Context(), db, acc)
 	if err != nil {
 		http.Error(w, "failed to get stats", http.StatusInternalServerError)
 		return
 	}
+	writeStats(w, mediaType, s)
+}
+
+// accountStats combines the account's closed trades with its open positions
+// valued at the latest prices.
+func accountStats(ctx context.Context, db *sql.DB, acc string) (StatsResponse, error) {
+	var s dbm.Stats
+	err := tracing.DB(ctx, "GetStats", func() (err error) {
+		s, err = dbm.GetStats(db, acc)
+		return err
+	})
+	if err != nil {
+		return StatsResponse{}, err
+	}
+
+	var unrealized float64
+	var open, unpriced int
+	err = tracing.DB(ctx, "CalculateUnrealized", func() (err error) {
+		unrealized, open, unpriced, err = CalculateUnrealized(db, acc)
+		return err
+	})
+	if err != nil {
+		return StatsResponse{}, err
+	}
 
-	s.Account = acc
-	s.Profit = math.Round(s.Profit*100) / 100
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"Account":"%s","Trades":%d,"Profit":%.2f}`, s.Account, s.Trades, s.Profit)
+	return StatsResponse{
+		Account:           acc,
+		Trades:            s.Trades,
+		Profit:            math.Round(s.Profit*100) / 100,
+		OpenPositions:     open,
+		UnpricedPositions: unpriced,
+		Unrealized:        math.Round(unrealized*100) / 100,
+		Equity:            math.Round((s.Profit+unrealized)*100) / 100,
+	}, nil
 }
 
 func HandleHealthz(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -125,37 +247,255 @@ func HandleHealthz(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
-func SetupRouter(db *sql.DB) http.Handler {
+type RouterOption func(*routerConfig)
+
+type routerConfig struct {
+	readDB     *sql.DB
+	authns     []Authenticator
+	readAuthns []Authenticator
+	signer     *SignatureVerifier
+	limiters   map[string]*RateLimiter
+	maxPending int
+	logger     *slog.Logger
+	eventPoll  time.Duration
+	heartbeat  time.Duration
+}
+
+// WithReadDB serves read-only endpoints from a separate connection pool so
+// they don't queue behind the single writer connection.
+func WithReadDB(db *sql.DB) RouterOption {
+	return func(c *routerConfig) {
+		c.readDB = db
+	}
+}
+
+// WithAuthenticators requires every endpoint except /healthz to be called
+// with credentials accepted by one of authns.
+func WithAuthenticators(authns ...Authenticator) RouterOption {
+	return func(c *routerConfig) {
+		c.authns = append(c.authns, authns...)
+	}
+}
+
+// WithReadAuthenticators adds authenticators whose identities may only read,
+// such as bearer tokens. They gate reads like WithAuthenticators; writes need
+// credentials only if WithAuthenticators is also given.
+func WithReadAuthenticators(authns ...Authenticator) RouterOption {
+	return func(c *routerConfig) {
+		c.readAuthns = append(c.readAuthns, authns...)
+	}
+}
+
+// WithSignedTrades requires every request that queues trades, positions or
+// prices to carry a valid HMAC signature checked by v.
+func WithSignedTrades(v *SignatureVerifier) RouterOption {
+	return func(c *routerConfig) {
+		c.signer = v
+	}
+}
+
+// WithRateLimits limits each client per route; the keys are the patterns
+// registered by SetupRouter, such as "/trades" or "/stats/". A gRPC server
+// given the same option shares the buckets of the matching routes.
+func WithRateLimits(limits map[string]RateLimit) RouterOption {
+	limiters := make(map[string]*RateLimiter, len(limits))
+	for route, l := range limits {
+		limiters[route] = NewRateLimiter(l)
+	}
+	return func(c *routerConfig) {
+		c.limiters = limiters
+	}
+}
+
+// WithQueueGuard sheds new trades and positions with 503 while more than max
+// rows are pending.
+func WithQueueGuard(max int) RouterOption {
+	return func(c *routerConfig) {
+		c.maxPending = max
+	}
+}
+
+// WithLogger sets the access logger; slog.Default() is used otherwise.
+func WithLogger(l *slog.Logger) RouterOption {
+	return func(c *routerConfig) {
+		c.logger = l
+	}
+}
+
+// WithStreaming sets how often streaming endpoints check for new events and
+// how often they send heartbeats on idle streams.
+func WithStreaming(poll, heartbeat time.Duration) RouterOption {
+	return func(c *routerConfig) {
+		c.eventPoll, c.heartbeat = poll, heartbeat
+	}
+}
+
+func SetupRouter(db *sql.DB, opts ...RouterOption) http.Handler {
+	cfg := routerConfig{
+		readDB:    db,
+		logger:    slog.Default(),
+		eventPoll: 250 * time.Millisecond,
+		heartbeat: 15 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	hub := NewEventHub(cfg.readDB, cfg.eventPoll)
+
 	mux := http.NewServeMux()
 
+	// handle registers h behind the configured rate limit and authentication;
+	// authentication runs first so limits can be keyed by identity.
+	handle := func(pattern string, h http.Handler) {
+		if l, ok := cfg.limiters[pattern]; ok {
+			h = l.Middleware(h)
+		}
+		if len(cfg.authns) > 0 || len(cfg.readAuthns) > 0 {
+			h = RequireAuth(cfg.authns, cfg.readAuthns, h)
+		}
+		mux.Handle(pattern, h)
+	}
+	signed := func(h http.Handler) http.Handler {
+		if cfg.signer == nil {
+			return h
+		}
+		return cfg.signer.Middleware(h)
+	}
+	signedUpload := func(h http.Handler) http.Handler {
+		if cfg.signer == nil {
+			return h
+		}
+		return cfg.signer.SpooledMiddleware(maxImportSize, h)
+	}
+	guarded := func(h http.Handler) http.Handler {
+		if cfg.maxPending <= 0 {
+			return h
+		}
+		return NewQueueGuard(cfg.readDB, cfg.maxPending).Middleware(h)
+	}
+
 	// POST /trades endpoint
-	mux.HandleFunc("/trades", func(w http.ResponseWriter, r *http.Request) {
+	handle("/trades", guarded(signed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		HandleTradeRequest(w, r, db)
-	})
+	}))))
 
-	// GET /stats/{acc} endpoint
-	mux.HandleFunc("/stats/", func(w http.ResponseWriter, r *http.Request) {
-		HandleStatsRequest(w, r, db)
-	})
+	// POST /trades/batch and GET /trades/{id} endpoints
+	handle("/trades/", guarded(signed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleTradeSubrequest(w, r, db, cfg.readDB)
+	}))))
+
+	// GET /trades/feed WebSocket endpoint
+	handle("/trades/feed", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleTradeFeed(w, r, cfg.readDB, hub, cfg.heartbeat)
+	}))
+
+	// GET /stats/{acc} and GET /stats/{acc}/stream endpoints
+	handle("/stats/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acc, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/stats/"), "/stream"); ok {
+			HandleStatsStream(w, r, cfg.readDB, hub, acc, cfg.heartbeat)
+			return
+		}
+		HandleStatsRequest(w, r, cfg.readDB)
+	}))
+
+	// POST /positions and GET /positions?account= endpoints
+	handle("/positions", guarded(signed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandlePositions(w, r, db, cfg.readDB)
+	}))))
+
+	// GET /positions/{id} and POST /positions/{id}/close endpoints
+	handle("/positions/", signed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandlePositionRequest(w, r, db, cfg.readDB)
+	})))
+
+	// GET /events endpoint
+	handle("/events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleEvents(w, r, cfg.readDB, hub)
+	}))
+
+	// POST /imports endpoint
+	handle("/imports", guarded(signedUpload(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleImports(w, r, db, cfg.maxPending)
+	}))))
+
+	// GET /imports/{id} endpoint
+	handle("/imports/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleImportRequest(w, r, cfg.readDB)
+	}))
+
+	// GET /exports/trades and GET /exports/stats endpoints
+	handle("/exports/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleExports(w, r, cfg.readDB)
+	}))
+
+	// GET /webhooks/{id}/deliveries endpoint
+	handle("/webhooks/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebhookRequest(w, r, cfg.readDB)
+	}))
+
+	// POST /prices endpoint
+	handle("/prices", signed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandlePriceRequest(w, r, db)
+	})))
 
 	// GET /healthz endpoint
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		HandleHealthz(w, r, db)
 	})
 
-	return mux
+	// GET /openapi.json endpoint
+	mux.HandleFunc("/openapi.json", HandleOpenAPI)
+
+	route := func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+	return TraceRequests(route, AccessLog(cfg.logger, route, mux))
 }
 
 func main() {
 	// Command line flags
 	dbPath := flag.String("db", "data.db", "path to SQLite database")
 	listenAddr := flag.String("listen", "8080", "HTTP server listen address")
+	grpcListenAddr := flag.String("grpc-listen", "", "gRPC server listen port, e.g. 9090 (empty disables)")
+	requireAPIKey := flag.Bool("require-api-key", false, "require an API key (see brokerctl keys) on every endpoint except /healthz")
+	jwtJWKS := flag.String("jwt-jwks", "", "JWKS file with keys for verifying bearer tokens")
+	jwtPublicKey := flag.String("jwt-public-key", "", "PEM RSA/ECDSA public key for verifying bearer tokens")
+	jwtSecret := flag.String("jwt-hmac-secret-file", "", "file holding an HS256 secret for verifying bearer tokens")
+	jwtIssuer := flag.String("jwt-issuer", "", "required iss claim of bearer tokens")
+	jwtAudience := flag.String("jwt-audience", "", "required aud claim of bearer tokens")
+	jwtAccountsClaim := flag.String("jwt-accounts-claim", "accounts", "claim listing the accounts a bearer token may read")
+	signingSecrets := flag.String("signing-secrets", "", "JSON file of client id to secret; when set, trade, position, price and import submissions must be HMAC signed")
+	signatureWindow := flag.Duration("signature-window", 5*time.Minute, "maximum clock skew for signed requests")
+	rateLimits := flag.String("rate-limit", "", `per-client limits per route, e.g. "/trades=10:20,/stats/=50" (requests/s[:burst])`)
+	maxPending := flag.Int("max-pending", 0, "reject new trades with 503, and hold imports, while more rows are pending (0 disables)")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate to serve HTTPS with; reloaded when the file changes")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM CA bundle for verifying client certificates (mTLS)")
+	tlsClientIDs := flag.String("tls-client-identities", "", "JSON file mapping client certificate common names to accounts and permissions")
+	pricesFile := flag.String("prices-file", "", "replay newline-delimited POST /prices payloads from this file on startup")
+	fixListen := flag.String("fix-listen", "", "FIX 4.4 acceptor listen port, e.g. 9878 (empty disables)")
+	fixCompID := flag.String("fix-comp-id", "BROKER", "SenderCompID of the FIX acceptor")
+	fixSessions := flag.String("fix-sessions", "", "JSON file mapping FIX counterparty SenderCompIDs to accounts, permissions and password")
+	traceExporter := flag.String("trace-exporter", "none", "export trace spans: none, stdout or otlp (OTEL_EXPORTER_OTLP_ENDPOINT)")
+	var logFlags logging.Flags
+	logFlags.Register(flag.CommandLine)
 	flag.Parse()
 
+	if err := logFlags.Setup(os.Stderr); err != nil {
+		log.Fatalf("%v", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), *traceExporter, "broker-server", os.Stdout)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database connection
 	db, err := InitDatabase(*dbPath)
 	if err != nil {
@@ -163,13 +503,159 @@ func main() {
 	}
 	defer db.Close()
 
+	// Imports are read by the process that accepted them; one that stopped
+	// part way through will not be finished.
+	if n, err := dbm.AbandonImports(db); err != nil {
+		log.Fatalf("failed to fail interrupted imports: %v", err)
+	} else if n > 0 {
+		slog.Warn("failed interrupted imports", "count", n)
+	}
+
+	if *pricesFile != "" {
+		f, err := os.Open(*pricesFile)
+		if err != nil {
+			log.Fatalf("failed to open prices file: %v", err)
+		}
+		n, err := ReplayPrices(db, f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("failed to replay prices: %v", err)
+		}
+		slog.Info("replayed prices", "count", n, "file", *pricesFile)
+	}
+
+	readDB, err := dbm.Open(*dbPath, dbm.Options{ReadOnly: true})
+	if err != nil {
+		log.Fatalf("failed to open read pool: %v", err)
+	}
+	defer readDB.Close()
+
 	// Set up router with handlers
-	mux := SetupRouter(db)
+	opts := []RouterOption{WithReadDB(readDB)}
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		certs, err := NewCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		var clientCAs *x509.CertPool
+		if *tlsClientCA != "" {
+			if clientCAs, err = LoadClientCAs(*tlsClientCA); err != nil {
+				log.Fatalf("failed to load client CAs: %v", err)
+			}
+		}
+		tlsConfig = ServerTLSConfig(certs, clientCAs)
+	}
+	if *tlsClientIDs != "" {
+		if tlsConfig == nil || tlsConfig.ClientCAs == nil {
+			log.Fatalf("-tls-client-identities needs -tls-cert, -tls-key and -tls-client-ca")
+		}
+		ids, err := LoadClientIdentities(*tlsClientIDs)
+		if err != nil {
+			log.Fatalf("failed to load client identities: %v", err)
+		}
+		opts = append(opts, WithAuthenticators(ClientCertAuthenticator(ids)))
+	}
+	if *requireAPIKey {
+		opts = append(opts, WithAuthenticators(APIKeyAuthenticator(readDB)))
+	}
+	jwtKeys, err := LoadJWTKeys(*jwtJWKS, *jwtPublicKey, *jwtSecret)
+	if err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
+	}
+	if len(jwtKeys) > 0 {
+		verifier := &jwt.Verifier{
+			Keys:     jwtKeys,
+			Issuer:   *jwtIssuer,
+			Audience: *jwtAudience,
+			Leeway:   30 * time.Second,
+		}
+		opts = append(opts, WithReadAuthenticators(JWTAuthenticator(verifier, *jwtAccountsClaim)))
+	}
+	if *signingSecrets != "" {
+		secrets, err := LoadSigningSecrets(*signingSecrets)
+		if err != nil {
+			log.Fatalf("failed to load signing secrets: %v", err)
+		}
+		opts = append(opts, WithSignedTrades(NewSignatureVerifier(secrets, *signatureWindow)))
+	}
+	if *rateLimits != "" {
+		limits, err := ParseRateLimits(*rateLimits)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		opts = append(opts, WithRateLimits(limits))
+	}
+	if *maxPending > 0 {
+		opts = append(opts, WithQueueGuard(*maxPending))
+	}
+	mux := SetupRouter(db, opts...)
+
+	if *grpcListenAddr != "" {
+		// gRPC calls carry no HMAC signature, so they would bypass it
+		if *signingSecrets != "" {
+			log.Fatalf("-grpc-listen can't be used with -signing-secrets")
+		}
+		var creds credentials.TransportCredentials
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		grpcServer, hs := NewGRPCServer(db, creds, opts...)
+		go WatchHealth(context.Background(), db, hs, 5*time.Second)
+
+		grpcAddr := fmt.Sprintf(":%s", *grpcListenAddr)
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatalf("failed to listen for gRPC: %v", err)
+		}
+		slog.Info("starting gRPC server", "addr", grpcAddr, "tls", creds != nil)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
+	if *fixListen != "" {
+		if *fixSessions == "" {
+			log.Fatalf("-fix-listen needs -fix-sessions")
+		}
+		sessions, err := LoadFIXSessions(*fixSessions)
+		if err != nil {
+			log.Fatalf("failed to load FIX sessions: %v", err)
+		}
+		for remote, cp := range sessions {
+			if cp.Password == "" && (tlsConfig == nil || tlsConfig.ClientCAs == nil) {
+				log.Fatalf("FIX session %s needs a password, or -tls-client-ca for client certificates", remote)
+			}
+		}
+		fixAddr := fmt.Sprintf(":%s", *fixListen)
+		lis, err := net.Listen("tcp", fixAddr)
+		if err != nil {
+			log.Fatalf("failed to listen for FIX: %v", err)
+		}
+		if tlsConfig != nil {
+			lis = tls.NewListener(lis, tlsConfig)
+		}
+		slog.Info("starting FIX acceptor", "addr", fixAddr, "comp_id", *fixCompID, "tls", tlsConfig != nil)
+		go func() {
+			if err := NewFIXAcceptor(db, *fixCompID, sessions).Serve(lis); err != nil {
+				log.Fatalf("FIX acceptor failed: %v", err)
+			}
+		}()
+	}
 
 	// Start server
 	serverAddr := fmt.Sprintf(":%s", *listenAddr)
-	log.Printf("Starting server on %s", serverAddr)
-	if err := http.ListenAndServe(serverAddr, mux); err != nil {
+	srv := &http.Server{Addr: serverAddr, Handler: mux, TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		slog.Info("starting HTTPS server", "addr", serverAddr)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("starting server", "addr", serverAddr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -364,3 +365,35 @@ func TestEndToEndTradeFlow(t *testing.T) {
 		t.Errorf("after stats = %+v", s)
 	}
 }
+
+func TestSetupRouterWithReadDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "router.db")
+	db, err := InitDatabase(path)
+	if err != nil {
+		t.Fatalf("InitDatabase failed: %v", err)
+	}
+	defer db.Close()
+	readDB, err := dbm.Open(path, dbm.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open read pool failed: %v", err)
+	}
+	defer readDB.Close()
+
+	if err := dbm.UpdateStats(db, "acc1", 42); err != nil {
+		t.Fatalf("UpdateStats failed: %v", err)
+	}
+
+	srv := httptest.NewServer(SetupRouter(db, WithReadDB(readDB)))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/stats/acc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s dbm.Stats
+	json.NewDecoder(res.Body).Decode(&s)
+	res.Body.Close()
+	if s.Trades != 1 || s.Profit != 42 {
+		t.Errorf("stats via read pool = %+v", s)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+// openAPIDoc is the part of an OpenAPI 3.1 document the contract test reads.
+type openAPIDoc struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas    map[string]any             `json:"schemas"`
+		Responses  map[string]openAPIResponse `json:"responses"`
+		Parameters map[string]openAPIParam    `json:"parameters"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParam             `json:"parameters"`
+	RequestBody *openAPIRequestBody        `json:"requestBody"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+// json returns the body's JSON media type; bodies like file uploads have
+// none and are not validated.
+func (b *openAPIRequestBody) json() (openAPIMediaType, bool) {
+	if b == nil {
+		return openAPIMediaType{}, false
+	}
+	media, ok := b.Content["application/json"]
+	return media, ok
+}
+
+type openAPIParam struct {
+	Ref      string `json:"$ref"`
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+}
+
+type openAPIResponse struct {
+	Ref     string                      `json:"$ref"`
+	Content map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema any `json:"schema"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return &doc
+}
+
+// operation finds the path template and operation serving method and path.
+func (doc *openAPIDoc) operation(method, path string) (string, *openAPIOperation) {
+	if op, ok := doc.Paths[path][strings.ToLower(method)]; ok {
+		return path, &op
+	}
+	for tmpl, ops := range doc.Paths {
+		re := "^" + regexp.MustCompile(`\\\{[^}]+\\\}`).ReplaceAllString(regexp.QuoteMeta(tmpl), `[^/]+`) + "$"
+		if !regexp.MustCompile(re).MatchString(path) {
+			continue
+		}
+		if op, ok := ops[strings.ToLower(method)]; ok {
+			return tmpl, &op
+		}
+	}
+	return "", nil
+}
+
+func (doc *openAPIDoc) response(op *openAPIOperation, status int) (openAPIResponse, bool) {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if ok && resp.Ref != "" {
+		resp, ok = doc.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+	}
+	return resp, ok
+}
+
+func (doc *openAPIDoc) param(p openAPIParam) openAPIParam {
+	if p.Ref != "" {
+		return doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+	return p
+}
+
+// validate checks v, decoded from JSON, against the subset of JSON Schema
+// used in openapi.json.
+func (doc *openAPIDoc) validate(schema any, v any, at string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: schema is not an object", at)
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		return doc.validate(doc.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")], v, at)
+	}
+
+	if typ, ok := s["type"].(string); ok {
+		if err := checkType(typ, v); err != nil {
+			return fmt.Errorf("%s: %v", at, err)
+		}
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if e == v {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v not in %v", at, v, enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		if p, ok := s["pattern"].(string); ok && !regexp.MustCompile(p).MatchString(v) {
+			return fmt.Errorf("%s: %q does not match %s", at, v, p)
+		}
+		if n, ok := s["minLength"].(float64); ok && len(v) < int(n) {
+			return fmt.Errorf("%s: shorter than %v", at, n)
+		}
+		if n, ok := s["maxLength"].(float64); ok && len(v) > int(n) {
+			return fmt.Errorf("%s: longer than %v", at, n)
+		}
+		if s["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return fmt.Errorf("%s: %v", at, err)
+			}
+		}
+	case float64:
+		if n, ok := s["minimum"].(float64); ok && v < n {
+			return fmt.Errorf("%s: %v < %v", at, v, n)
+		}
+		if n, ok := s["exclusiveMinimum"].(float64); ok && v <= n {
+			return fmt.Errorf("%s: %v <= %v", at, v, n)
+		}
+	case []any:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				if err := doc.validate(items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		props, _ := s["properties"].(map[string]any)
+		if required, ok := s["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := v[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing %s", at, name)
+				}
+			}
+		}
+		for name, pv := range v {
+			ps, ok := props[name]
+			if !ok {
+				if s["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %s", at, name)
+				}
+				continue
+			}
+			if err := doc.validate(ps, pv, at+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkType(typ string, v any) error {
+	ok := false
+	switch typ {
+	case "object":
+		_, ok = v.(map[string]any)
+	case "array":
+		_, ok = v.([]any)
+	case "string":
+		_, ok = v.(string)
+	case "boolean":
+		_, ok = v.(bool)
+	case "number":
+		_, ok = v.(float64)
+	case "integer":
+		f, isNum := v.(float64)
+		ok = isNum && f == float64(int64(f))
+	}
+	if !ok {
+		return fmt.Errorf("%v is not %s", v, typ)
+	}
+	return nil
+}
+
+// TestOpenAPIContract sends requests for every documented operation through
+// SetupRouter and checks both sides against openapi.json: request bodies the
+// handler accepts must be valid against the spec and ones it rejects with
+// 400 must not be, and every response must be documented with a matching
+// content type and schema.
+func TestOpenAPIContract(t *testing.T) {
+	doc := loadOpenAPI(t)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi = %q", doc.OpenAPI)
+	}
+
+	db := setupTestDB(t)
+	defer db.Close()
+	router := SetupRouter(db)
+
+	// an applied position for the read endpoints
+	dbm.EnqueuePositionEvent(db, dbm.PositionEvent{Position: "pos-1", Kind: dbm.PositionOpen, Account: "acc1", Symbol: "EURUSD", Side: "buy", Volume: 1, Price: 1.1})
+	events, _ := dbm.FetchPendingPositionEvents(db)
+	for _, ev := range events {
+		if err := dbm.ApplyPositionEvent(db, ev); err != nil {
+			t.Fatalf("ApplyPositionEvent: %v", err)
+		}
+	}
+	if _, err := dbm.CreateWebhook(db, "http://receiver.invalid/hook", []string{dbm.EventStatsUpdated}, nil); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	dbm.UpdateStats(db, "acc1", 12.5)
+
+	tests := []struct {
+		method, path, accept, body string
+		status                     int
+	}{
+		{"POST", "/trades", "", `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"buy"}`, 202},
+		{"POST", "/trades", "", `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"side":"sell"}`, 202},
+		{"POST", "/trades", "", `{"account":"acc1","symbol":"eurusd","volume":1,"open":1.1,"close":1.2,"side":"buy"}`, 400},
+		{"POST", "/trades", "", `{"account":"acc1","symbol":"EURUSD","volume":0,"open":1.1,"close":1.2,"side":"buy"}`, 400},
+		{"POST", "/trades", "", `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"hold"}`, 400},
+		{"GET", "/trades", "", "", 405},
+		{"POST", "/trades/batch", "", `{"trades":[{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"buy"},{"account":"acc2","symbol":"GBPUSD","volume":2,"open":1.3,"close":1.2,"side":"sell"}]}`, 202},
+		{"POST", "/trades/batch", "", `{"trades":[{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"side":"buy"}]}`, 400},
+		{"GET", "/trades/batch", "", "", 405},
+		{"GET", "/trades/1", "", "", 200},
+		{"GET", "/trades/999", "", "", 404},
+		{"GET", "/trades/feed", "", "", 426},
+		{"GET", "/stats/acc1", "", "", 200},
+		{"GET", "/stats/acc1", "application/vnd.broker.v2+json", "", 200},
+		{"GET", "/stats/acc1", "application/vnd.broker.v1+json", "", 200},
+		{"GET", "/stats/acc1", "text/csv", "", 200},
+		{"GET", "/stats/acc1", "image/png", "", 406},
+		{"GET", "/stats/acc1/stream", "", "", 200},
+		{"POST", "/stats/acc1/stream", "", "", 405},
+		{"POST", "/stats/acc1", "", "", 405},
+		{"GET", "/positions?account=acc1", "", "", 200},
+		{"GET", "/positions", "", "", 400},
+		{"POST", "/positions", "", `{"id":"pos-2","account":"acc1","symbol":"GBPUSD","volume":0.5,"open":1.3,"side":"sell"}`, 202},
+		{"POST", "/positions", "", `{"account":"acc1","symbol":"GBPUSD","volume":0.5,"open":1.3,"side":"sell"}`, 202},
+		{"POST", "/positions", "", `{"account":"acc1","symbol":"GBPUSD","volume":0.5,"open":-1,"side":"sell"}`, 400},
+		{"GET", "/positions/pos-1", "", "", 200},
+		{"GET", "/positions/missing", "", "", 404},
+		{"POST", "/positions/pos-1/close", "", `{"volume":0.5,"close":1.2}`, 202},
+		{"POST", "/positions/pos-1/close", "", `{"close":1.2}`, 202},
+		{"POST", "/positions/pos-1/close", "", `{"volume":0.5,"close":0}`, 400},
+		{"GET", "/events?after=0&limit=2", "", "", 200},
+		{"GET", "/events?after=999&wait=0", "", "", 200},
+		{"GET", "/events?limit=0", "", "", 400},
+		{"POST", "/events", "", "", 405},
+		{"POST", "/imports?account=acc1&name=history.csv", "", "ticket,symbol,side,volume,open,close\n1001,EURUSD,buy,1,1.1,1.2\n", 202},
+		{"POST", "/imports?account=acc1&format=metatrader", "", "", 400},
+		{"POST", "/imports", "", "ticket,symbol,side,volume,open,close\n", 400},
+		{"GET", "/imports", "", "", 405},
+		{"GET", "/imports/1", "", "", 200},
+		{"GET", "/imports/99", "", "", 404},
+		{"POST", "/imports/1", "", "", 405},
+		{"GET", "/exports/trades?account=acc1&status=processed,pending&from=2020-01-01", "", "", 200},
+		{"GET", "/exports/trades?format=ndjson", "", "", 200},
+		{"GET", "/exports/trades?format=parquet&to=2020-01-01T00:00:00Z", "", "", 200},
+		{"GET", "/exports/trades?status=dead", "", "", 400},
+		{"POST", "/exports/trades", "", "", 405},
+		{"GET", "/exports/stats?format=ndjson&at=2030-01-01", "", "", 200},
+		{"GET", "/exports/stats?format=xlsx", "", "", 400},
+		{"POST", "/exports/stats", "", "", 405},
+		{"GET", "/webhooks/1/deliveries", "", "", 200},
+		{"GET", "/webhooks/1/deliveries?limit=0", "", "", 400},
+		{"GET", "/webhooks/9/deliveries", "", "", 404},
+		{"POST", "/webhooks/1/deliveries", "", "", 405},
+		{"POST", "/prices", "", `{"symbol":"EURUSD","bid":1.1,"ask":1.1002,"timestamp":"2024-01-02T15:04:05Z"}`, 204},
+		{"POST", "/prices", "", `{"symbol":"EURUSD","bid":0,"ask":1.1002,"timestamp":"2024-01-02T15:04:05Z"}`, 400},
+		{"GET", "/healthz", "", "", 200},
+		{"POST", "/healthz", "", "", 405},
+		{"GET", "/openapi.json", "", "", 200},
+	}
+
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		name := tt.method + " " + tt.path
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if strings.HasSuffix(tt.path, "/stream") {
+			// end the stream once its first event is written
+			ctx, cancel := context.WithCancel(req.Context())
+			cancel()
+			req = req.WithContext(ctx)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", name, rr.Code, tt.status, rr.Body.String())
+			continue
+		}
+
+		tmpl, op := doc.operation(tt.method, req.URL.Path)
+		if op == nil {
+			if tt.status != http.StatusMethodNotAllowed {
+				t.Errorf("%s: not in openapi.json", name)
+			}
+			continue
+		}
+		covered[strings.ToLower(tt.method)+" "+tmpl] = true
+
+		for _, p := range op.Parameters {
+			p = doc.param(p)
+			if p.In == "query" && p.Required && tt.status < 400 && req.URL.Query().Get(p.Name) == "" {
+				t.Errorf("%s: accepted without required query parameter %s", name, p.Name)
+			}
+		}
+
+		if media, ok := op.RequestBody.json(); ok && tt.body != "" {
+			var body any
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatalf("%s: test body: %v", name, err)
+			}
+			err := doc.validate(media.Schema, body, "request")
+			if tt.status < 300 && err != nil {
+				t.Errorf("%s: handler accepted a body the spec rejects: %v", name, err)
+			}
+			if tt.status == http.StatusBadRequest && err == nil {
+				t.Errorf("%s: handler rejected a body the spec allows", name)
+			}
+		}
+
+		resp, ok := doc.response(op, rr.Code)
+		if !ok {
+			t.Errorf("%s: status %d not documented", name, rr.Code)
+			continue
+		}
+		ct := rr.Header().Get("Content-Type")
+		if ct == "" && rr.Body.Len() == 0 {
+			continue
+		}
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		content, ok := resp.Content[mediaType]
+		if !ok {
+			t.Errorf("%s: content type %q not documented for %d", name, mediaType, rr.Code)
+			continue
+		}
+		if mediaType == "application/x-ndjson" {
+			// the schema is that of each line
+			for i, line := range bytes.Split(bytes.TrimSpace(rr.Body.Bytes()), []byte("\n")) {
+				if len(line) == 0 {
+					continue
+				}
+				var body any
+				if err := json.Unmarshal(line, &body); err != nil {
+					t.Errorf("%s: invalid JSON line %d: %v", name, i+1, err)
+				} else if err := doc.validate(content.Schema, body, fmt.Sprintf("response line %d", i+1)); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+			continue
+		}
+		if !strings.HasSuffix(mediaType, "json") {
+			continue
+		}
+		var body any
+		if err := json.Unmarshal(bytes.TrimSpace(rr.Body.Bytes()), &body); err != nil {
+			t.Errorf("%s: invalid JSON response: %v", name, err)
+			continue
+		}
+		if err := doc.validate(content.Schema, body, "response"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	var missing []string
+	for tmpl, ops := range doc.Paths {
+		for method := range ops {
+			if !covered[method+" "+tmpl] {
+				missing = append(missing, method+" "+tmpl)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("operations not exercised: %v", missing)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+var positionIDRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// PositionRequest opens a position. ID is the caller's position identifier;
+// the server generates one when it is empty.
+type PositionRequest struct {
+	ID      string  `json:"id"`
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Volume  float64 `json:"volume"`
+	Open    float64 `json:"open"`
+	Side    string  `json:"side"`
+}
+
+// ClosePositionRequest closes Volume of a position at Close. A zero or
+// missing volume closes the remaining volume.
+type ClosePositionRequest struct {
+	Volume float64 `json:"volume"`
+	Close  float64 `json:"close"`
+}
+
+type PositionResponse struct {
+	ID        string  `json:"id"`
+	Account   string  `json:"account"`
+	Symbol    string  `json:"symbol"`
+	Side      string  `json:"side"`
+	Volume    float64 `json:"volume"`
+	Remaining float64 `json:"remaining"`
+	Open      float64 `json:"open"`
+	Realized  float64 `json:"realized"`
+	Status    string  `json:"status"`
+}
+
+type PositionsResponse struct {
+	Account   string             `json:"account"`
+	Open      int                `json:"open"`
+	Volume    float64            `json:"volume"`
+	Realized  float64            `json:"realized"`
+	Positions []PositionResponse `json:"positions"`
+}
+
+func NewPositionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "pos-" + hex.EncodeToString(b), nil
+}
+
+func ValidatePositionRequest(req PositionRequest) error {
+	if !positionIDRe.MatchString(req.ID) {
+		return fmt.Errorf("%w: position id must match %s", trade.ErrInvalid, positionIDRe)
+	}
+	return trade.Position{
+		ID:      req.ID,
+		Account: req.Account,
+		Symbol:  req.Symbol,
+		Side:    req.Side,
+		Volume:  req.Volume,
+		Open:    req.Open,
+	}.Validate()
+}
+
+func ValidateClosePositionRequest(req ClosePositionRequest) error {
+	if !(req.Close > 0) {
+		return fmt.Errorf("%w: close must be > 0", trade.ErrInvalid)
+	}
+	if req.Volume < 0 || math.IsNaN(req.Volume) {
+		return fmt.Errorf("%w: volume must be >= 0", trade.ErrInvalid)
+	}
+	return nil
+}
+
+// HandlePositions serves POST /positions and GET /positions?account=.
+func HandlePositions(w http.ResponseWriter, r *http.Request, db, readDB *sql.DB) {
+	switch r.Method {
+	case http.MethodPost:
+		HandleOpenPosition(w, r, db)
+	case http.MethodGet:
+		HandleListPositions(w, r, readDB)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func HandleOpenPosition(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var req PositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, req.Account, dbm.PermWrite) {
+		return
+	}
+	if req.ID == "" {
+		id, err := NewPositionID()
+		if err != nil {
+			http.Error(w, "failed to generate position id", http.StatusInternalServerError)
+			return
+		}
+		req.ID = id
+	}
+	if err := ValidatePositionRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := enqueuePositionEvent(r, db, dbm.PositionEvent{
+		Position: req.ID,
+		Kind:     dbm.PositionOpen,
+		Account:  req.Account,
+		Symbol:   req.Symbol,
+		Side:     req.Side,
+		Volume:   req.Volume,
+		Price:    req.Open,
+	})
+	if errors.Is(err, dbm.ErrPositionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to enqueue position", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": req.ID})
+}
+
+func HandleListPositions(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	acc := r.URL.Query().Get("account")
+	if acc == "" {
+		http.Error(w, "account not specified", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, acc, dbm.PermRead) {
+		return
+	}
+
+	var summary dbm.PositionSummary
+	var positions []dbm.Position
+	err := tracing.DB(r.Context(), "ListPositions", func() (err error) {
+		if summary, err = dbm.GetPositionSummary(db, acc); err != nil {
+			return err
+		}
+		positions, err = dbm.ListPositions(db, acc)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "failed to get positions", http.StatusInternalServerError)
+		return
+	}
+
+	resp := PositionsResponse{
+		Account:   acc,
+		Open:      summary.Open,
+		Volume:    summary.Volume,
+		Realized:  math.Round(summary.Realized*100) / 100,
+		Positions: []PositionResponse{},
+	}
+	for _, p := range positions {
+		resp.Positions = append(resp.Positions, positionResponse(p))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandlePositionRequest serves GET /positions/{id} and POST /positions/{id}/close.
+func HandlePositionRequest(w http.ResponseWriter, r *http.Request, db, readDB *sql.DB) {
+	rest := strings.TrimPrefix(r.URL.Path, "/positions/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" {
+		http.Error(w, "position not specified", http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var p dbm.Position
+		err := tracing.DB(r.Context(), "GetPosition", func() (err error) {
+			p, err = dbm.GetPosition(readDB, id)
+			return err
+		})
+		if errors.Is(err, dbm.ErrPositionNotFound) {
+			http.Error(w, "position not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to get position", http.StatusInternalServerError)
+			return
+		}
+		if !authorize(w, r, p.Account, dbm.PermRead) {
+			return
+		}
+		writeJSON(w, http.StatusOK, positionResponse(p))
+	case "close":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		HandleClosePosition(w, r, db, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func HandleClosePosition(w http.ResponseWriter, r *http.Request, db *sql.DB, id string) {
+	var req ClosePositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := ValidateClosePositionRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The open event may still be queued, so existence and remaining volume
+	// are checked by the worker when it applies the close. Authenticated
+	// callers need the owning account, which is known once the open is queued.
+	if _, ok := IdentityFromContext(r.Context()); ok {
+		var acc string
+		err := tracing.DB(r.Context(), "PositionAccount", func() (err error) {
+			acc, err = dbm.PositionAccount(db, id)
+			return err
+		})
+		if errors.Is(err, dbm.ErrPositionNotFound) {
+			http.Error(w, "position not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to get position", http.StatusInternalServerError)
+			return
+		}
+		if !authorize(w, r, acc, dbm.PermWrite) {
+			return
+		}
+	}
+
+	if err := enqueuePositionEvent(r, db, dbm.PositionEvent{
+		Position: id,
+		Kind:     dbm.PositionClose,
+		Volume:   req.Volume,
+		Price:    req.Close,
+	}); err != nil {
+		http.Error(w, "failed to enqueue close", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id})
+}
+
+// enqueuePositionEvent queues ev tagged with r's request ID and trace context.
+func enqueuePositionEvent(r *http.Request, db *sql.DB, ev dbm.PositionEvent) error {
+	ev.RequestID = RequestIDFromContext(r.Context())
+	ev.TraceParent = tracing.Inject(r.Context())
+	return tracing.DB(r.Context(), "EnqueuePositionEvent", func() error {
+		return dbm.EnqueuePositionEvent(db, ev)
+	})
+}
+
+func positionResponse(p dbm.Position) PositionResponse {
+	status := "closed"
+	if p.IsOpen() {
+		status = "open"
+	}
+	return PositionResponse{
+		ID:        p.ID,
+		Account:   p.Account,
+		Symbol:    p.Symbol,
+		Side:      p.Side,
+		Volume:    p.Volume,
+		Remaining: p.Remaining,
+		Open:      p.Open,
+		Realized:  math.Round(p.Realized*100) / 100,
+		Status:    status,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
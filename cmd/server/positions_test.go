@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestValidatePositionRequest(t *testing.T) {
+	valid := PositionRequest{ID: "p1", Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Side: "buy"}
+	if err := ValidatePositionRequest(valid); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+	bad := valid
+	bad.ID = "has/slash"
+	if err := ValidatePositionRequest(bad); err == nil {
+		t.Error("expected error for id with slash")
+	}
+	bad = valid
+	bad.Open = 0
+	if err := ValidatePositionRequest(bad); err == nil {
+		t.Error("expected error for zero open price")
+	}
+	if err := ValidateClosePositionRequest(ClosePositionRequest{Close: 1.2}); err != nil {
+		t.Errorf("full close rejected: %v", err)
+	}
+	if err := ValidateClosePositionRequest(ClosePositionRequest{Volume: -1, Close: 1.2}); err == nil {
+		t.Error("expected error for negative volume")
+	}
+	if err := ValidateClosePositionRequest(ClosePositionRequest{Volume: 1}); err == nil {
+		t.Error("expected error for missing close price")
+	}
+}
+
+func TestPositionEndpoints(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	srv := httptest.NewServer(SetupRouter(db))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/positions", "application/json",
+		strings.NewReader(`{"account":"acc1","symbol":"EURUSD","volume":1.0,"open":1.1,"side":"buy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened map[string]string
+	json.NewDecoder(res.Body).Decode(&opened)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || opened["id"] == "" {
+		t.Fatalf("open: status %d, body %v", res.StatusCode, opened)
+	}
+	id := opened["id"]
+
+	res, err = http.Post(srv.URL+"/positions", "application/json",
+		strings.NewReader(`{"id":"`+id+`","account":"acc2","symbol":"EURUSD","volume":1.0,"open":1.1,"side":"buy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict || strings.TrimSpace(string(body)) != dbm.ErrPositionExists.Error() {
+		t.Errorf("open reusing a queued id: status %d, body %q", res.StatusCode, body)
+	}
+
+	res, err = http.Post(srv.URL+"/positions", "application/json",
+		strings.NewReader(`{"account":"acc1","symbol":"eurusd","volume":1.0,"open":1.1,"side":"buy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid open: status %d", res.StatusCode)
+	}
+
+	res, err = http.Post(srv.URL+"/positions/"+id+"/close", "application/json", strings.NewReader(`{"volume":0.4,"close":1.2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("close: status %d", res.StatusCode)
+	}
+
+	res, _ = http.Get(srv.URL + "/positions/" + id)
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("position before worker run: status %d", res.StatusCode)
+	}
+
+	// what the worker does on its next poll
+	events, err := dbm.FetchPendingPositionEvents(db)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("pending events: %v, %d", err, len(events))
+	}
+	for _, ev := range events {
+		if err := dbm.ApplyPositionEvent(db, ev); err != nil {
+			t.Fatalf("ApplyPositionEvent failed: %v", err)
+		}
+	}
+
+	res, err = http.Get(srv.URL + "/positions/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p PositionResponse
+	json.NewDecoder(res.Body).Decode(&p)
+	res.Body.Close()
+	if p.Status != "open" || p.Remaining != 0.6 || p.Realized != 4000 {
+		t.Errorf("unexpected position: %+v", p)
+	}
+
+	res, err = http.Get(srv.URL + "/positions?account=acc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list PositionsResponse
+	json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if list.Open != 1 || len(list.Positions) != 1 || list.Realized != 4000 {
+		t.Errorf("unexpected position list: %+v", list)
+	}
+
+	res, _ = http.Get(srv.URL + "/positions")
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("list without account: status %d", res.StatusCode)
+	}
+	res, _ = http.Get(srv.URL + "/positions/" + id + "/close")
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET close: status %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+type PriceRequest struct {
+	Symbol    string    `json:"symbol"`
+	Bid       float64   `json:"bid"`
+	Ask       float64   `json:"ask"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (req PriceRequest) Quote() trade.Quote {
+	return trade.Quote{
+		Symbol: req.Symbol,
+		Bid:    req.Bid,
+		Ask:    req.Ask,
+		Time:   req.Timestamp,
+	}
+}
+
+func HandlePriceRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// quotes affect every account's equity
+	if !authorize(w, r, dbm.AllAccounts, dbm.PermWrite) {
+		return
+	}
+
+	var req PriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	q := req.Quote()
+	if err := q.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := tracing.DB(r.Context(), "UpsertQuote", func() error {
+		return dbm.UpsertQuote(db, q)
+	}); err != nil {
+		http.Error(w, "failed to store price", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReplayPrices stores a stream of POST /prices payloads, one JSON object per
+// line, and returns how many quotes were stored.
+func ReplayPrices(db *sql.DB, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	n := 0
+	for {
+		var req PriceRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("price %d: %v", n+1, err)
+		}
+		q := req.Quote()
+		if err := q.Validate(); err != nil {
+			return n, fmt.Errorf("price %d: %v", n+1, err)
+		}
+		if err := dbm.UpsertQuote(db, q); err != nil {
+			return n, fmt.Errorf("price %d: %v", n+1, err)
+		}
+		n++
+	}
+}
+
+// CalculateUnrealized values the account's open positions at the latest
+// quotes. Positions whose symbol has no quote yet are left out of the total
+// and counted in unpriced.
+func CalculateUnrealized(db *sql.DB, account string) (unrealized float64, open, unpriced int, err error) {
+	positions, err := dbm.ListPositions(db, account)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	quotes := map[string]trade.Quote{}
+	for _, p := range positions {
+		if !p.IsOpen() {
+			continue
+		}
+		open++
+		q, ok := quotes[p.Symbol]
+		if !ok {
+			q, err = dbm.GetQuote(db, p.Symbol)
+			if errors.Is(err, dbm.ErrNoQuote) {
+				unpriced++
+				continue
+			}
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			quotes[p.Symbol] = q
+		}
+		unrealized += p.Unrealized(q)
+	}
+	return unrealized, open, unpriced, nil
+}
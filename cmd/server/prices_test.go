@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestHandlePriceRequest(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest("POST", "/prices", strings.NewReader(`{"symbol":"EURUSD","bid":1.1,"ask":1.1002,"timestamp":"2024-05-01T10:00:00Z"}`))
+	w := httptest.NewRecorder()
+	HandlePriceRequest(w, req, db)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status NoContent; got %v", w.Code)
+	}
+	q, err := dbm.GetQuote(db, "EURUSD")
+	if err != nil || q.Bid != 1.1 || q.Ask != 1.1002 {
+		t.Errorf("stored quote = %+v, %v", q, err)
+	}
+
+	req = httptest.NewRequest("POST", "/prices", strings.NewReader(`{"symbol":"EURUSD","bid":1.2,"ask":1.1,"timestamp":"2024-05-01T10:00:01Z"}`))
+	w = httptest.NewRecorder()
+	HandlePriceRequest(w, req, db)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for crossed quote; got %v", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/prices", nil)
+	w = httptest.NewRecorder()
+	HandlePriceRequest(w, req, db)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status MethodNotAllowed; got %v", w.Code)
+	}
+}
+
+func TestReplayPrices(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	feed := `{"symbol":"EURUSD","bid":1.1,"ask":1.2,"timestamp":"2024-05-01T10:00:00Z"}
+{"symbol":"GBPUSD","bid":1.3,"ask":1.4,"timestamp":"2024-05-01T10:00:00Z"}
+{"symbol":"EURUSD","bid":1.15,"ask":1.25,"timestamp":"2024-05-01T10:00:01Z"}
+`
+	n, err := ReplayPrices(db, strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("ReplayPrices failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ReplayPrices() = %d, want 3", n)
+	}
+	q, _ := dbm.GetQuote(db, "EURUSD")
+	if q.Bid != 1.15 {
+		t.Errorf("expected latest EURUSD quote, got %+v", q)
+	}
+
+	_, err = ReplayPrices(db, strings.NewReader(`{"symbol":"bad","bid":1,"ask":1,"timestamp":"2024-05-01T10:00:00Z"}`))
+	if err == nil {
+		t.Error("expected error for invalid quote")
+	}
+}
+
+func TestStatsWithUnrealized(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	srv := httptest.NewServer(SetupRouter(db))
+	defer srv.Close()
+
+	// no close price: opens a position
+	res, err := http.Post(srv.URL+"/trades", "application/json",
+		strings.NewReader(`{"account":"acc1","symbol":"EURUSD","volume":1.0,"open":1.1,"side":"buy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened map[string]string
+	json.NewDecoder(res.Body).Decode(&opened)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || opened["position"] == "" {
+		t.Fatalf("open trade: status %d, body %v", res.StatusCode, opened)
+	}
+
+	// an explicit zero close price is still invalid
+	res, _ = http.Post(srv.URL+"/trades", "application/json",
+		strings.NewReader(`{"account":"acc1","symbol":"EURUSD","volume":1.0,"open":1.1,"close":0,"side":"buy"}`))
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("zero close: status %d", res.StatusCode)
+	}
+
+	events, _ := dbm.FetchPendingPositionEvents(db)
+	for _, ev := range events {
+		if err := dbm.ApplyPositionEvent(db, ev); err != nil {
+			t.Fatalf("ApplyPositionEvent failed: %v", err)
+		}
+	}
+	dbm.UpdateStats(db, "acc1", 100)
+
+	var stats struct {
+		Profit, Unrealized, Equity float64
+		OpenPositions              int
+	}
+	res, _ = http.Get(srv.URL + "/stats/acc1")
+	json.NewDecoder(res.Body).Decode(&stats)
+	res.Body.Close()
+	if stats.OpenPositions != 1 || stats.Unrealized != 0 || stats.Equity != 100 {
+		t.Errorf("stats before any quote = %+v", stats)
+	}
+	if s, err := accountStats(context.Background(), db, "acc1"); err != nil || s.UnpricedPositions != 1 {
+		t.Errorf("unpriced positions before any quote = %+v, %v", s, err)
+	}
+
+	res, _ = http.Post(srv.URL+"/prices", "application/json",
+		strings.NewReader(`{"symbol":"EURUSD","bid":1.105,"ask":1.106,"timestamp":"2024-05-01T10:00:00Z"}`))
+	res.Body.Close()
+
+	res, _ = http.Get(srv.URL + "/stats/acc1")
+	json.NewDecoder(res.Body).Decode(&stats)
+	res.Body.Close()
+	if stats.Unrealized != 500 || stats.Equity != 600 || stats.Profit != 100 {
+		t.Errorf("stats after quote = %+v", stats)
+	}
+	if s, err := accountStats(context.Background(), db, "acc1"); err != nil || s.UnpricedPositions != 0 {
+		t.Errorf("unpriced positions after quote = %+v, %v", s, err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+// RateLimit is a token bucket refilled at Rate tokens per second holding at
+// most Burst tokens.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimits parses per-route limits such as "/trades=10:20,/stats/=50",
+// where each route is a SetupRouter pattern followed by requests per second
+// and an optional burst that defaults to the rate.
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(spec, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("invalid rate limit %q: want route=rate[:burst]", spec)
+		}
+		rateStr, burstStr, hasBurst := strings.Cut(value, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || !(rate > 0) {
+			return nil, fmt.Errorf("invalid rate in %q", spec)
+		}
+		burst := int(math.Ceil(rate))
+		if hasBurst {
+			burst, err = strconv.Atoi(burstStr)
+			if err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid burst in %q", spec)
+			}
+		}
+		limits[route] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps one token bucket per client.
+type RateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until a token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= 10000 {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely; they are equivalent to
+// a new bucket.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware answers 429 with Retry-After once the caller's bucket is empty.
+// Authenticated callers are limited per identity, others per client IP.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.Allow(clientKey(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientKey(r *http.Request) string {
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		return id.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// QueueGuard sheds submissions with 503 while more than Max rows wait in the
+// queues. The depth is cached for a short while so a flood of requests
+// doesn't turn into a flood of COUNT queries.
+type QueueGuard struct {
+	db  *sql.DB
+	max int
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	depth   int
+	checked time.Time
+}
+
+func NewQueueGuard(db *sql.DB, max int) *QueueGuard {
+	return &QueueGuard{db: db, max: max, ttl: 250 * time.Millisecond, now: time.Now}
+}
+
+func (g *QueueGuard) pending() (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if !g.checked.IsZero() && now.Sub(g.checked) < g.ttl {
+		return g.depth, nil
+	}
+	depth, err := dbm.CountPending(g.db)
+	if err != nil {
+		return 0, err
+	}
+	g.depth, g.checked = depth, now
+	return depth, nil
+}
+
+// Full reports whether more than the maximum number of rows are pending.
+func (g *QueueGuard) Full() (bool, error) {
+	depth, err := g.pending()
+	return depth > g.max, err
+}
+
+func (g *QueueGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		full, err := g.Full()
+		if err != nil {
+			http.Error(w, "failed to check queue depth", http.StatusInternalServerError)
+			return
+		}
+		if full {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
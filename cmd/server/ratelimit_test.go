@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("/trades=10:20, /stats/=2.5")
+	if err != nil {
+		t.Fatalf("ParseRateLimits: %v", err)
+	}
+	if got := limits["/trades"]; got != (RateLimit{Rate: 10, Burst: 20}) {
+		t.Errorf("/trades = %+v", got)
+	}
+	if got := limits["/stats/"]; got != (RateLimit{Rate: 2.5, Burst: 3}) {
+		t.Errorf("/stats/ = %+v", got)
+	}
+
+	for _, bad := range []string{"/trades", "/trades=0", "/trades=x", "/trades=1:0", "=1"} {
+		if _, err := ParseRateLimits(bad); err == nil {
+			t.Errorf("ParseRateLimits(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewRateLimiter(RateLimit{Rate: 2, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("Allow after burst = %v, %v; want false, 500ms", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("other client was limited")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("token not refilled")
+	}
+}
+
+func TestRateLimitedRoute(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	router := SetupRouter(db, WithRateLimits(map[string]RateLimit{"/stats/": {Rate: 1, Burst: 1}}))
+	get := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stats/acc1", nil)
+		req.RemoteAddr = remote
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("10.0.0.1:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rr.Code)
+	}
+	rr := get("10.0.0.1:5678")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q", rr.Header().Get("Retry-After"))
+	}
+	if rr := get("10.0.0.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("other client: status %d", rr.Code)
+	}
+
+	// routes without a limit are unaffected
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("healthz: status %d", rr.Code)
+		}
+	}
+}
+
+func TestQueueGuard(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	router := SetupRouter(db, WithQueueGuard(1))
+	post := func() int {
+		body := `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"buy"}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trades", strings.NewReader(body)))
+		return rr.Code
+	}
+
+	if code := post(); code != http.StatusAccepted {
+		t.Fatalf("first trade: status %d", code)
+	}
+	if code := post(); code != http.StatusAccepted {
+		t.Fatalf("second trade: status %d", code)
+	}
+
+	g := NewQueueGuard(db, 1)
+	rr := httptest.NewRecorder()
+	g.Middleware(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trades", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("full queue: status %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	if n, err := dbm.CountPending(db); err != nil || n != 2 {
+		t.Fatalf("CountPending = %d, %v; want 2", n, err)
+	}
+}
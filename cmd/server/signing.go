@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderClientID  = "X-Client-Id"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+
+	maxSignedBody = 1 << 20
+)
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrUnknownClient    = errors.New("unknown client")
+	ErrStaleRequest     = errors.New("timestamp outside the allowed window")
+	ErrReplayedRequest  = errors.New("nonce already used")
+	ErrBadSignature     = errors.New("invalid signature")
+)
+
+// SignRequest returns the hex HMAC-SHA256 a client sends in X-Signature.
+// The signed string is the method, escaped path, canonical query, unix
+// timestamp, nonce and body joined by newlines. The canonical query is the
+// query's parameters sorted by name and escaped, as url.Values.Encode
+// writes them, so the order a client sends them in does not matter.
+func SignRequest(secret []byte, method, path, query string, timestamp int64, nonce string, body []byte) string {
+	mac := requestMAC(secret, method, path, query, timestamp, nonce)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// requestMAC returns the HMAC of a request's signed string up to its body.
+func requestMAC(secret []byte, method, path, query string, timestamp int64, nonce string) hash.Hash {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d\n%s\n", method, path, canonicalQuery(query), timestamp, nonce)
+	return mac
+}
+
+// canonicalQuery returns the signed form of a raw query. Pairs that do not
+// parse are left out, as they are of the values handlers read.
+func canonicalQuery(query string) string {
+	values, _ := url.ParseQuery(query)
+	return values.Encode()
+}
+
+// LoadSigningSecrets reads a JSON object mapping client ids to secrets.
+func LoadSigningSecrets(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %v", path, err)
+	}
+	return secrets, nil
+}
+
+// SignatureVerifier checks signed requests. A request is accepted once: its
+// timestamp must be within Window of the server clock and its nonce must not
+// have been seen from the same client while that timestamp is still valid.
+type SignatureVerifier struct {
+	secrets map[string][]byte
+	window  time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewSignatureVerifier(secrets map[string]string, window time.Duration) *SignatureVerifier {
+	v := &SignatureVerifier{
+		secrets: make(map[string][]byte, len(secrets)),
+		window:  window,
+		now:     time.Now,
+		nonces:  map[string]time.Time{},
+	}
+	for client, secret := range secrets {
+		v.secrets[client] = []byte(secret)
+	}
+	return v
+}
+
+func (v *SignatureVerifier) Verify(r *http.Request, body []byte) error {
+	return v.verify(r, bytes.NewReader(body))
+}
+
+// verify checks the signature of r over the body read from body.
+func (v *SignatureVerifier) verify(r *http.Request, body io.Reader) error {
+	client := r.Header.Get(HeaderClientID)
+	sig := r.Header.Get(HeaderSignature)
+	nonce := r.Header.Get(HeaderNonce)
+	tsHeader := r.Header.Get(HeaderTimestamp)
+	if client == "" || sig == "" || nonce == "" || tsHeader == "" {
+		return ErrMissingSignature
+	}
+	secret, ok := v.secrets[client]
+	if !ok {
+		return ErrUnknownClient
+	}
+
+	ts, err := strconv.ParseInt(tsHeader, 10, 64)
+	if err != nil {
+		return ErrStaleRequest
+	}
+	now := v.now()
+	sent := time.Unix(ts, 0)
+	if sent.Before(now.Add(-v.window)) || sent.After(now.Add(v.window)) {
+		return ErrStaleRequest
+	}
+
+	mac := requestMAC(secret, r.Method, r.URL.EscapedPath(), r.URL.RawQuery, ts, nonce)
+	if _, err := io.Copy(mac, body); err != nil {
+		return err
+	}
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(strings.ToLower(sig)), []byte(want)) {
+		return ErrBadSignature
+	}
+
+	return v.useNonce(client+"\n"+nonce, sent.Add(v.window), now)
+}
+
+func (v *SignatureVerifier) useNonce(key string, expires, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if exp, ok := v.nonces[key]; ok && exp.After(now) {
+		return ErrReplayedRequest
+	}
+	// Nonces only need remembering while their timestamp would pass the
+	// window check; drop expired ones as the cache grows.
+	if len(v.nonces) >= 1024 {
+		for k, exp := range v.nonces {
+			if !exp.After(now) {
+				delete(v.nonces, k)
+			}
+		}
+	}
+	v.nonces[key] = expires
+	return nil
+}
+
+// Middleware rejects requests that modify state unless they are signed.
+func (v *SignatureVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBody))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := v.Verify(r, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SpooledMiddleware is Middleware for bodies of up to max bytes, too large
+// to hold in memory: the body is spooled to a temporary file, verified from
+// there and read by next from the start of it.
+func (v *SignatureVerifier) SpooledMiddleware(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		f, err := os.CreateTemp("", "broker-signed-*")
+		if err != nil {
+			http.Error(w, "failed to store request body", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, max))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "failed to store request body", http.StatusInternalServerError)
+			return
+		}
+		if err := v.verify(r, f); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "failed to store request body", http.StatusInternalServerError)
+			return
+		}
+
+		r.Body = f
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func signedTradeRequest(secret, client, nonce string, ts time.Time, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/trades", strings.NewReader(body))
+	req.Header.Set(HeaderClientID, client)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set(HeaderSignature, SignRequest([]byte(secret), "POST", "/trades", "", ts.Unix(), nonce, []byte(body)))
+	return req
+}
+
+func withQuery(req *http.Request, query string) *http.Request {
+	req.URL.RawQuery = query
+	return req
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := NewSignatureVerifier(map[string]string{"gw": "s3cret"}, time.Minute)
+	v.now = func() time.Time { return now }
+
+	body := `{"account":"acc1"}`
+	if err := v.Verify(signedTradeRequest("s3cret", "gw", "n1", now, body), []byte(body)); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		body string
+		want error
+	}{
+		{"replayed nonce", signedTradeRequest("s3cret", "gw", "n1", now, body), body, ErrReplayedRequest},
+		{"stale", signedTradeRequest("s3cret", "gw", "n2", now.Add(-2*time.Minute), body), body, ErrStaleRequest},
+		{"future", signedTradeRequest("s3cret", "gw", "n3", now.Add(2*time.Minute), body), body, ErrStaleRequest},
+		{"wrong secret", signedTradeRequest("other", "gw", "n4", now, body), body, ErrBadSignature},
+		{"tampered body", signedTradeRequest("s3cret", "gw", "n5", now, body), `{"account":"acc2"}`, ErrBadSignature},
+		{"unknown client", signedTradeRequest("s3cret", "nobody", "n6", now, body), body, ErrUnknownClient},
+		{"unsigned", httptest.NewRequest("POST", "/trades", strings.NewReader(body)), body, ErrMissingSignature},
+		{"added query", withQuery(signedTradeRequest("s3cret", "gw", "n7", now, body), "account=acc2"), body, ErrBadSignature},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := v.Verify(tc.req, []byte(tc.body)); !errors.Is(err, tc.want) {
+				t.Errorf("Verify() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// the query is signed in canonical form, whatever order it is sent in
+	req := withQuery(signedTradeRequest("s3cret", "gw", "n8", now, body), "b=2&a=1&a=0")
+	req.Header.Set(HeaderSignature, SignRequest([]byte("s3cret"), "POST", "/trades", "a=1&a=0&b=2", now.Unix(), "n8", []byte(body)))
+	if err := v.Verify(req, []byte(body)); err != nil {
+		t.Errorf("reordered query rejected: %v", err)
+	}
+
+	// a nonce is free again once its timestamp has left the window
+	now = now.Add(2 * time.Minute)
+	if err := v.Verify(signedTradeRequest("s3cret", "gw", "n1", now, body), []byte(body)); err != nil {
+		t.Errorf("reused expired nonce rejected: %v", err)
+	}
+}
+
+func TestSignedTradesRouter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	router := SetupRouter(db, WithSignedTrades(NewSignatureVerifier(map[string]string{"gw": "s3cret"}, time.Minute)))
+	body := `{"account":"acc1","symbol":"EURUSD","volume":1.0,"open":1.1,"close":1.2,"side":"buy"}`
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedTradeRequest("s3cret", "gw", "n1", time.Now(), body))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("signed trade: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/trades", strings.NewReader(body)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned trade: status %d", w.Code)
+	}
+
+	for _, path := range []string{"/trades/batch", "/positions", "/positions/p1/close", "/prices", "/imports?account=acc1"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(`{}`)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("unsigned POST %s: status %d", path, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/acc1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("stats should not need a signature: status %d", w.Code)
+	}
+}
+
+func TestSignedImports(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	router := SetupRouter(db, WithSignedTrades(NewSignatureVerifier(map[string]string{"gw": "s3cret"}, time.Minute)))
+
+	// Import files may be larger than other signed bodies.
+	var csv strings.Builder
+	csv.WriteString("ticket,symbol,side,volume,open,close,comment\n")
+	for i := 1; csv.Len() <= maxSignedBody; i++ {
+		fmt.Fprintf(&csv, "%d,EURUSD,buy,1,1.1,1.2,%s\n", i, strings.Repeat("x", 1000))
+	}
+	body := csv.String()
+	// Every request is signed for acc1; query is what is actually sent.
+	post := func(secret, nonce, query string) *httptest.ResponseRecorder {
+		now := time.Now()
+		req := httptest.NewRequest("POST", "/imports?"+query, strings.NewReader(body))
+		req.Header.Set(HeaderClientID, "gw")
+		req.Header.Set(HeaderNonce, nonce)
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(HeaderSignature, SignRequest([]byte(secret), "POST", "/imports", "account=acc1", now.Unix(), nonce, []byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("wrong", "n1", "account=acc1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("import signed with the wrong secret: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("s3cret", "n2", "account=acc2"); w.Code != http.StatusUnauthorized {
+		t.Errorf("import signed for acc1 sent to acc2: status %d: %s", w.Code, w.Body.String())
+	}
+	w := post("s3cret", "n3", "account=acc1")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("signed import: status %d: %s", w.Code, w.Body.String())
+	}
+	var imp ImportResponse
+	json.Unmarshal(w.Body.Bytes(), &imp)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := dbm.GetImport(db, imp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.State != dbm.ImportRunning {
+			if got.State != dbm.ImportDone || got.Size != int64(len(body)) || got.Rejected != 0 {
+				t.Errorf("import = %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("import still running: %+v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadSigningSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	os.WriteFile(path, []byte(`{"gw":"s3cret"}`), 0o600)
+	secrets, err := LoadSigningSecrets(path)
+	if err != nil || secrets["gw"] != "s3cret" {
+		t.Errorf("LoadSigningSecrets() = %v, %v", secrets, err)
+	}
+	os.WriteFile(path, []byte(`not json`), 0o600)
+	if _, err := LoadSigningSecrets(path); err == nil {
+		t.Error("expected error for invalid file")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types GET /stats/{acc} can answer with. MediaTypeStatsV1, the
+// original capitalized field names, is what clients get without an Accept
+// header or with a wildcard one, so those written before the documented
+// contract keep working; the documented shape has to be asked for by name.
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeStatsV1 = "application/vnd.broker.v1+json"
+	MediaTypeStatsV2 = "application/vnd.broker.v2+json"
+	MediaTypeCSV     = "text/csv"
+)
+
+var statsMediaTypes = []string{MediaTypeStatsV1, MediaTypeJSON, MediaTypeStatsV2, MediaTypeCSV}
+
+// StatsResponse is the body of GET /stats/{acc}. UnpricedPositions counts
+// the open positions whose symbol has no quote yet; they are left out of
+// Unrealized and Equity.
+type StatsResponse struct {
+	Account           string  `json:"account"`
+	Trades            int     `json:"trades"`
+	Profit            float64 `json:"profit"`
+	OpenPositions     int     `json:"open_positions"`
+	UnpricedPositions int     `json:"unpriced_positions"`
+	Unrealized        float64 `json:"unrealized"`
+	Equity            float64 `json:"equity"`
+}
+
+// statsResponseV1 is the shape served before the documented contract was
+// adopted.
+type statsResponseV1 struct {
+	Account       string
+	Trades        int
+	Profit        float64
+	OpenPositions int
+	Unrealized    float64
+	Equity        float64
+}
+
+func writeStats(w http.ResponseWriter, mediaType string, s StatsResponse) {
+	switch mediaType {
+	case MediaTypeCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"account", "trades", "profit", "open_positions", "unrealized", "equity", "unpriced_positions"})
+		cw.Write([]string{
+			s.Account,
+			strconv.Itoa(s.Trades),
+			strconv.FormatFloat(s.Profit, 'f', 2, 64),
+			strconv.Itoa(s.OpenPositions),
+			strconv.FormatFloat(s.Unrealized, 'f', 2, 64),
+			strconv.FormatFloat(s.Equity, 'f', 2, 64),
+			strconv.Itoa(s.UnpricedPositions),
+		})
+		cw.Flush()
+	case MediaTypeStatsV1:
+		w.Header().Set("Content-Type", MediaTypeStatsV1)
+		json.NewEncoder(w).Encode(statsResponseV1{
+			Account:       s.Account,
+			Trades:        s.Trades,
+			Profit:        s.Profit,
+			OpenPositions: s.OpenPositions,
+			Unrealized:    s.Unrealized,
+			Equity:        s.Equity,
+		})
+	default:
+		w.Header().Set("Content-Type", mediaType)
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// negotiate picks the offer the Accept header prefers, breaking ties by the
+// order of offers. Each offer takes the quality of the most specific range
+// that matches it. A missing header accepts the first offer; "" means none
+// is acceptable.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	quality := make([]float64, len(offers))
+	specificity := make([]int, len(offers))
+	for i := range specificity {
+		specificity[i] = -1
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for i, offer := range offers {
+			if m := matchMediaRange(mediaRange, offer); m > specificity[i] {
+				quality[i], specificity[i] = q, m
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for i, offer := range offers {
+		if quality[i] > bestQ {
+			best, bestQ = offer, quality[i]
+		}
+	}
+	return best
+}
+
+// matchMediaRange reports how specifically mediaRange matches offer: 2 for
+// an exact match, 1 for type/*, 0 for */* and -1 for no match.
+func matchMediaRange(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
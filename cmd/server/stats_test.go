@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", MediaTypeStatsV1},
+		{"*/*", MediaTypeStatsV1},
+		{"application/*", MediaTypeStatsV1},
+		{MediaTypeJSON, MediaTypeJSON},
+		{"application/json, */*;q=0.1", MediaTypeJSON},
+		{"text/csv", MediaTypeCSV},
+		{"text/*", MediaTypeCSV},
+		{"application/json;q=0.5, text/csv", MediaTypeCSV},
+		{MediaTypeStatsV1, MediaTypeStatsV1},
+		{"*/*;q=0.1, application/vnd.broker.v1+json", MediaTypeStatsV1},
+		{"text/csv;q=0, */*", MediaTypeStatsV1},
+		{"text/html", ""},
+		{"application/xml, text/csv;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiate(tt.accept, statsMediaTypes); got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestStatsResponseFormats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	acc := `we"ird\acc`
+	dbm.UpdateStats(db, acc, 1234.5)
+	path := "/stats/" + url.PathEscape(acc)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		HandleStatsRequest(w, req, db)
+		return w
+	}
+
+	w := get(MediaTypeJSON)
+	if ct := w.Header().Get("Content-Type"); ct != MediaTypeJSON {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+	}
+	if body["account"] != acc || body["trades"] != 1.0 || body["profit"] != 1234.5 {
+		t.Errorf("body = %v", body)
+	}
+
+	// Clients that don't ask for a media type get the original shape.
+	for _, accept := range []string{"", "*/*", MediaTypeStatsV1} {
+		w = get(accept)
+		if ct := w.Header().Get("Content-Type"); ct != MediaTypeStatsV1 {
+			t.Errorf("Accept %q: Content-Type = %q", accept, ct)
+		}
+		var v1 map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &v1); err != nil {
+			t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+		}
+		if v1["Account"] != acc || v1["Trades"] != 1.0 || v1["OpenPositions"] != 0.0 {
+			t.Errorf("Accept %q: v1 body = %v", accept, v1)
+		}
+	}
+
+	w = get("text/csv")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 2 || records[0][0] != "account" || records[1][0] != acc || records[1][2] != "1234.50" {
+		t.Errorf("csv = %q", records)
+	}
+
+	if w := get("application/xml"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("unsupported Accept: status %d, want 406", w.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+const sseRetry = 2 * time.Second
+
+// HandleStatsStream serves GET /stats/{acc}/stream as Server-Sent Events: a
+// stats snapshot whenever an event for the account is recorded, identified
+// by the event's ID. A client reconnecting with Last-Event-ID gets a fresh
+// snapshot only if the account changed since, and comment lines are sent
+// every heartbeat so proxies keep idle streams open.
+func HandleStatsStream(w http.ResponseWriter, r *http.Request, db *sql.DB, hub *EventHub, acc string, heartbeat time.Duration) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if acc == "" {
+		http.Error(w, "account not specified", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, acc, dbm.PermRead) {
+		return
+	}
+
+	// Subscribe before reading the latest ID so no event falls in between.
+	events, cancel, err := hub.Subscribe(acc)
+	if err != nil {
+		http.Error(w, "failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+	defer cancel()
+
+	var latest int64
+	err = tracing.DB(r.Context(), "LatestEventID", func() (err error) {
+		latest, err = dbm.LatestEventID(db, acc)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "failed to get events", http.StatusInternalServerError)
+		return
+	}
+	sent := int64(-1)
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		sent = id
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+
+	rc := http.NewResponseController(w)
+	send := func(id int64) error {
+		s, err := accountStats(r.Context(), db, acc)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: stats\ndata: %s\n\n", id, b); err != nil {
+			return err
+		}
+		sent = id
+		return rc.Flush()
+	}
+
+	if latest > sent {
+		err = send(latest)
+	} else {
+		err = rc.Flush()
+	}
+	if err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case ev, ok := <-events:
+			if !ok {
+				// Too far behind; the client resumes from the last ID sent.
+				return
+			}
+			// One snapshot covers every event already waiting.
+			for drained := false; !drained; {
+				select {
+				case next, ok := <-events:
+					if !ok {
+						drained = true
+						break
+					}
+					ev = next
+				default:
+					drained = true
+				}
+			}
+			if ev.ID <= sent {
+				continue
+			}
+			// A change records several events in one transaction; label the
+			// snapshot with the last one so the others don't repeat it.
+			latest, err := dbm.LatestEventID(db, acc)
+			if err != nil {
+				return
+			}
+			if err := send(max(latest, ev.ID)); err != nil {
+				return
+			}
+		}
+	}
+}
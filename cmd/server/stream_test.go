@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+type sseMessage struct {
+	id, event, data, comment string
+}
+
+// readSSE returns the next message or comment on the stream, skipping the
+// retry field.
+func readSSE(t *testing.T, br *bufio.Reader) sseMessage {
+	t.Helper()
+	var m sseMessage
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if m != (sseMessage{}) {
+				return m
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			m.comment = value
+		case "id":
+			m.id = value
+		case "event":
+			m.event = value
+		case "data":
+			m.data = value
+		}
+	}
+}
+
+func openStatsStream(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(res.Body)
+}
+
+func TestStatsStream(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	srv := httptest.NewServer(SetupRouter(db, WithStreaming(5*time.Millisecond, 200*time.Millisecond)))
+	// Registered before the streams so their bodies are closed first.
+	t.Cleanup(srv.Close)
+
+	apply := func(acc string, profit float64) {
+		t.Helper()
+		ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{{Account: acc, Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: acc}, profit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := openStatsStream(t, srv.URL+"/stats/acc1/stream", "")
+	first := readSSE(t, stream)
+	if first.event != "stats" || first.id != "0" || !strings.Contains(first.data, `"trades":0`) {
+		t.Fatalf("initial snapshot = %+v", first)
+	}
+
+	apply("acc2", 5)
+	apply("acc1", 10)
+	update := readSSE(t, stream)
+	var s StatsResponse
+	if err := json.Unmarshal([]byte(update.data), &s); err != nil {
+		t.Fatal(err)
+	}
+	if update.event != "stats" || s.Account != "acc1" || s.Trades != 1 || s.Profit != 10 {
+		t.Fatalf("update = %+v", update)
+	}
+	if hb := readSSE(t, stream); hb.comment != "heartbeat" {
+		t.Errorf("idle stream sent %+v, want heartbeat", hb)
+	}
+
+	// Resuming at the last ID sends nothing until the next change...
+	resumed := openStatsStream(t, srv.URL+"/stats/acc1/stream", update.id)
+	if m := readSSE(t, resumed); m.comment != "heartbeat" {
+		t.Errorf("up-to-date resume sent %+v, want heartbeat", m)
+	}
+	// ...while resuming from before it gets a fresh snapshot at once.
+	stale := openStatsStream(t, srv.URL+"/stats/acc1/stream", "0")
+	if m := readSSE(t, stale); m.id != update.id || !strings.Contains(m.data, `"trades":1`) {
+		t.Errorf("stale resume sent %+v, want snapshot %s", m, update.id)
+	}
+}
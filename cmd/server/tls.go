@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate/key pair from disk and picks up new files
+// when either one changes, so certificates can be rotated without a restart.
+// A pair that fails to load is logged and the previous one kept.
+type CertReloader struct {
+	certFile, keyFile string
+	// checkEvery limits how often the files are stat'ed during handshakes.
+	checkEvery time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+	checked time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		checkEvery: time.Second,
+		now:        time.Now,
+	}
+	mod, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(mod); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) modTimes() ([2]time.Time, error) {
+	var mod [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return mod, err
+		}
+		mod[i] = fi.ModTime()
+	}
+	return mod, nil
+}
+
+func (r *CertReloader) load(mod [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %v", err)
+	}
+	r.cert = &cert
+	r.modTime = mod
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checked) < r.checkEvery {
+		return r.cert, nil
+	}
+	r.checked = now
+
+	mod, err := r.modTimes()
+	if err != nil {
+		slog.Warn("keeping current certificate", "err", err)
+		return r.cert, nil
+	}
+	if mod != r.modTime {
+		if err := r.load(mod); err != nil {
+			slog.Warn("keeping current certificate", "err", err)
+		} else {
+			slog.Info("reloaded certificate", "file", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// LoadClientCAs reads the PEM certificates client certificates must chain to.
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig serves certificates from certs. With a client CA pool,
+// clients may present a certificate, which is verified against it;
+// ClientCertAuthenticator then turns it into an identity.
+func ServerTLSConfig(certs *CertReloader, clientCAs *x509.CertPool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+// ClientIdentity is what a client certificate may do, as configured for its
+// subject common name.
+type ClientIdentity struct {
+	Accounts    []string `json:"accounts"`
+	Permissions []string `json:"permissions"`
+}
+
+// LoadClientIdentities reads a JSON object mapping client certificate common
+// names to their accounts and permissions.
+func LoadClientIdentities(path string) (map[string]ClientIdentity, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ids map[string]ClientIdentity
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return nil, fmt.Errorf("invalid client identities file %s: %v", path, err)
+	}
+	return ids, nil
+}
+
+// ClientCertAuthenticator accepts verified TLS client certificates whose
+// subject common name is listed in ids.
+func ClientCertAuthenticator(ids map[string]ClientIdentity) Authenticator {
+	return func(r *http.Request) (*Identity, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return nil, nil
+		}
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		id, ok := ids[cn]
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return &Identity{
+			Subject:     "cert:" + cn,
+			Accounts:    id.Accounts,
+			Permissions: id.Permissions,
+		}, nil
+	}
+}
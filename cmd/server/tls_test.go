@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues a certificate for cn signed by parent, or self-signed
+// when parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert, serial int64) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	newTestCert(t, "localhost", nil, 1).write(t, certFile, keyFile)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	first, _ := r.GetCertificate(nil)
+	if first.Leaf.SerialNumber.Int64() != 1 {
+		t.Fatalf("serial %v, want 1", first.Leaf.SerialNumber)
+	}
+
+	newTestCert(t, "localhost", nil, 2).write(t, certFile, keyFile)
+	later := now.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	if c, _ := r.GetCertificate(nil); c != first {
+		t.Fatal("files checked again within checkEvery")
+	}
+	now = now.Add(2 * time.Second)
+	if c, _ := r.GetCertificate(nil); c.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("serial %v after rotation, want 2", c.Leaf.SerialNumber)
+	}
+
+	// a broken pair keeps the current certificate
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	now = now.Add(2 * time.Second)
+	if c, _ := r.GetCertificate(nil); c.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("serial %v after bad rotation, want 2", c.Leaf.SerialNumber)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ca := newTestCert(t, "test-ca", nil, 1)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	newTestCert(t, "localhost", ca, 2).write(t, certFile, keyFile)
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	ids := map[string]ClientIdentity{"desk1": {Accounts: []string{"acc1"}, Permissions: []string{"read"}}}
+	srv := httptest.NewUnstartedServer(SetupRouter(db, WithAuthenticators(ClientCertAuthenticator(ids))))
+	srv.TLS = ServerTLSConfig(certs, clientCAs)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(client *testCert, path string) int {
+		t.Helper()
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		cfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if client != nil {
+			cfg.Certificates = []tls.Certificate{client.tlsCertificate()}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := c.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	desk1 := newTestCert(t, "desk1", ca, 3)
+	if code := get(desk1, "/stats/acc1"); code != http.StatusOK {
+		t.Errorf("desk1 own account: status %d", code)
+	}
+	if code := get(desk1, "/stats/acc2"); code != http.StatusForbidden {
+		t.Errorf("desk1 other account: status %d, want 403", code)
+	}
+	if code := get(newTestCert(t, "desk2", ca, 4), "/stats/acc1"); code != http.StatusUnauthorized {
+		t.Errorf("unmapped subject: status %d, want 401", code)
+	}
+	if code := get(nil, "/stats/acc1"); code != http.StatusUnauthorized {
+		t.Errorf("no client certificate: status %d, want 401", code)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+func TestTradeTraceContextPersisted(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	defer otel.SetTracerProvider(prev)
+
+	db := setupTestDB(t)
+	defer db.Close()
+	router := SetupRouter(db)
+
+	body := `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"buy"}`
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trades", strings.NewReader(body)))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("status %d", rr.Code)
+	}
+
+	spans := exp.GetSpans()
+	var server, enqueue *tracetest.SpanStub
+	for i := range spans {
+		switch spans[i].Name {
+		case "POST /trades":
+			server = &spans[i]
+		case "db.EnqueueTrades":
+			enqueue = &spans[i]
+		}
+	}
+	if server == nil || enqueue == nil {
+		t.Fatalf("missing spans in %d recorded", len(spans))
+	}
+	if server.SpanKind != trace.SpanKindServer || enqueue.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("db span is not a child of the request span")
+	}
+
+	trades, err := dbm.FetchPendingTrades(db)
+	if err != nil || len(trades) != 1 {
+		t.Fatalf("FetchPendingTrades = %v, %v", trades, err)
+	}
+	stored := trace.SpanContextFromContext(tracing.Extract(t.Context(), trades[0].TraceParent))
+	if stored.TraceID() != server.SpanContext.TraceID() || stored.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("stored trace context %q does not point at the request span", trades[0].TraceParent)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+const (
+	// HeaderIdempotencyKey makes a submission safe to retry: trades queued
+	// under a key are returned again instead of being queued twice.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed marks a response to a repeated key.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	maxBatchTrades = 1000
+)
+
+// TradeAcceptedResponse is the body of 202 responses to POST /trades: the
+// queued trade's id, or the position a trade without close opened.
+type TradeAcceptedResponse struct {
+	ID       int    `json:"id,omitempty"`
+	Position string `json:"position,omitempty"`
+}
+
+type TradeBatchRequest struct {
+	Trades []TradeRequest `json:"trades"`
+}
+
+type TradeBatchResponse struct {
+	IDs []int `json:"ids"`
+}
+
+// TradeStatusResponse is the body of GET /trades/{id}.
+type TradeStatusResponse struct {
+	ID      int     `json:"id"`
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Volume  float64 `json:"volume"`
+	Open    float64 `json:"open"`
+	Close   float64 `json:"close"`
+	Side    string  `json:"side"`
+	Status  string  `json:"status"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// HandleTradeSubrequest serves POST /trades/batch and GET /trades/{id}.
+func HandleTradeSubrequest(w http.ResponseWriter, r *http.Request, db, readDB *sql.DB) {
+	rest := strings.TrimPrefix(r.URL.Path, "/trades/")
+	if rest == "batch" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		HandleTradeBatch(w, r, db)
+		return
+	}
+
+	id, err := strconv.Atoi(rest)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	HandleGetTrade(w, r, readDB, id)
+}
+
+// HandleTradeBatch queues closed trades all at once: if any trade is invalid
+// or not writable by the caller, none is queued.
+func HandleTradeBatch(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var req TradeBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Trades) == 0 || len(req.Trades) > maxBatchTrades {
+		http.Error(w, fmt.Sprintf("a batch holds 1 to %d trades", maxBatchTrades), http.StatusBadRequest)
+		return
+	}
+	for i, t := range req.Trades {
+		if !authorize(w, r, t.Account, dbm.PermWrite) {
+			return
+		}
+		if err := ValidateTradeRequest(t); err != nil {
+			http.Error(w, fmt.Sprintf("trade %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+	key, ok := idempotencyKey(w, r)
+	if !ok {
+		return
+	}
+
+	ids, ok := enqueueTrades(w, r, db, req.Trades, key)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusAccepted, TradeBatchResponse{IDs: ids})
+}
+
+func HandleGetTrade(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
+	var t dbm.QueuedTrade
+	err := tracing.DB(r.Context(), "GetTrade", func() (err error) {
+		t, err = dbm.GetTrade(db, id)
+		return err
+	})
+	if errors.Is(err, dbm.ErrTradeNotFound) {
+		http.Error(w, "trade not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get trade", http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, t.Account, dbm.PermRead) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TradeStatusResponse{
+		ID:      t.ID,
+		Account: t.Account,
+		Symbol:  t.Symbol,
+		Volume:  t.Volume,
+		Open:    t.Open,
+		Close:   t.Close,
+		Side:    t.Side,
+		Status:  dbm.StateName(t.State),
+		Reason:  t.Reason,
+	})
+}
+
+// enqueueTrades queues validated trades under key, writing the error
+// response and returning false if that fails. Replays are flagged with
+// HeaderIdempotentReplayed.
+func enqueueTrades(w http.ResponseWriter, r *http.Request, db *sql.DB, reqs []TradeRequest, key string) ([]int, bool) {
+	ids, replayed, err := queueTrades(r.Context(), db, reqs, key)
+	if errors.Is(err, dbm.ErrIdempotencyConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "failed to enqueue trade", http.StatusInternalServerError)
+		return nil, false
+	}
+	if replayed {
+		w.Header().Set(HeaderIdempotentReplayed, "true")
+	}
+	return ids, true
+}
+
+// queueTrades queues validated trades under key, tagged with the request ID
+// and trace of ctx. It is shared by the HTTP and gRPC APIs.
+func queueTrades(ctx context.Context, db *sql.DB, reqs []TradeRequest, key string) (ids []int, replayed bool, err error) {
+	trades := make([]dbm.Trade, len(reqs))
+	for i, req := range reqs {
+		trades[i] = req.Trade()
+		trades[i].RequestID = RequestIDFromContext(ctx)
+		trades[i].TraceParent = tracing.Inject(ctx)
+	}
+	scope := idempotencyScope(ctx, trades[0].Account)
+	err = tracing.DB(ctx, "EnqueueTrades", func() (err error) {
+		ids, replayed, err = dbm.EnqueueTrades(db, trades, scope, key)
+		return err
+	})
+	return ids, replayed, err
+}
+
+// idempotencyKey returns the request's Idempotency-Key, writing 400 and
+// returning false if it is unusable.
+func idempotencyKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key != "" && !validRequestID(key) {
+		http.Error(w, "invalid "+HeaderIdempotencyKey, http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
+
+// idempotencyScope is the namespace of a caller's idempotency keys: its
+// identity, or the account submitted for when it has none.
+func idempotencyScope(ctx context.Context, account string) string {
+	if id, ok := IdentityFromContext(ctx); ok {
+		return id.Subject
+	}
+	return dbm.AccountScope([]dbm.Trade{{Account: account}})
+}
+
+// idempotentPositionID derives the id of a position opened under key in scope.
+func idempotentPositionID(scope, key string) string {
+	sum := sha256.Sum256([]byte(scope + "\n" + key))
+	return "pos-" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+type DeliveryResponse struct {
+	ID          int64           `json:"id"`
+	Offset      int64           `json:"offset"`
+	Type        string          `json:"type"`
+	Account     string          `json:"account"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt *time.Time      `json:"next_attempt,omitempty"`
+	StatusCode  int             `json:"status_code,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type DeliveriesResponse struct {
+	Webhook    int                `json:"webhook"`
+	URL        string             `json:"url"`
+	Disabled   bool               `json:"disabled"`
+	Deliveries []DeliveryResponse `json:"deliveries"`
+}
+
+// HandleWebhookRequest serves GET /webhooks/{id}/deliveries, the latest
+// deliveries to a webhook with the outcome of their last attempt. Callers
+// must be able to read every account the webhook is subscribed to.
+func HandleWebhookRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	rest, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/deliveries")
+	id, err := strconv.Atoi(rest)
+	if !ok || err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultDeliveryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxDeliveryLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxDeliveryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var hook dbm.Webhook
+	err = tracing.DB(r.Context(), "GetWebhook", func() (err error) {
+		hook, err = dbm.GetWebhook(db, id)
+		return err
+	})
+	if errors.Is(err, dbm.ErrWebhookNotFound) {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get webhook", http.StatusInternalServerError)
+		return
+	}
+	for _, acc := range hook.Accounts {
+		if !authorize(w, r, acc, dbm.PermRead) {
+			return
+		}
+	}
+
+	var ds []dbm.Delivery
+	err = tracing.DB(r.Context(), "ListDeliveries", func() (err error) {
+		ds, err = dbm.ListDeliveries(db, id, limit)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	resp := DeliveriesResponse{
+		Webhook:    hook.ID,
+		URL:        hook.URL,
+		Disabled:   hook.DisabledAt != nil,
+		Deliveries: make([]DeliveryResponse, len(ds)),
+	}
+	for i, d := range ds {
+		dr := DeliveryResponse{
+			ID:          d.ID,
+			Offset:      d.EventID,
+			Type:        d.Type,
+			Account:     d.Account,
+			Payload:     d.Payload,
+			CreatedAt:   d.CreatedAt,
+			State:       d.State,
+			Attempts:    d.Attempts,
+			StatusCode:  d.StatusCode,
+			Error:       d.Error,
+			DeliveredAt: d.DeliveredAt,
+		}
+		if d.State == dbm.DeliveryPending {
+			next := d.NextAttempt
+			dr.NextAttempt = &next
+		}
+		resp.Deliveries[i] = dr
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
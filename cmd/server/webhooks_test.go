@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	key, _, err := dbm.CreateAPIKey(db, "crm", []string{"acc1"}, []string{dbm.PermRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine, err := dbm.CreateWebhook(db, "http://crm.example/hook", nil, []string{"acc1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := dbm.CreateWebhook(db, "http://risk.example/hook", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 0, Side: "buy"}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbm.RejectTrade(db, ids[0], "close must be > 0"); err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRouter(db, WithAuthenticators(APIKeyAuthenticator(db)))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "ApiKey "+key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/webhooks/1/deliveries")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp DeliveriesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Webhook != mine.ID || len(resp.Deliveries) != 1 {
+		t.Fatalf("response = %+v", resp)
+	}
+	d := resp.Deliveries[0]
+	var payload dbm.TradeEvent
+	if err := json.Unmarshal(d.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if d.Type != dbm.EventTradeRejected || d.State != dbm.DeliveryPending || d.NextAttempt == nil || payload.Reason != "close must be > 0" {
+		t.Errorf("delivery = %+v, payload %+v", d, payload)
+	}
+
+	// A webhook on every account is only visible to keys for every account.
+	if rr := get("/webhooks/" + strconv.Itoa(all.ID) + "/deliveries"); rr.Code != http.StatusForbidden {
+		t.Errorf("catch-all webhook: status %d, want 403", rr.Code)
+	}
+	if rr := get("/webhooks/1"); rr.Code != http.StatusNotFound {
+		t.Errorf("webhook without /deliveries: status %d, want 404", rr.Code)
+	}
+}
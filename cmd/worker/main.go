@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/logging"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+	"gitlab.com/digineat/go-broker-test/internal/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func InitWorkerDatabase(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := dbm.Open(dbPath, dbm.Options{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -29,39 +39,71 @@ func InitWorkerDatabase(dbPath string) (*sql.DB, error) {
 }
 
 func CalculateProfitFromTrade(t dbm.Trade) float64 {
-	profit := (t.Close - t.Open) * t.Volume * 100000.0
-	if t.Side == "sell" {
-		profit = -profit
-	}
-	return profit
+	return t.Profit()
 }
 
-func ProcessTrade(db *sql.DB, t dbm.Trade) error {
-	profit := CalculateProfitFromTrade(t)
-
-	if err := dbm.UpdateStats(db, t.Account, profit); err != nil {
-		return fmt.Errorf("error updating stats for trade %d: %v", t.ID, err)
+// ProcessTrade re-validates a claimed trade, since rows may come from
+// producers other than the API server, and either applies it to the account's
+// stats or rejects it with the validation failure as the recorded reason.
+// The work is traced as part of the trace the trade was submitted in.
+func ProcessTrade(db *sql.DB, t dbm.Trade) (err error) {
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), t.TraceParent), "worker.ProcessTrade",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Int("trade.id", t.ID), attribute.String("trade.account", t.Account)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if verr := t.Validate(); verr != nil {
+		if err := tracing.DB(ctx, "RejectTrade", func() error {
+			return dbm.RejectTrade(db, t.ID, verr.Error())
+		}); err != nil {
+			return fmt.Errorf("error rejecting trade %d: %w", t.ID, err)
+		}
+		return fmt.Errorf("rejected trade %d: %w", t.ID, verr)
 	}
 
-	if err := dbm.MarkProcessed(db, t.ID); err != nil {
-		return fmt.Errorf("error marking trade %d processed: %v", t.ID, err)
+	if err := tracing.DB(ctx, "ApplyTrade", func() error {
+		return dbm.ApplyTrade(db, t, CalculateProfitFromTrade(t))
+	}); err != nil {
+		return fmt.Errorf("error applying trade %d: %w", t.ID, err)
 	}
 
 	return nil
 }
 
+// processPositionEvent applies ev in the trace it was queued in.
+func processPositionEvent(db *sql.DB, ev dbm.PositionEvent) (err error) {
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), ev.TraceParent), "worker.ProcessPositionEvent",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Int("event.id", ev.ID), attribute.String("position.id", ev.Position)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	return tracing.DB(ctx, "ApplyPositionEvent", func() error {
+		return dbm.ApplyPositionEvent(db, ev)
+	})
+}
+
 func ProcessPendingTrades(db *sql.DB) (int, error) {
-	trades, err := dbm.FetchPendingTrades(db)
+	return ProcessSource(context.Background(), db, QueueSource{DB: db})
+}
+
+func ProcessPendingPositions(db *sql.DB) (int, error) {
+	events, err := dbm.FetchPendingPositionEvents(db)
 	if err != nil {
-		return 0, fmt.Errorf("error fetching trades: %v", err)
+		return 0, fmt.Errorf("error fetching position events: %v", err)
 	}
 
 	processedCount := 0
-	for _, t := range trades {
-		err := ProcessTrade(db, t)
-		if err != nil {
-			log.Printf("%v", err)
-		} else {
+	for _, ev := range events {
+		err := processPositionEvent(db, ev)
+		switch {
+		case errors.Is(err, trade.ErrInvalid):
+			slog.Warn("rejected position event", "event", ev.ID, "kind", ev.Kind, "position", ev.Position, "request_id", ev.RequestID, "err", err)
+		case err != nil:
+			slog.Error("error applying position event", "event", ev.ID, "request_id", ev.RequestID, "err", err)
+		default:
+			slog.Debug("processed position event", "event", ev.ID, "kind", ev.Kind, "position", ev.Position, "request_id", ev.RequestID)
 			processedCount++
 		}
 	}
@@ -69,8 +111,17 @@ func ProcessPendingTrades(db *sql.DB) (int, error) {
 	return processedCount, nil
 }
 
-func RunWorker(db *sql.DB, pollInterval time.Duration, stopChan <-chan struct{}) {
-	log.Printf("Worker started with polling interval: %v", pollInterval)
+// RunWorker processes trades from sources, trades_q alone if none are
+// given, and position events every pollInterval until stopChan is closed.
+func RunWorker(db *sql.DB, pollInterval time.Duration, stopChan <-chan struct{}, sources ...Source) {
+	if len(sources) == 0 {
+		sources = []Source{QueueSource{DB: db}}
+	}
+	names := make([]string, len(sources))
+	for i, src := range sources {
+		names[i] = src.Name()
+	}
+	slog.Info("worker started", "poll", pollInterval, "sources", names)
 
 	timer := time.NewTicker(pollInterval)
 	defer timer.Stop()
@@ -78,14 +129,22 @@ func RunWorker(db *sql.DB, pollInterval time.Duration, stopChan <-chan struct{})
 	for {
 		select {
 		case <-timer.C:
-			processedCount, err := ProcessPendingTrades(db)
+			for _, src := range sources {
+				processedCount, err := ProcessSource(context.Background(), db, src)
+				if err != nil {
+					slog.Error("processing trades", "source", src.Name(), "err", err)
+				} else if processedCount > 0 {
+					slog.Info("processed trades", "source", src.Name(), "count", processedCount)
+				}
+			}
+			processedCount, err := ProcessPendingPositions(db)
 			if err != nil {
-				log.Printf("%v", err)
+				slog.Error("processing position events", "err", err)
 			} else if processedCount > 0 {
-				log.Printf("Processed %d trades", processedCount)
+				slog.Info("processed position events", "count", processedCount)
 			}
 		case <-stopChan:
-			log.Println("Worker stopping")
+			slog.Info("worker stopping")
 			return
 		}
 	}
@@ -94,12 +153,62 @@ func RunWorker(db *sql.DB, pollInterval time.Duration, stopChan <-chan struct{})
 func main() {
 	dbPath := flag.String("db", "data.db", "path to SQLite database")
 	pollInterval := flag.Duration("poll", 100*time.Millisecond, "polling interval")
+	reconcile := flag.Bool("reconcile", false, "compare account_stats with processed trades and exit")
+	fix := flag.Bool("fix", false, "with -reconcile, rewrite account_stats from processed trades")
+	webhookPoll := flag.Duration("webhook-poll", time.Second, "how often to deliver due webhooks (0 disables delivery)")
+	webhookAttempts := flag.Int("webhook-attempts", 10, "attempts before a webhook delivery is marked failed")
+	natsURL := flag.String("nats-url", "", "also consume trades from this NATS server's JetStream (empty disables)")
+	natsStream := flag.String("nats-stream", "TRADES", "JetStream stream trades are published to")
+	natsConsumer := flag.String("nats-consumer", "broker-worker", "durable consumer name, which keeps the position in the stream")
+	natsSubject := flag.String("nats-subject", "", "consume only this subject of the stream")
+	natsBatch := flag.Int("nats-batch", 100, "messages fetched per poll")
+	traceExporter := flag.String("trace-exporter", "none", "export trace spans: none, stdout or otlp (OTEL_EXPORTER_OTLP_ENDPOINT)")
+	var logFlags logging.Flags
+	logFlags.Register(flag.CommandLine)
 	flag.Parse()
+
+	if err := logFlags.Setup(os.Stderr); err != nil {
+		log.Fatalf("%v", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), *traceExporter, "broker-worker", os.Stdout)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	db, err := InitWorkerDatabase(*dbPath)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
 	defer db.Close()
 
-	RunWorker(db, *pollInterval, nil)
+	if *reconcile {
+		n, err := Reconcile(db, *fix, os.Stdout)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if n > 0 && !*fix {
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *webhookPoll > 0 {
+		d := webhook.NewDispatcher(db)
+		d.MaxAttempts = *webhookAttempts
+		go d.Run(context.Background(), *webhookPoll)
+	}
+
+	sources := []Source{QueueSource{DB: db}}
+	if *natsURL != "" {
+		src, err := NewNATSSource(context.Background(), db, *natsURL, *natsStream, *natsConsumer, *natsSubject, *natsBatch)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer src.Close()
+		sources = append(sources, src)
+	}
+
+	RunWorker(db, *pollInterval, nil, sources...)
 }
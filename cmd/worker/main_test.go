@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"strings"
 	"testing"
 	"time"
 
@@ -264,3 +265,75 @@ func TestRunWorker(t *testing.T) {
 		t.Errorf("Expected profit to be 100000, got %f", profit)
 	}
 }
+
+func TestProcessTradeRejectsInvalid(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// a producer other than the API server wrote a lowercase symbol
+	_, err := db.Exec("INSERT INTO trades_q (id, account, symbol, volume, open, close, side, processed) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		1, "acc1", "eurusd", 1.0, 1.0, 2.0, "buy", 0)
+	if err != nil {
+		t.Fatalf("Failed to insert test trade: %v", err)
+	}
+
+	count, err := ProcessPendingTrades(db)
+	if err != nil {
+		t.Fatalf("ProcessPendingTrades() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("ProcessPendingTrades() = %v, want 0", count)
+	}
+
+	var processed int
+	var reason string
+	err = db.QueryRow("SELECT processed, reason FROM trades_q WHERE id = 1").Scan(&processed, &reason)
+	if err != nil {
+		t.Fatalf("Failed to query trade: %v", err)
+	}
+	if processed != dbm.StateRejected {
+		t.Errorf("Expected trade to be rejected, got state %d", processed)
+	}
+	if !strings.Contains(reason, "symbol") {
+		t.Errorf("Expected reason to mention the symbol, got %q", reason)
+	}
+
+	var stats int
+	db.QueryRow("SELECT COUNT(*) FROM account_stats").Scan(&stats)
+	if stats != 0 {
+		t.Errorf("Expected no stats for rejected trade, got %d rows", stats)
+	}
+}
+
+func TestProcessPendingPositions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	events := []dbm.PositionEvent{
+		{Position: "p1", Kind: dbm.PositionOpen, Account: "acc1", Symbol: "ABCDEF", Side: "sell", Volume: 1.0, Price: 2.0},
+		{Position: "p1", Kind: dbm.PositionClose, Volume: 0.5, Price: 1.0},
+		{Position: "missing", Kind: dbm.PositionClose, Price: 1.0},
+	}
+	for _, ev := range events {
+		if err := dbm.EnqueuePositionEvent(db, ev); err != nil {
+			t.Fatalf("Failed to enqueue position event: %v", err)
+		}
+	}
+
+	count, err := ProcessPendingPositions(db)
+	if err != nil {
+		t.Fatalf("ProcessPendingPositions() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("ProcessPendingPositions() = %v, want 2", count)
+	}
+
+	var profit float64
+	err = db.QueryRow("SELECT profit FROM account_stats WHERE account = 'acc1'").Scan(&profit)
+	if err != nil {
+		t.Fatalf("Failed to query stats: %v", err)
+	}
+	if profit != 50000 {
+		t.Errorf("Expected profit to be 50000, got %f", profit)
+	}
+}
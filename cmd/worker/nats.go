@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+// NATSMessage is the JSON body of a trade published to the stream; it has
+// the fields of a POST /trades request.
+type NATSMessage struct {
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Volume  float64 `json:"volume"`
+	Open    float64 `json:"open"`
+	Close   float64 `json:"close"`
+	Side    string  `json:"side"`
+}
+
+// NATSSource consumes trades from a JetStream stream through a durable
+// consumer. Each message is queued in trades_q under an idempotency key
+// derived from its Nats-Msg-Id header, or its stream sequence without one,
+// and acknowledged only after the worker has applied or rejected it. A
+// message redelivered because its ack was lost maps to the same row, which
+// is already processed, so it is never counted twice.
+type NATSSource struct {
+	db     *sql.DB
+	conn   *nats.Conn
+	cons   jetstream.Consumer
+	stream string
+	batch  int
+}
+
+// NewNATSSource connects to url and creates or updates the durable consumer
+// on stream, which must exist. An empty subject consumes every subject of
+// the stream.
+func NewNATSSource(ctx context.Context, db *sql.DB, url, stream, durable, subject string, batch int) (*NATSSource, error) {
+	conn, err := nats.Connect(url, nats.Name("broker-worker"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %v", err)
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       durable,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		FilterSubject: subject,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create consumer %s on stream %s: %v", durable, stream, err)
+	}
+	return &NATSSource{db: db, conn: conn, cons: cons, stream: stream, batch: batch}, nil
+}
+
+func (s *NATSSource) Name() string { return "nats:" + s.stream }
+
+// Fetch queues the messages waiting on the consumer, without waiting for
+// more. Messages that can never become a trade are terminated.
+func (s *NATSSource) Fetch(ctx context.Context) ([]Delivery, error) {
+	batch, err := s.cons.FetchNoWait(s.batch)
+	if err != nil {
+		return nil, err
+	}
+
+	var ds []Delivery
+	for msg := range batch.Messages() {
+		d, err := s.queue(ctx, msg)
+		if errors.Is(err, errUnusableMessage) {
+			slog.Warn("dropping NATS message", "source", s.Name(), "subject", msg.Subject(), "err", err)
+			if err := msg.Term(); err != nil {
+				slog.Error("terminating NATS message", "source", s.Name(), "err", err)
+			}
+			continue
+		}
+		if err != nil {
+			// The rest of the batch is redelivered once its ack wait is over.
+			msg.Nak()
+			return ds, err
+		}
+		ds = append(ds, d)
+	}
+	return ds, batch.Error()
+}
+
+var errUnusableMessage = errors.New("unusable message")
+
+func (s *NATSSource) queue(ctx context.Context, msg jetstream.Msg) (Delivery, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return Delivery{}, fmt.Errorf("%w: %v", errUnusableMessage, err)
+	}
+	var m NATSMessage
+	if err := json.Unmarshal(msg.Data(), &m); err != nil {
+		return Delivery{}, fmt.Errorf("%w: invalid JSON: %v", errUnusableMessage, err)
+	}
+
+	key := "nats:" + s.stream + ":seq:" + strconv.FormatUint(meta.Sequence.Stream, 10)
+	if id := msg.Headers().Get(nats.MsgIdHdr); id != "" {
+		key = "nats:" + s.stream + ":id:" + id
+	}
+	t := dbm.Trade{
+		Account:     m.Account,
+		Symbol:      m.Symbol,
+		Volume:      m.Volume,
+		Open:        m.Open,
+		Close:       m.Close,
+		Side:        m.Side,
+		RequestID:   msg.Headers().Get("X-Request-ID"),
+		TraceParent: msg.Headers().Get("traceparent"),
+	}
+	ids, _, err := dbm.EnqueueTrades(s.db, []dbm.Trade{t}, "", key)
+	if errors.Is(err, dbm.ErrIdempotencyConflict) {
+		return Delivery{}, fmt.Errorf("%w: %s was used for a different trade", errUnusableMessage, key)
+	}
+	if err != nil {
+		return Delivery{}, fmt.Errorf("error queueing message %d: %v", meta.Sequence.Stream, err)
+	}
+	t.ID = ids[0]
+	// The ack is confirmed by the server so the consumer's position only
+	// moves once the trade is committed.
+	ack := func() error { return msg.DoubleAck(ctx) }
+	return Delivery{Trade: t, Ack: ack, Nak: msg.Nak}, nil
+}
+
+func (s *NATSSource) Close() error {
+	return s.conn.Drain()
+}
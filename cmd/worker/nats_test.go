@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+// startJetStream runs an in-process NATS server with JetStream and a TRADES
+// stream on trades.>.
+func startJetStream(t *testing.T) (string, jetstream.JetStream) {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("starting NATS: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS not ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "TRADES", Subjects: []string{"trades.>"}}); err != nil {
+		t.Fatalf("CreateStream: %v", err)
+	}
+	return ns.ClientURL(), js
+}
+
+func TestNATSSource(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	url, js := startJetStream(t)
+	ctx := context.Background()
+
+	publish := func(id, body string) {
+		t.Helper()
+		var opts []jetstream.PublishOpt
+		if id != "" {
+			opts = append(opts, jetstream.WithMsgID(id))
+		}
+		if _, err := js.Publish(ctx, "trades.fills", []byte(body), opts...); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	publish("fill-1", `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.0,"close":1.5,"side":"buy"}`)
+	publish("", `{"account":"acc1","symbol":"eurusd","volume":1,"open":1.1,"close":1.2,"side":"buy"}`)
+	publish("", `not json`)
+
+	src, err := NewNATSSource(ctx, db, url, "TRADES", "worker", "", 10)
+	if err != nil {
+		t.Fatalf("NewNATSSource: %v", err)
+	}
+	t.Cleanup(func() { src.Close() })
+
+	n, err := ProcessSource(ctx, db, src)
+	if err != nil || n != 1 {
+		t.Fatalf("ProcessSource = %d, %v; want 1 applied", n, err)
+	}
+	stats, _ := dbm.GetStats(db, "acc1")
+	if stats.Trades != 1 || stats.Profit != 50000 {
+		t.Errorf("stats after first batch = %+v", stats)
+	}
+	rejected, err := dbm.GetTrade(db, 2)
+	if err != nil || rejected.State != dbm.StateRejected || rejected.Reason == "" {
+		t.Errorf("invalid message = %+v, %v; want a rejected row", rejected, err)
+	}
+
+	// The worker applies a trade but dies before its ack reaches the
+	// server: the redelivered message is acknowledged without being
+	// counted again.
+	publish("fill-2", `{"account":"acc1","symbol":"EURUSD","volume":1,"open":1.5,"close":1.0,"side":"sell"}`)
+	ds, err := src.Fetch(ctx)
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("Fetch = %+v, %v", ds, err)
+	}
+	if err := ProcessTrade(db, ds[0].Trade); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds[0].Nak(); err != nil {
+		t.Fatal(err)
+	}
+	// Process until the consumer has nothing left; terminations are not
+	// confirmed, so this may take a few rounds.
+	seen := &recordingSource{Source: src}
+	applied := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := ProcessSource(ctx, db, seen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applied += n
+		info, err := src.cons.Info(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.NumPending == 0 && info.NumAckPending == 0 && slices.Contains(seen.ids, ds[0].Trade.ID) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consumer has %d pending and %d unacknowledged messages; delivered %v", info.NumPending, info.NumAckPending, seen.ids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if applied != 0 {
+		t.Errorf("redelivery applied %d trades, want 0", applied)
+	}
+	stats, _ = dbm.GetStats(db, "acc1")
+	if stats.Trades != 2 || stats.Profit != 100000 {
+		t.Errorf("stats after redelivery = %+v", stats)
+	}
+}
+
+// recordingSource records the trades a Source delivers.
+type recordingSource struct {
+	Source
+	ids []int
+}
+
+func (s *recordingSource) Fetch(ctx context.Context) ([]Delivery, error) {
+	ds, err := s.Source.Fetch(ctx)
+	for _, d := range ds {
+		s.ids = append(s.ids, d.Trade.ID)
+	}
+	return ds, err
+}
+
+func TestRunWorkerSources(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	url, js := startJetStream(t)
+
+	if _, err := js.Publish(context.Background(), "trades.fills", []byte(`{"account":"acc2","symbol":"EURUSD","volume":1,"open":1.1,"close":1.2,"side":"buy"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dbm.EnqueueTrades(db, []dbm.Trade{{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewNATSSource(context.Background(), db, url, "TRADES", "worker", "trades.fills", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.Close() })
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunWorker(db, 10*time.Millisecond, stop, QueueSource{DB: db}, src)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s1, _ := dbm.GetStats(db, "acc1")
+		s2, _ := dbm.GetStats(db, "acc2")
+		if s1.Trades == 1 && s2.Trades == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v, %+v; want a trade from each source", s1, s2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+}
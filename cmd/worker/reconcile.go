@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+// DiffStats compares stored account_stats rows with rows rebuilt from trades
+// and returns the differing accounts as unified-diff style lines. Both inputs
+// must be sorted by account. Profits are compared at cent precision, the same
+// precision GET /stats reports.
+func DiffStats(stored, rebuilt []dbm.Stats) []string {
+	var lines []string
+	i, j := 0, 0
+	for i < len(stored) || j < len(rebuilt) {
+		switch {
+		case j == len(rebuilt) || (i < len(stored) && stored[i].Account < rebuilt[j].Account):
+			lines = append(lines, "-"+formatStats(stored[i]))
+			i++
+		case i == len(stored) || rebuilt[j].Account < stored[i].Account:
+			lines = append(lines, "+"+formatStats(rebuilt[j]))
+			j++
+		default:
+			if stored[i].Trades != rebuilt[j].Trades || math.Abs(stored[i].Profit-rebuilt[j].Profit) >= 0.005 {
+				lines = append(lines, "-"+formatStats(stored[i]), "+"+formatStats(rebuilt[j]))
+			}
+			i++
+			j++
+		}
+	}
+	return lines
+}
+
+func formatStats(s dbm.Stats) string {
+	return fmt.Sprintf("%s trades=%d profit=%.2f", s.Account, s.Trades, s.Profit)
+}
+
+// Reconcile writes the discrepancies between account_stats and the processed
+// trades to out and, with apply set, rewrites account_stats from the trades.
+// It returns the number of diff lines.
+func Reconcile(db *sql.DB, apply bool, out io.Writer) (int, error) {
+	stored, rebuilt, err := dbm.RebuildStats(db, CalculateProfitFromTrade, apply)
+	if err != nil {
+		return 0, fmt.Errorf("error rebuilding stats: %v", err)
+	}
+
+	lines := DiffStats(stored, rebuilt)
+	if len(lines) == 0 {
+		fmt.Fprintln(out, "account_stats matches processed trades")
+		return 0, nil
+	}
+
+	fmt.Fprintln(out, "--- account_stats")
+	fmt.Fprintln(out, "+++ trades_q")
+	for _, l := range lines {
+		fmt.Fprintln(out, l)
+	}
+	if apply {
+		fmt.Fprintln(out, "account_stats rewritten")
+	}
+	return len(lines), nil
+}
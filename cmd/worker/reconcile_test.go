@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func TestDiffStats(t *testing.T) {
+	stored := []dbm.Stats{
+		{Account: "a", Trades: 1, Profit: 10},
+		{Account: "b", Trades: 2, Profit: 20},
+		{Account: "d", Trades: 1, Profit: 5.001},
+	}
+	rebuilt := []dbm.Stats{
+		{Account: "b", Trades: 3, Profit: 25},
+		{Account: "c", Trades: 1, Profit: 1},
+		{Account: "d", Trades: 1, Profit: 5},
+	}
+
+	got := DiffStats(stored, rebuilt)
+	want := []string{
+		"-a trades=1 profit=10.00",
+		"-b trades=2 profit=20.00",
+		"+b trades=3 profit=25.00",
+		"+c trades=1 profit=1.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffStats() = %q, want %q", got, want)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	trade := dbm.Trade{Account: "acc1", Symbol: "ABCDEF", Volume: 1.0, Open: 1.0, Close: 2.0, Side: "buy"}
+	if err := dbm.EnqueueTrade(db, trade); err != nil {
+		t.Fatalf("EnqueueTrade failed: %v", err)
+	}
+	if _, err := ProcessPendingTrades(db); err != nil {
+		t.Fatalf("ProcessPendingTrades failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	n, err := Reconcile(db, false, &out)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no discrepancies, got %d:\n%s", n, out.String())
+	}
+
+	if err := dbm.UpdateStats(db, "acc1", 50); err != nil {
+		t.Fatalf("UpdateStats failed: %v", err)
+	}
+	out.Reset()
+	n, err = Reconcile(db, true, &out)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 diff lines, got %d", n)
+	}
+	if !strings.Contains(out.String(), "-acc1 trades=2 profit=100050.00\n+acc1 trades=1 profit=100000.00") {
+		t.Errorf("unexpected diff output:\n%s", out.String())
+	}
+
+	s, err := dbm.GetStats(db, "acc1")
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if s.Trades != 1 || s.Profit != 100000 {
+		t.Errorf("stats not rewritten: %+v", s)
+	}
+}
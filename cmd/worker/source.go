@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+// Source hands the worker trades to process. Whatever their origin, they are
+// rows of trades_q by the time Fetch returns them, so they are validated,
+// priced and applied the same way. A source must deliver a trade again until
+// it has been acknowledged.
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context) ([]Delivery, error)
+}
+
+// Delivery is a trade from a Source. Ack is called once the trade has been
+// applied or rejected and the transaction committed; Nak when it could not
+// be, so the source redelivers it. Either may be nil.
+type Delivery struct {
+	Trade dbm.Trade
+	Ack   func() error
+	Nak   func() error
+}
+
+// QueueSource delivers the pending rows of trades_q, which the API server
+// writes. The row's state is its acknowledgement, so there is nothing to ack.
+type QueueSource struct {
+	DB *sql.DB
+}
+
+func (s QueueSource) Name() string { return "trades_q" }
+
+func (s QueueSource) Fetch(ctx context.Context) ([]Delivery, error) {
+	trades, err := dbm.FetchPendingTrades(s.DB)
+	if err != nil {
+		return nil, err
+	}
+	ds := make([]Delivery, len(trades))
+	for i, t := range trades {
+		ds[i] = Delivery{Trade: t}
+	}
+	return ds, nil
+}
+
+// ProcessSource processes the trades src has ready and returns how many were
+// applied. Rejected trades and trades already processed by an earlier
+// delivery are acknowledged too; trades that failed for any other reason
+// are handed back to be delivered again.
+func ProcessSource(ctx context.Context, db *sql.DB, src Source) (int, error) {
+	ds, err := src.Fetch(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching trades from %s: %v", src.Name(), err)
+	}
+
+	processedCount := 0
+	for _, d := range ds {
+		t := d.Trade
+		err := ProcessTrade(db, t)
+		ack := d.Ack
+		switch {
+		case err == nil:
+			slog.Debug("processed trade", "trade", t.ID, "account", t.Account, "source", src.Name(), "request_id", t.RequestID)
+			processedCount++
+		case errors.Is(err, trade.ErrInvalid):
+			slog.Warn("trade not processed", "trade", t.ID, "source", src.Name(), "request_id", t.RequestID, "err", err)
+		case errors.Is(err, dbm.ErrNotPending):
+			slog.Debug("trade already processed", "trade", t.ID, "source", src.Name(), "request_id", t.RequestID)
+		default:
+			slog.Error("trade not processed", "trade", t.ID, "source", src.Name(), "request_id", t.RequestID, "err", err)
+			ack = d.Nak
+		}
+		if ack != nil {
+			if err := ack(); err != nil {
+				slog.Error("acknowledging trade", "trade", t.ID, "source", src.Name(), "err", err)
+			}
+		}
+	}
+
+	return processedCount, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/tracing"
+)
+
+func TestProcessTradeContinuesTrace(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	defer otel.SetTracerProvider(prev)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// the span the server handled POST /trades in
+	ctx, submit := tracing.Start(context.Background(), "POST /trades")
+	submit.End()
+	tr := dbm.Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy", TraceParent: tracing.Inject(ctx)}
+	if err := dbm.EnqueueTrade(db, tr); err != nil {
+		t.Fatalf("EnqueueTrade: %v", err)
+	}
+
+	if n, err := ProcessPendingTrades(db); err != nil || n != 1 {
+		t.Fatalf("ProcessPendingTrades = %d, %v", n, err)
+	}
+
+	spans := exp.GetSpans()
+	byName := map[string]tracetest.SpanStub{}
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	process, ok := byName["worker.ProcessTrade"]
+	if !ok {
+		t.Fatalf("no worker span among %d", len(spans))
+	}
+	if process.SpanContext.TraceID() != submit.SpanContext().TraceID() || process.Parent.SpanID() != submit.SpanContext().SpanID() {
+		t.Error("worker span does not continue the submission trace")
+	}
+	apply, ok := byName["db.ApplyTrade"]
+	if !ok || apply.Parent.SpanID() != process.SpanContext.SpanID() {
+		t.Error("db.ApplyTrade is not a child of the worker span")
+	}
+}
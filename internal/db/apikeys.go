@@ -0,0 +1,145 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	PermRead  = "read"
+	PermWrite = "write"
+
+	// AllAccounts scopes a key to every account.
+	AllAccounts = "*"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey describes a key. Only the SHA-256 of the key itself is stored; the
+// plaintext is returned once by CreateAPIKey.
+type APIKey struct {
+	ID          int
+	Name        string
+	Accounts    []string
+	Permissions []string
+	CreatedAt   time.Time
+	RevokedAt   *time.Time
+}
+
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "bk_" + hex.EncodeToString(b), nil
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func CreateAPIKey(db *sql.DB, name string, accounts, permissions []string) (string, APIKey, error) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	k := APIKey{
+		Name:        name,
+		Accounts:    accounts,
+		Permissions: permissions,
+		CreatedAt:   time.Now().UTC(),
+	}
+	res, err := db.Exec(
+		`INSERT INTO api_keys (name, hash, accounts, permissions, created_at) VALUES (?, ?, ?, ?, ?)`,
+		k.Name, HashAPIKey(key), strings.Join(accounts, ","), strings.Join(permissions, ","), k.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	k.ID = int(id)
+	return key, k, nil
+}
+
+func RevokeAPIKey(db *sql.DB, id int) error {
+	res, err := db.Exec(
+		`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`,
+		time.Now().UTC().Unix(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// LookupAPIKey returns the active key matching the plaintext key.
+func LookupAPIKey(db *sql.DB, key string) (APIKey, error) {
+	row := db.QueryRow(
+		`SELECT id, name, accounts, permissions, created_at, revoked_at FROM api_keys WHERE hash = ? AND revoked_at IS NULL`,
+		HashAPIKey(key),
+	)
+	k, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return k, ErrAPIKeyNotFound
+	}
+	return k, err
+}
+
+func ListAPIKeys(db *sql.DB) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT id, name, accounts, permissions, created_at, revoked_at FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var (
+		k                     APIKey
+		accounts, permissions string
+		created               int64
+		revoked               sql.NullInt64
+	)
+	if err := row.Scan(&k.ID, &k.Name, &accounts, &permissions, &created, &revoked); err != nil {
+		return k, err
+	}
+	k.Accounts = splitList(accounts)
+	k.Permissions = splitList(permissions)
+	k.CreatedAt = time.Unix(created, 0).UTC()
+	if revoked.Valid {
+		t := time.Unix(revoked.Int64, 0).UTC()
+		k.RevokedAt = &t
+	}
+	return k, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAPIKeys(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	key, created, err := CreateAPIKey(conn, "gateway", []string{"acc1", "acc2"}, []string{PermRead})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	if !strings.HasPrefix(key, "bk_") || created.ID == 0 {
+		t.Errorf("unexpected key %q / %+v", key, created)
+	}
+
+	var stored string
+	conn.QueryRow("SELECT hash FROM api_keys WHERE id = ?", created.ID).Scan(&stored)
+	if stored == key || stored != HashAPIKey(key) {
+		t.Errorf("key not stored hashed: %q", stored)
+	}
+
+	k, err := LookupAPIKey(conn, key)
+	if err != nil {
+		t.Fatalf("LookupAPIKey failed: %v", err)
+	}
+	if k.Name != "gateway" || !reflect.DeepEqual(k.Accounts, []string{"acc1", "acc2"}) || !reflect.DeepEqual(k.Permissions, []string{PermRead}) {
+		t.Errorf("unexpected key: %+v", k)
+	}
+	if _, err := LookupAPIKey(conn, "bk_unknown"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	if err := RevokeAPIKey(conn, created.ID); err != nil {
+		t.Fatalf("RevokeAPIKey failed: %v", err)
+	}
+	if _, err := LookupAPIKey(conn, key); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoked key still valid: %v", err)
+	}
+	if err := RevokeAPIKey(conn, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound revoking twice, got %v", err)
+	}
+
+	keys, err := ListAPIKeys(conn)
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Errorf("ListAPIKeys = %+v, %v", keys, err)
+	}
+}
@@ -2,16 +2,50 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/digineat/go-broker-test/internal/trade"
 )
 
-type Trade struct {
-	ID      int
-	Account string
-	Symbol  string
-	Volume  float64
-	Open    float64
-	Close   float64
-	Side    string
+// Values of trades_q.processed.
+const (
+	StatePending   = 0
+	StateProcessed = 1
+	StateRejected  = 2
+)
+
+var stateNames = map[int]string{
+	StatePending:   "pending",
+	StateProcessed: "processed",
+	StateRejected:  "rejected",
+}
+
+// StateName returns the name of a trades_q state used by the API and exports.
+func StateName(state int) string {
+	return stateNames[state]
+}
+
+// ParseState returns the state named name.
+func ParseState(name string) (int, error) {
+	for state, n := range stateNames {
+		if n == name {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown trade status %q, want pending, processed or rejected", name)
+}
+
+var ErrNotPending = errors.New("trade is not pending")
+
+type Trade = trade.Trade
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
 type Stats struct {
@@ -22,15 +56,15 @@ type Stats struct {
 
 func EnqueueTrade(db *sql.DB, t Trade) error {
 	_, err := db.Exec(
-		`INSERT INTO trades_q (account, symbol, volume, open, close, side) VALUES (?, ?, ?, ?, ?, ?)`,
-		t.Account, t.Symbol, t.Volume, t.Open, t.Close, t.Side,
+		`INSERT INTO trades_q (account, symbol, volume, open, close, side, request_id, trace_parent, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Account, t.Symbol, t.Volume, t.Open, t.Close, t.Side, t.RequestID, t.TraceParent, time.Now().UnixNano(),
 	)
 	return err
 }
 
 func FetchPendingTrades(db *sql.DB) ([]Trade, error) {
 	rows, err := db.Query(
-		`SELECT id, account, symbol, volume, open, close, side FROM trades_q WHERE processed = 0`,
+		`SELECT id, account, symbol, volume, open, close, side, COALESCE(request_id, ''), COALESCE(trace_parent, '') FROM trades_q WHERE processed = 0`,
 	)
 	if err != nil {
 		return nil, err
@@ -40,7 +74,7 @@ func FetchPendingTrades(db *sql.DB) ([]Trade, error) {
 	var trades []Trade
 	for rows.Next() {
 		var t Trade
-		if err := rows.Scan(&t.ID, &t.Account, &t.Symbol, &t.Volume, &t.Open, &t.Close, &t.Side); err != nil {
+		if err := rows.Scan(&t.ID, &t.Account, &t.Symbol, &t.Volume, &t.Open, &t.Close, &t.Side, &t.RequestID, &t.TraceParent); err != nil {
 			return nil, err
 		}
 		trades = append(trades, t)
@@ -48,14 +82,96 @@ func FetchPendingTrades(db *sql.DB) ([]Trade, error) {
 	return trades, rows.Err()
 }
 
+// CountPending returns the number of trades and position events waiting for
+// the worker.
+func CountPending(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM trades_q WHERE processed = 0) + (SELECT COUNT(*) FROM position_q WHERE processed = 0)`,
+	).Scan(&n)
+	return n, err
+}
+
 func MarkProcessed(db *sql.DB, id int) error {
 	_, err := db.Exec(
-		`UPDATE trades_q SET processed = 1 WHERE id = ?`,
-		id,
+		`UPDATE trades_q SET processed = 1, processed_at = ? WHERE id = ?`,
+		time.Now().UnixNano(), id,
 	)
 	return err
 }
 
+// ApplyTrade marks a pending trade processed, adds profit to its account's
+// stats and records trade.processed and stats.updated events in one
+// transaction. It returns ErrNotPending if the row was already processed or
+// rejected, so a trade is never counted twice.
+func ApplyTrade(db *sql.DB, t Trade, profit float64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		`UPDATE trades_q SET processed = ?, processed_at = ? WHERE id = ? AND processed = ?`,
+		StateProcessed, now.UnixNano(), t.ID, StatePending,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotPending
+	}
+
+	if err := appendEvent(tx, EventTradeProcessed, t.Account, TradeEvent{
+		ID:          t.ID,
+		Account:     t.Account,
+		Symbol:      t.Symbol,
+		Side:        t.Side,
+		Volume:      t.Volume,
+		Open:        t.Open,
+		Close:       t.Close,
+		Profit:      profit,
+		ProcessedAt: now,
+	}, now); err != nil {
+		return err
+	}
+	if err := addStats(tx, t.Account, profit, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RejectTrade marks a pending trade rejected with reason and records a
+// trade.rejected event.
+func RejectTrade(db *sql.DB, id int, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ev := TradeEvent{ID: id, Reason: reason, ProcessedAt: time.Now().UTC()}
+	err = tx.QueryRow(
+		`UPDATE trades_q SET processed = ?, reason = ?, processed_at = ? WHERE id = ? AND processed = ?
+		RETURNING account, symbol, side, volume, open, close`,
+		StateRejected, reason, ev.ProcessedAt.UnixNano(), id, StatePending,
+	).Scan(&ev.Account, &ev.Symbol, &ev.Side, &ev.Volume, &ev.Open, &ev.Close)
+	if err == sql.ErrNoRows {
+		return ErrNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := appendEvent(tx, EventTradeRejected, ev.Account, ev, ev.ProcessedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func UpdateStats(db *sql.DB, account string, profit float64) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -63,16 +179,52 @@ func UpdateStats(db *sql.DB, account string, profit float64) error {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(
+	if err := addStats(tx, account, profit, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addStats counts a trade with profit on account and records the new totals
+// as a stats.updated event.
+func addStats(q querier, account string, profit float64, at time.Time) error {
+	ch := StatsChange{Account: account}
+	err := q.QueryRow(
 		`INSERT INTO account_stats (account, trades, profit) VALUES (?, 1, ?)
-		ON CONFLICT(account) DO UPDATE SET trades = trades + 1, profit = profit + ?`,
+		ON CONFLICT(account) DO UPDATE SET trades = trades + 1, profit = profit + ?
+		RETURNING trades, profit`,
 		account, profit, profit,
-	)
+	).Scan(&ch.Trades, &ch.Profit)
 	if err != nil {
 		return err
 	}
+	return appendEvent(q, EventStatsUpdated, account, ch, at)
+}
 
-	return tx.Commit()
+// ResetStats zeroes account's totals, recording a stats.rebuilt event, and
+// returns the totals it cleared.
+func ResetStats(db *sql.DB, account string) (Stats, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Stats{}, err
+	}
+	defer tx.Rollback()
+
+	s := Stats{Account: account}
+	err = tx.QueryRow(
+		`DELETE FROM account_stats WHERE account = ? RETURNING trades, profit`,
+		account,
+	).Scan(&s.Trades, &s.Profit)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := appendEvent(tx, EventStatsRebuilt, account, StatsChange{Account: account}, time.Now()); err != nil {
+		return s, err
+	}
+	return s, tx.Commit()
 }
 
 func GetStats(db *sql.DB, account string) (Stats, error) {
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -22,6 +23,9 @@ func TestEnqueueFetchMark(t *testing.T) {
 	if err := EnqueueTrade(dbConn, tr); err != nil {
 		t.Fatalf("enqueue failed: %v", err)
 	}
+	if n, err := CountPending(dbConn); err != nil || n != 1 {
+		t.Fatalf("CountPending = %d, %v; want 1", n, err)
+	}
 	// fetch
 	trs, err := FetchPendingTrades(dbConn)
 	if err != nil {
@@ -82,3 +86,54 @@ func TestUpdateGetStats(t *testing.T) {
 		t.Errorf("final stats mismatch: %+v", s1)
 	}
 }
+
+func TestApplyRejectTrade(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer dbConn.Close()
+	dbConn.SetMaxOpenConns(1)
+	if err := InitDB(dbConn); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		tr := Trade{Account: "acc1", Symbol: "ABCDEF", Volume: 1.0, Open: 1.0, Close: 2.0, Side: "buy"}
+		if err := EnqueueTrade(dbConn, tr); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+	trs, err := FetchPendingTrades(dbConn)
+	if err != nil || len(trs) != 2 {
+		t.Fatalf("fetch pending: %v, %d trades", err, len(trs))
+	}
+
+	if err := ApplyTrade(dbConn, trs[0], 10); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if err := ApplyTrade(dbConn, trs[0], 10); !errors.Is(err, ErrNotPending) {
+		t.Errorf("second apply: expected ErrNotPending, got %v", err)
+	}
+	if err := RejectTrade(dbConn, trs[1].ID, "bad symbol"); err != nil {
+		t.Fatalf("reject failed: %v", err)
+	}
+	if err := RejectTrade(dbConn, trs[1].ID, "bad symbol"); !errors.Is(err, ErrNotPending) {
+		t.Errorf("second reject: expected ErrNotPending, got %v", err)
+	}
+
+	s, err := GetStats(dbConn, "acc1")
+	if err != nil {
+		t.Fatalf("get stats failed: %v", err)
+	}
+	if s.Trades != 1 || s.Profit != 10 {
+		t.Errorf("stats mismatch: %+v", s)
+	}
+	var state int
+	var reason string
+	if err := dbConn.QueryRow("SELECT processed, reason FROM trades_q WHERE id = ?", trs[1].ID).Scan(&state, &reason); err != nil {
+		t.Fatalf("scan rejected row: %v", err)
+	}
+	if state != StateRejected || reason != "bad symbol" {
+		t.Errorf("rejected row = (%d, %q)", state, reason)
+	}
+}
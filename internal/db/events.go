@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Types of the changes recorded in the events table.
+const (
+	EventTradeProcessed = "trade.processed"
+	EventTradeRejected  = "trade.rejected"
+	EventPositionOpened = "position.opened"
+	EventPositionClosed = "position.closed"
+	EventStatsUpdated   = "stats.updated"
+	EventStatsRebuilt   = "stats.rebuilt"
+)
+
+// Event is a change appended to the events table in the same transaction as
+// the change itself, so readers tailing the table by ID see every change
+// exactly once and in commit order.
+type Event struct {
+	ID        int64
+	Type      string
+	Account   string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// TradeEvent is the payload of trade.processed and trade.rejected events.
+type TradeEvent struct {
+	ID          int       `json:"id"`
+	Account     string    `json:"account"`
+	Symbol      string    `json:"symbol"`
+	Side        string    `json:"side"`
+	Volume      float64   `json:"volume"`
+	Open        float64   `json:"open"`
+	Close       float64   `json:"close"`
+	Profit      float64   `json:"profit"`
+	Reason      string    `json:"reason,omitempty"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// PositionChange is the payload of position.opened and position.closed
+// events. Volume is the volume opened or closed.
+type PositionChange struct {
+	Position string  `json:"position"`
+	Account  string  `json:"account"`
+	Symbol   string  `json:"symbol"`
+	Side     string  `json:"side"`
+	Volume   float64 `json:"volume"`
+	Price    float64 `json:"price"`
+	Profit   float64 `json:"profit"`
+}
+
+// StatsChange is the payload of stats.updated and stats.rebuilt events: the
+// account's totals after the change.
+type StatsChange struct {
+	Account string  `json:"account"`
+	Trades  int     `json:"trades"`
+	Profit  float64 `json:"profit"`
+}
+
+func appendEvent(q querier, typ, account string, payload any, at time.Time) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	res, err := q.Exec(
+		`INSERT INTO events (type, account, payload, created_at) VALUES (?, ?, ?, ?)`,
+		typ, account, string(b), at.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return enqueueDeliveries(q, id, typ, account, at)
+}
+
+// ListEvents returns up to limit events after the given ID in order, only
+// those of account unless it is empty.
+func ListEvents(db *sql.DB, after int64, account string, limit int) ([]Event, error) {
+	query := `SELECT id, type, account, payload, created_at FROM events WHERE id > ?`
+	args := []any{after}
+	if account != "" {
+		query += ` AND account = ?`
+		args = append(args, account)
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var (
+			ev      Event
+			payload string
+			created int64
+		)
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.Account, &payload, &created); err != nil {
+			return nil, err
+		}
+		ev.Payload = json.RawMessage(payload)
+		ev.CreatedAt = time.Unix(0, created).UTC()
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// LatestEventID returns the ID of the last event, of account unless it is
+// empty, or 0 if there is none.
+func LatestEventID(db *sql.DB, account string) (int64, error) {
+	var id int64
+	var err error
+	if account == "" {
+		err = db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM events`).Scan(&id)
+	} else {
+		err = db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM events WHERE account = ?`, account).Scan(&id)
+	}
+	return id, err
+}
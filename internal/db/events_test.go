@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestEvents(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	ids, _, err := EnqueueTrades(db, []Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc2", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 0, Side: "buy"},
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTrade(db, Trade{ID: ids[0], Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := RejectTrade(db, ids[1], "close must be > 0"); err != nil {
+		t.Fatal(err)
+	}
+	// a trade rejected twice records one event
+	if err := RejectTrade(db, ids[1], "again"); !errors.Is(err, ErrNotPending) {
+		t.Fatalf("second reject: err = %v", err)
+	}
+	if err := EnqueuePositionEvent(db, PositionEvent{Position: "p1", Kind: PositionOpen, Account: "acc1", Symbol: "EURUSD", Side: "buy", Volume: 2, Price: 1.1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnqueuePositionEvent(db, PositionEvent{Position: "p1", Kind: PositionClose, Price: 1.2}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := FetchPendingPositionEvents(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range pending {
+		if err := ApplyPositionEvent(db, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := ListEvents(db, 0, "", 100)
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	want := []string{EventTradeProcessed, EventStatsUpdated, EventTradeRejected, EventPositionOpened, EventPositionClosed, EventStatsUpdated}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, ev := range events {
+		if ev.Type != want[i] || (i > 0 && ev.ID <= events[i-1].ID) {
+			t.Errorf("event %d = %s #%d, want %s after #%d", i, ev.Type, ev.ID, want[i], events[max(i-1, 0)].ID)
+		}
+	}
+
+	var processed TradeEvent
+	if err := json.Unmarshal(events[0].Payload, &processed); err != nil {
+		t.Fatal(err)
+	}
+	if processed.ID != ids[0] || processed.Profit != 1000 || processed.ProcessedAt.IsZero() {
+		t.Errorf("trade.processed payload = %+v", processed)
+	}
+	var rejected TradeEvent
+	json.Unmarshal(events[2].Payload, &rejected)
+	if rejected.Account != "acc2" || rejected.Symbol != "EURUSD" || rejected.Reason != "close must be > 0" {
+		t.Errorf("trade.rejected payload = %+v", rejected)
+	}
+	var closed PositionChange
+	json.Unmarshal(events[4].Payload, &closed)
+	if closed.Volume != 2 || closed.Profit <= 0 {
+		t.Errorf("position.closed payload = %+v", closed)
+	}
+	// stats.updated carries the totals after the change
+	var totals StatsChange
+	json.Unmarshal(events[5].Payload, &totals)
+	if totals.Account != "acc1" || totals.Trades != 2 || totals.Profit != 1000+closed.Profit {
+		t.Errorf("stats.updated payload = %+v", totals)
+	}
+
+	acc1, err := ListEvents(db, events[1].ID, "acc1", 1)
+	if err != nil || len(acc1) != 1 || acc1[0].Type != EventPositionOpened {
+		t.Errorf("ListEvents(after first, acc1, 1) = %+v, %v", acc1, err)
+	}
+	if latest, err := LatestEventID(db, "acc2"); err != nil || latest != events[2].ID {
+		t.Errorf("LatestEventID(acc2) = %d, %v; want %d", latest, err, events[2].ID)
+	}
+	if latest, _ := LatestEventID(db, "nobody"); latest != 0 {
+		t.Errorf("LatestEventID(nobody) = %d, want 0", latest)
+	}
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// FIXSession is the sequence number state of a FIX session between two comp
+// ids. NextIn is the MsgSeqNum expected from the counterparty and NextOut the
+// one the next message sent will carry; both start at 1.
+type FIXSession struct {
+	Local     string
+	Remote    string
+	NextIn    int
+	NextOut   int
+	UpdatedAt time.Time
+}
+
+// GetFIXSession returns the stored state of the session between local and
+// remote, or a fresh one if it has never logged on.
+func GetFIXSession(db *sql.DB, local, remote string) (FIXSession, error) {
+	s := FIXSession{Local: local, Remote: remote, NextIn: 1, NextOut: 1}
+	var updated int64
+	err := db.QueryRow(
+		`SELECT next_in, next_out, updated_at FROM fix_sessions WHERE local_comp_id = ? AND remote_comp_id = ?`,
+		local, remote,
+	).Scan(&s.NextIn, &s.NextOut, &updated)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return FIXSession{}, err
+	}
+	s.UpdatedAt = time.Unix(0, updated).UTC()
+	return s, nil
+}
+
+// SaveFIXSession stores the sequence numbers of s.
+func SaveFIXSession(db *sql.DB, s FIXSession) error {
+	_, err := db.Exec(
+		`INSERT INTO fix_sessions (local_comp_id, remote_comp_id, next_in, next_out, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(local_comp_id, remote_comp_id) DO UPDATE SET
+			next_in = excluded.next_in, next_out = excluded.next_out, updated_at = excluded.updated_at`,
+		s.Local, s.Remote, s.NextIn, s.NextOut, time.Now().UnixNano(),
+	)
+	return err
+}
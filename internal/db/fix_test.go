@@ -0,0 +1,39 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFIXSession(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	s, err := GetFIXSession(conn, "BROKER", "LP1")
+	if err != nil || s.NextIn != 1 || s.NextOut != 1 {
+		t.Fatalf("new session = %+v, %v; want both at 1", s, err)
+	}
+	s.NextIn, s.NextOut = 5, 3
+	if err := SaveFIXSession(conn, s); err != nil {
+		t.Fatalf("SaveFIXSession failed: %v", err)
+	}
+	s.NextIn = 6
+	if err := SaveFIXSession(conn, s); err != nil {
+		t.Fatalf("SaveFIXSession failed: %v", err)
+	}
+	got, err := GetFIXSession(conn, "BROKER", "LP1")
+	if err != nil || got.NextIn != 6 || got.NextOut != 3 || got.UpdatedAt.IsZero() {
+		t.Errorf("stored session = %+v, %v", got, err)
+	}
+	if other, _ := GetFIXSession(conn, "BROKER", "LP2"); other.NextIn != 1 {
+		t.Errorf("sessions are not kept apart: %+v", other)
+	}
+}
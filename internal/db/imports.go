@@ -0,0 +1,221 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// States of an import.
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// maxImportErrors is how many rejected rows an import keeps the reasons of;
+// the rest are only counted.
+const maxImportErrors = 100
+
+var ErrImportNotFound = errors.New("import not found")
+
+// Import is a file of historical trades being queued for one account. Rows
+// counts the records read so far, each of which was queued, skipped as a
+// duplicate of a ticket already queued for the account, or rejected.
+type Import struct {
+	ID         int
+	Account    string
+	Format     string
+	Name       string
+	State      string
+	Size       int64
+	BytesRead  int64
+	Rows       int
+	Queued     int
+	Duplicates int
+	Rejected   int
+	Error      string
+	Errors     []ImportError
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// ImportError is why a row of an import was rejected. Line is the line of a
+// CSV file or the row of an HTML statement.
+type ImportError struct {
+	Line   int
+	Ticket string
+	Error  string
+}
+
+// ImportedTrade is a valid row of an import. Ticket is its number in the
+// system it was exported from.
+type ImportedTrade struct {
+	Ticket string
+	Trade  Trade
+}
+
+// CreateImport records a running import of size bytes.
+func CreateImport(db *sql.DB, account, format, name string, size int64) (Import, error) {
+	imp := Import{
+		Account:   account,
+		Format:    format,
+		Name:      name,
+		State:     ImportRunning,
+		Size:      size,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	res, err := db.Exec(
+		`INSERT INTO imports (account, format, name, state, size, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		imp.Account, imp.Format, imp.Name, imp.State, imp.Size, imp.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return Import{}, err
+	}
+	id, err := res.LastInsertId()
+	imp.ID = int(id)
+	return imp, err
+}
+
+// GetImport returns an import with the reasons its first rejected rows were
+// rejected for.
+func GetImport(db *sql.DB, id int) (Import, error) {
+	var (
+		imp      Import
+		created  int64
+		finished sql.NullInt64
+	)
+	err := db.QueryRow(
+		`SELECT id, account, format, name, state, size, bytes_read, rows, queued, duplicates, rejected, error, created_at, finished_at
+		FROM imports WHERE id = ?`, id,
+	).Scan(&imp.ID, &imp.Account, &imp.Format, &imp.Name, &imp.State, &imp.Size, &imp.BytesRead,
+		&imp.Rows, &imp.Queued, &imp.Duplicates, &imp.Rejected, &imp.Error, &created, &finished)
+	if errors.Is(err, sql.ErrNoRows) {
+		return imp, ErrImportNotFound
+	}
+	if err != nil {
+		return imp, err
+	}
+	imp.CreatedAt = time.Unix(created, 0).UTC()
+	if finished.Valid {
+		t := time.Unix(finished.Int64, 0).UTC()
+		imp.FinishedAt = &t
+	}
+
+	rows, err := db.Query(`SELECT line, ticket, error FROM import_errors WHERE import_id = ? ORDER BY line`, id)
+	if err != nil {
+		return imp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ImportError
+		if err := rows.Scan(&e.Line, &e.Ticket, &e.Error); err != nil {
+			return imp, err
+		}
+		imp.Errors = append(imp.Errors, e)
+	}
+	return imp, rows.Err()
+}
+
+// RecordImportBatch queues trades and records rejected rows for import id in
+// one transaction, together with the import's progress, bytesRead. A trade
+// whose ticket was already imported for its account is skipped, whichever
+// import or batch queued it and even once it has been purged.
+func RecordImportBatch(db *sql.DB, id int, trades []ImportedTrade, rejected []ImportError, bytesRead int64) (queued, duplicates int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+	for _, it := range trades {
+		t := it.Trade
+		res, err := tx.Exec(
+			`INSERT INTO import_tickets (account, ticket, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (account, ticket) DO NOTHING`,
+			t.Account, it.Ticket, now,
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return 0, 0, err
+		} else if n == 0 {
+			duplicates++
+			continue
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO trades_q (account, symbol, volume, open, close, side, request_id, trace_parent, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.Account, t.Symbol, t.Volume, t.Open, t.Close, t.Side, t.RequestID, t.TraceParent, now,
+		); err != nil {
+			return 0, 0, err
+		}
+		queued++
+	}
+
+	var kept int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM import_errors WHERE import_id = ?`, id).Scan(&kept); err != nil {
+		return 0, 0, err
+	}
+	for _, e := range rejected {
+		if kept >= maxImportErrors {
+			break
+		}
+		if _, err := tx.Exec(`INSERT INTO import_errors (import_id, line, ticket, error) VALUES (?, ?, ?, ?)`, id, e.Line, e.Ticket, e.Error); err != nil {
+			return 0, 0, err
+		}
+		kept++
+	}
+
+	res, err := tx.Exec(
+		`UPDATE imports SET rows = rows + ?, queued = queued + ?, duplicates = duplicates + ?, rejected = rejected + ?, bytes_read = ?
+		WHERE id = ? AND state = ?`,
+		len(trades)+len(rejected), queued, duplicates, len(rejected), bytesRead, id, ImportRunning,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, 0, err
+	} else if n == 0 {
+		return 0, 0, ErrImportNotFound
+	}
+	return queued, duplicates, tx.Commit()
+}
+
+// FinishImport marks import id done, or failed with failure.
+func FinishImport(db *sql.DB, id int, failure string) error {
+	state := ImportDone
+	if failure != "" {
+		state = ImportFailed
+	}
+	res, err := db.Exec(
+		`UPDATE imports SET state = ?, error = ?, finished_at = ? WHERE id = ? AND state = ?`,
+		state, failure, time.Now().Unix(), id, ImportRunning,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrImportNotFound
+	}
+	return nil
+}
+
+// AbandonImports fails the imports left running by a process that stopped
+// before finishing them, and returns how many there were. Their trades
+// queued so far stay queued; importing the file again queues the rest.
+func AbandonImports(db *sql.DB) (int, error) {
+	res, err := db.Exec(
+		`UPDATE imports SET state = ?, error = 'interrupted', finished_at = ? WHERE state = ?`,
+		ImportFailed, time.Now().Unix(), ImportRunning,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
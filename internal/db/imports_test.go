@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestImports(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	imp, err := CreateImport(conn, "acc1", "csv", "history.csv", 1000)
+	if err != nil {
+		t.Fatalf("CreateImport failed: %v", err)
+	}
+	trade := func(ticket string, open float64) ImportedTrade {
+		return ImportedTrade{Ticket: ticket, Trade: Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: open, Close: 1.2, Side: "buy"}}
+	}
+
+	queued, dups, err := RecordImportBatch(conn, imp.ID, []ImportedTrade{trade("1", 1.1), trade("2", 1.15), trade("1", 1.1)}, []ImportError{{Line: 4, Ticket: "3", Error: "bad"}}, 400)
+	if err != nil || queued != 2 || dups != 1 {
+		t.Fatalf("RecordImportBatch = %d queued, %d duplicates, %v", queued, dups, err)
+	}
+	// A second import of the same account skips the tickets it already has.
+	other, _ := CreateImport(conn, "acc1", "csv", "", 0)
+	if queued, dups, _ := RecordImportBatch(conn, other.ID, []ImportedTrade{trade("2", 1.15), trade("5", 1.1)}, nil, 0); queued != 1 || dups != 1 {
+		t.Errorf("second import: %d queued, %d duplicates", queued, dups)
+	}
+	// Tickets are per account.
+	elsewhere := trade("1", 1.1)
+	elsewhere.Trade.Account = "acc2"
+	if queued, _, _ := RecordImportBatch(conn, other.ID, []ImportedTrade{elsewhere}, nil, 0); queued != 1 {
+		t.Errorf("ticket of another account was not queued")
+	}
+
+	if err := FinishImport(conn, imp.ID, ""); err != nil {
+		t.Fatalf("FinishImport failed: %v", err)
+	}
+	got, err := GetImport(conn, imp.ID)
+	if err != nil {
+		t.Fatalf("GetImport failed: %v", err)
+	}
+	if got.State != ImportDone || got.Rows != 4 || got.Queued != 2 || got.Duplicates != 1 || got.Rejected != 1 || got.BytesRead != 400 || got.FinishedAt == nil {
+		t.Errorf("import = %+v", got)
+	}
+	if len(got.Errors) != 1 || got.Errors[0] != (ImportError{Line: 4, Ticket: "3", Error: "bad"}) {
+		t.Errorf("errors = %+v", got.Errors)
+	}
+	if _, _, err := RecordImportBatch(conn, imp.ID, []ImportedTrade{trade("9", 1.1)}, nil, 0); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("batch for a finished import: err = %v, want ErrImportNotFound", err)
+	}
+
+	if n, err := AbandonImports(conn); err != nil || n != 1 {
+		t.Fatalf("AbandonImports = %d, %v; want 1", n, err)
+	}
+	if got, _ := GetImport(conn, other.ID); got.State != ImportFailed || got.Error != "interrupted" {
+		t.Errorf("abandoned import = %+v", got)
+	}
+	if _, err := GetImport(conn, 99); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("expected ErrImportNotFound, got %v", err)
+	}
+}
@@ -2,8 +2,49 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 )
 
+// columns added after the tables were first created; CREATE TABLE IF NOT
+// EXISTS leaves existing databases untouched, so they are added here.
+var columns = []struct {
+	table, name, decl string
+}{
+	{"trades_q", "reason", "TEXT"},
+	{"trades_q", "request_id", "TEXT"},
+	{"position_q", "request_id", "TEXT"},
+	{"trades_q", "trace_parent", "TEXT"},
+	{"position_q", "trace_parent", "TEXT"},
+	{"trades_q", "created_at", "INTEGER"},
+	{"trades_q", "processed_at", "INTEGER"},
+}
+
+// indexes on added columns, created once the columns exist.
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS trades_q_created_at ON trades_q (created_at);`,
+	`CREATE INDEX IF NOT EXISTS trades_q_processed_at ON trades_q (processed_at);`,
+}
+
+// backfills fill in newer columns for rows written by older versions. They run
+// on every start, so each must leave already migrated rows alone.
+var backfills = []string{
+	// trades_q.created_at and processed_at were added after trades had been
+	// queued. A finished trade takes processed_at from its event, when it
+	// has one, and created_at from processed_at; one with no record of
+	// either gets created_at 0, so From/To filters treat it as queued at the
+	// epoch and this runs once per trade.
+	`UPDATE trades_q SET processed_at = e.at
+	FROM (
+		SELECT json_extract(payload, '$.id') AS id, MIN(created_at) AS at FROM events
+		WHERE type IN ('trade.processed', 'trade.rejected')
+			AND EXISTS (SELECT 1 FROM trades_q WHERE created_at IS NULL AND processed_at IS NULL AND processed IN (1, 2))
+		GROUP BY 1
+	) AS e
+	WHERE trades_q.id = e.id AND trades_q.created_at IS NULL AND trades_q.processed_at IS NULL AND trades_q.processed IN (1, 2);`,
+	`UPDATE trades_q SET created_at = COALESCE(processed_at, 0) WHERE created_at IS NULL AND processed IN (1, 2);`,
+}
+
 func InitDB(db *sql.DB) error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS trades_q (
@@ -20,6 +61,126 @@ func InitDB(db *sql.DB) error {
             account TEXT PRIMARY KEY,
             trades INTEGER NOT NULL DEFAULT 0,
             profit REAL NOT NULL DEFAULT 0
+        );`,
+		`CREATE INDEX IF NOT EXISTS trades_q_processed ON trades_q (processed);`,
+		`CREATE TABLE IF NOT EXISTS position_q (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            position TEXT NOT NULL,
+            kind TEXT NOT NULL,
+            account TEXT NOT NULL DEFAULT '',
+            symbol TEXT NOT NULL DEFAULT '',
+            side TEXT NOT NULL DEFAULT '',
+            volume REAL NOT NULL DEFAULT 0,
+            price REAL NOT NULL,
+            processed INTEGER NOT NULL DEFAULT 0,
+            reason TEXT
+        );`,
+		`CREATE INDEX IF NOT EXISTS position_q_processed ON position_q (processed);`,
+		`CREATE TABLE IF NOT EXISTS positions (
+            id TEXT PRIMARY KEY,
+            account TEXT NOT NULL,
+            symbol TEXT NOT NULL,
+            side TEXT NOT NULL,
+            volume REAL NOT NULL,
+            remaining REAL NOT NULL,
+            open REAL NOT NULL,
+            realized REAL NOT NULL DEFAULT 0
+        );`,
+		`CREATE INDEX IF NOT EXISTS positions_account ON positions (account);`,
+		`CREATE TABLE IF NOT EXISTS quotes (
+            symbol TEXT PRIMARY KEY,
+            bid REAL NOT NULL,
+            ask REAL NOT NULL,
+            ts INTEGER NOT NULL
+        );`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            hash TEXT NOT NULL UNIQUE,
+            accounts TEXT NOT NULL,
+            permissions TEXT NOT NULL,
+            created_at INTEGER NOT NULL,
+            revoked_at INTEGER
+        );`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+            scope TEXT NOT NULL,
+            key TEXT NOT NULL,
+            trades TEXT NOT NULL,
+            created_at INTEGER NOT NULL,
+            PRIMARY KEY (scope, key)
+        );`,
+		`CREATE TABLE IF NOT EXISTS events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            type TEXT NOT NULL,
+            account TEXT NOT NULL,
+            payload TEXT NOT NULL,
+            created_at INTEGER NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS events_account ON events (account, id);`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            url TEXT NOT NULL,
+            secret TEXT NOT NULL,
+            events TEXT NOT NULL,
+            accounts TEXT NOT NULL,
+            created_at INTEGER NOT NULL,
+            disabled_at INTEGER
+        );`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            webhook_id INTEGER NOT NULL,
+            event_id INTEGER NOT NULL,
+            state TEXT NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            next_attempt_at INTEGER NOT NULL DEFAULT 0,
+            status_code INTEGER NOT NULL DEFAULT 0,
+            error TEXT NOT NULL DEFAULT '',
+            delivered_at INTEGER,
+            created_at INTEGER NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (state, next_attempt_at);`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);`,
+		`CREATE TABLE IF NOT EXISTS fix_sessions (
+            local_comp_id TEXT NOT NULL,
+            remote_comp_id TEXT NOT NULL,
+            next_in INTEGER NOT NULL,
+            next_out INTEGER NOT NULL,
+            updated_at INTEGER NOT NULL,
+            PRIMARY KEY (local_comp_id, remote_comp_id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS imports (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            account TEXT NOT NULL,
+            format TEXT NOT NULL,
+            name TEXT NOT NULL DEFAULT '',
+            state TEXT NOT NULL,
+            size INTEGER NOT NULL DEFAULT 0,
+            bytes_read INTEGER NOT NULL DEFAULT 0,
+            rows INTEGER NOT NULL DEFAULT 0,
+            queued INTEGER NOT NULL DEFAULT 0,
+            duplicates INTEGER NOT NULL DEFAULT 0,
+            rejected INTEGER NOT NULL DEFAULT 0,
+            error TEXT NOT NULL DEFAULT '',
+            created_at INTEGER NOT NULL,
+            finished_at INTEGER
+        );`,
+		`CREATE TABLE IF NOT EXISTS import_errors (
+            import_id INTEGER NOT NULL,
+            line INTEGER NOT NULL,
+            ticket TEXT NOT NULL,
+            error TEXT NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS import_errors_import ON import_errors (import_id, line);`,
+		`CREATE TABLE IF NOT EXISTS import_tickets (
+            account TEXT NOT NULL,
+            ticket TEXT NOT NULL,
+            created_at INTEGER NOT NULL,
+            PRIMARY KEY (account, ticket)
+        );`,
+		`CREATE TABLE IF NOT EXISTS purged_trades (
+            account TEXT PRIMARY KEY,
+            trades INTEGER NOT NULL DEFAULT 0,
+            profit REAL NOT NULL DEFAULT 0
         );`,
 	}
 	for _, q := range queries {
@@ -27,5 +188,55 @@ func InitDB(db *sql.DB) error {
 			return err
 		}
 	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.name, c.decl); err != nil {
+			return err
+		}
+	}
+	for _, q := range indexes {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	for _, q := range backfills {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func addColumn(db *sql.DB, table, name, decl string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	exists := false
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			col, typ         string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &col, &typ, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if col == name {
+			exists = true
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, name, decl))
+	// the server and the worker migrate on startup and may race here
+	if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+		return nil
+	}
+	return err
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"database/sql"
+	"slices"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -31,3 +33,110 @@ func TestInitDB(t *testing.T) {
 		}
 	}
 }
+
+func TestInitDBAddsColumns(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory DB: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	// schema as created by the first release
+	if _, err := conn.Exec(`CREATE TABLE trades_q (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            account TEXT NOT NULL,
+            symbol TEXT NOT NULL,
+            volume REAL NOT NULL,
+            open REAL NOT NULL,
+            close REAL NOT NULL,
+            side TEXT NOT NULL,
+            processed INTEGER NOT NULL DEFAULT 0
+        )`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := InitDB(conn); err != nil {
+			t.Fatalf("InitDB run %d failed: %v", i, err)
+		}
+	}
+	for _, c := range columns {
+		var n int
+		q := "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+		if err := conn.QueryRow(q, c.table, c.name).Scan(&n); err != nil {
+			t.Fatalf("table_info %s: %v", c.table, err)
+		}
+		if n != 1 {
+			t.Errorf("column %s.%s missing", c.table, c.name)
+		}
+	}
+}
+
+func TestInitDBBackfillsQueueTimes(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory DB: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	// trades queued before their times were recorded: one processed since
+	// events were, one finished before that and one still pending
+	processedAt := time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+	var ids []int
+	for _, state := range []int{StateProcessed, StateRejected, StatePending} {
+		res, err := conn.Exec(
+			`INSERT INTO trades_q (account, symbol, volume, open, close, side, processed) VALUES ('acc1', 'EURUSD', 1, 1.1, 1.2, 'buy', ?)`, state,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, int(id))
+	}
+	if _, err := conn.Exec(
+		`INSERT INTO events (type, account, payload, created_at) VALUES (?, 'acc1', json_object('id', ?), ?)`,
+		EventTradeProcessed, ids[0], processedAt.UnixNano(),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := InitDB(conn); err != nil {
+			t.Fatalf("InitDB run %d failed: %v", i, err)
+		}
+	}
+	if got, err := GetTrade(conn, ids[0]); err != nil || !got.CreatedAt.Equal(processedAt) || !got.ProcessedAt.Equal(processedAt) {
+		t.Errorf("trade processed with an event = %+v, %v; want queued and processed at %v", got, err, processedAt)
+	}
+	var created sql.NullInt64
+	if err := conn.QueryRow(`SELECT created_at FROM trades_q WHERE id = ?`, ids[1]).Scan(&created); err != nil || !created.Valid || created.Int64 != 0 {
+		t.Errorf("created_at of a trade with no recorded times = %v, %v; want 0", created, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		f    TradeFilter
+		want []int
+	}{
+		{"from", TradeFilter{From: processedAt.Add(-time.Hour)}, ids[:1]},
+		{"to", TradeFilter{To: processedAt.Add(time.Hour)}, ids},
+		{"before", TradeFilter{To: processedAt}, ids[1:]},
+	} {
+		got, err := ListTrades(conn, tt.f, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotIDs []int
+		for _, tr := range got {
+			gotIDs = append(gotIDs, tr.ID)
+		}
+		if !slices.Equal(gotIDs, tt.want) {
+			t.Errorf("%s: ListTrades = %v, want %v", tt.name, gotIDs, tt.want)
+		}
+	}
+}
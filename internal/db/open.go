@@ -0,0 +1,75 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const DefaultBusyTimeout = 5 * time.Second
+
+// Options controls how Open configures a connection pool.
+//
+// The server and the worker are separate processes writing to the same file,
+// so every writer pool holds a single connection and starts transactions with
+// BEGIN IMMEDIATE: the write lock is taken up front and waits on busy_timeout
+// instead of failing on a lock upgrade. Read-only pools may hold several
+// connections since WAL lets readers run alongside the writer.
+type Options struct {
+	ReadOnly    bool
+	BusyTimeout time.Duration
+	MaxReaders  int
+}
+
+func Open(path string, opts Options) (*sql.DB, error) {
+	if opts.BusyTimeout <= 0 {
+		opts.BusyTimeout = DefaultBusyTimeout
+	}
+	if opts.MaxReaders <= 0 {
+		opts.MaxReaders = runtime.NumCPU()
+	}
+
+	memory := isMemoryPath(path)
+	params := []string{
+		fmt.Sprintf("_busy_timeout=%d", opts.BusyTimeout.Milliseconds()),
+		"_foreign_keys=on",
+	}
+	if !memory {
+		params = append(params, "_synchronous=NORMAL")
+	}
+	if opts.ReadOnly {
+		params = append(params, "_query_only=true")
+	} else {
+		params = append(params, "_txlock=immediate")
+		if !memory {
+			params = append(params, "_journal_mode=WAL")
+		}
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite3", path+sep+strings.Join(params, "&"))
+	if err != nil {
+		return nil, err
+	}
+
+	// An in-memory database only exists on the connection that created it.
+	if opts.ReadOnly && !memory {
+		db.SetMaxOpenConns(opts.MaxReaders)
+		db.SetMaxIdleConns(opts.MaxReaders)
+	} else {
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+	}
+	return db, nil
+}
+
+func isMemoryPath(path string) bool {
+	return path == ":memory:" || strings.HasPrefix(path, ":memory:?") || strings.Contains(path, "mode=memory")
+}
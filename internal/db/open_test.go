@@ -0,0 +1,166 @@
+package db
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestOpenPragmas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pragmas.db")
+	conn, err := Open(path, Options{BusyTimeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer conn.Close()
+
+	var mode string
+	if err := conn.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatalf("journal_mode: %v", err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %s, want wal", mode)
+	}
+	var fk, timeout, sync int
+	if err := conn.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil {
+		t.Fatalf("foreign_keys: %v", err)
+	}
+	if fk != 1 {
+		t.Errorf("foreign_keys = %d, want 1", fk)
+	}
+	if err := conn.QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil {
+		t.Fatalf("busy_timeout: %v", err)
+	}
+	if timeout != 2000 {
+		t.Errorf("busy_timeout = %d, want 2000", timeout)
+	}
+	if err := conn.QueryRow("PRAGMA synchronous").Scan(&sync); err != nil {
+		t.Fatalf("synchronous: %v", err)
+	}
+	if sync != 1 { // NORMAL
+		t.Errorf("synchronous = %d, want 1", sync)
+	}
+	if s := conn.Stats(); s.MaxOpenConnections != 1 {
+		t.Errorf("writer MaxOpenConnections = %d, want 1", s.MaxOpenConnections)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ro.db")
+	w, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open writer failed: %v", err)
+	}
+	defer w.Close()
+	if err := InitDB(w); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	r, err := Open(path, Options{ReadOnly: true, MaxReaders: 4})
+	if err != nil {
+		t.Fatalf("Open reader failed: %v", err)
+	}
+	defer r.Close()
+	if s := r.Stats(); s.MaxOpenConnections != 4 {
+		t.Errorf("reader MaxOpenConnections = %d, want 4", s.MaxOpenConnections)
+	}
+	if _, err := GetStats(r, "acc1"); err != nil {
+		t.Errorf("read through reader failed: %v", err)
+	}
+	if err := EnqueueTrade(r, Trade{Account: "acc1", Symbol: "ABCDEF", Volume: 1, Open: 1, Close: 2, Side: "buy"}); err == nil {
+		t.Error("expected write through reader to fail")
+	}
+}
+
+func TestConcurrentEnqueueAndProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "concurrent.db")
+	producer, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open producer failed: %v", err)
+	}
+	defer producer.Close()
+	if err := InitDB(producer); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	// a second pool on the same file stands in for the worker process
+	consumer, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer consumer.Close()
+	reader, err := Open(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open reader failed: %v", err)
+	}
+	defer reader.Close()
+
+	const n = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < n; i++ {
+			tr := Trade{Account: "acc1", Symbol: "ABCDEF", Volume: 1.0, Open: 1.0, Close: 1.0001, Side: "buy"}
+			if err := EnqueueTrade(producer, tr); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				if err := ProcessPending(consumer); err != nil {
+					errs <- err
+				}
+				return
+			default:
+			}
+			if err := ProcessPending(consumer); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := GetStats(reader, "acc1"); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent access failed: %v", err)
+	}
+
+	s, err := GetStats(producer, "acc1")
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if s.Trades != n {
+		t.Errorf("expected %d processed trades, got %d", n, s.Trades)
+	}
+}
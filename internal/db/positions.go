@@ -0,0 +1,291 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+const (
+	PositionOpen  = "open"
+	PositionClose = "close"
+)
+
+var (
+	ErrPositionNotFound = errors.New("position not found")
+	ErrPositionExists   = errors.New("position id already in use")
+)
+
+type Position = trade.Position
+
+// PositionEvent is a row of position_q. Open events carry the account,
+// symbol, side, volume and open price; close events carry the volume to close
+// (zero for all of it) and the close price.
+type PositionEvent struct {
+	ID       int
+	Position string
+	Kind     string
+	Account  string
+	Symbol   string
+	Side     string
+	Volume   float64
+	Price    float64
+	// RequestID is the X-Request-ID of the request that queued the event.
+	RequestID string
+	// TraceParent is the W3C trace context of that request.
+	TraceParent string
+}
+
+type PositionSummary struct {
+	Account  string
+	Open     int
+	Volume   float64
+	Realized float64
+}
+
+// EnqueuePositionEvent queues ev. Position ids are global, so an open whose id
+// belongs to a position or to another pending open returns ErrPositionExists;
+// otherwise closes could not tell which account the id refers to.
+func EnqueuePositionEvent(db *sql.DB, ev PositionEvent) error {
+	if ev.Kind != PositionOpen {
+		_, err := db.Exec(
+			`INSERT INTO position_q (position, kind, account, symbol, side, volume, price, request_id, trace_parent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			ev.Position, ev.Kind, ev.Account, ev.Symbol, ev.Side, ev.Volume, ev.Price, ev.RequestID, ev.TraceParent,
+		)
+		return err
+	}
+
+	res, err := db.Exec(
+		`INSERT INTO position_q (position, kind, account, symbol, side, volume, price, request_id, trace_parent)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM positions WHERE id = ?)
+		AND NOT EXISTS (SELECT 1 FROM position_q WHERE position = ? AND kind = ? AND processed = ?)`,
+		ev.Position, ev.Kind, ev.Account, ev.Symbol, ev.Side, ev.Volume, ev.Price, ev.RequestID, ev.TraceParent,
+		ev.Position, ev.Position, PositionOpen, StatePending,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPositionExists
+	}
+	return nil
+}
+
+func FetchPendingPositionEvents(db *sql.DB) ([]PositionEvent, error) {
+	rows, err := db.Query(
+		`SELECT id, position, kind, account, symbol, side, volume, price, COALESCE(request_id, ''), COALESCE(trace_parent, '') FROM position_q WHERE processed = 0 ORDER BY id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []PositionEvent
+	for rows.Next() {
+		var ev PositionEvent
+		if err := rows.Scan(&ev.ID, &ev.Position, &ev.Kind, &ev.Account, &ev.Symbol, &ev.Side, &ev.Volume, &ev.Price, &ev.RequestID, &ev.TraceParent); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// ApplyPositionEvent opens or closes a position and marks the event processed
+// in one transaction. A close adds the realized profit to account_stats as one
+// trade. Events that fail domain checks are marked rejected with the reason
+// and the returned error wraps trade.ErrInvalid.
+func ApplyPositionEvent(db *sql.DB, ev PositionEvent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE position_q SET processed = ? WHERE id = ? AND processed = ?`,
+		StateProcessed, ev.ID, StatePending,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotPending
+	}
+
+	var rejected error
+	switch ev.Kind {
+	case PositionOpen:
+		rejected, err = openPosition(tx, ev)
+	case PositionClose:
+		rejected, err = closePosition(tx, ev)
+	default:
+		rejected = fmt.Errorf("%w: unknown event kind %q", trade.ErrInvalid, ev.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	if rejected != nil {
+		if _, err := tx.Exec(
+			`UPDATE position_q SET processed = ?, reason = ? WHERE id = ?`,
+			StateRejected, rejected.Error(), ev.ID,
+		); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return rejected
+	}
+	return tx.Commit()
+}
+
+func openPosition(tx *sql.Tx, ev PositionEvent) (rejected, err error) {
+	p := Position{
+		ID:        ev.Position,
+		Account:   ev.Account,
+		Symbol:    ev.Symbol,
+		Side:      ev.Side,
+		Volume:    ev.Volume,
+		Remaining: ev.Volume,
+		Open:      ev.Price,
+	}
+	if err := p.Validate(); err != nil {
+		return err, nil
+	}
+
+	res, err := tx.Exec(
+		`INSERT OR IGNORE INTO positions (id, account, symbol, side, volume, remaining, open) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Account, p.Symbol, p.Side, p.Volume, p.Remaining, p.Open,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return fmt.Errorf("%w: position %s already exists", trade.ErrInvalid, p.ID), nil
+	}
+	return nil, appendEvent(tx, EventPositionOpened, p.Account, PositionChange{
+		Position: p.ID,
+		Account:  p.Account,
+		Symbol:   p.Symbol,
+		Side:     p.Side,
+		Volume:   p.Volume,
+		Price:    p.Open,
+	}, time.Now())
+}
+
+func closePosition(tx *sql.Tx, ev PositionEvent) (rejected, err error) {
+	p, err := getPosition(tx, ev.Position)
+	if errors.Is(err, ErrPositionNotFound) {
+		return fmt.Errorf("%w: position %s not found", trade.ErrInvalid, ev.Position), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	volume := ev.Volume
+	if volume == 0 {
+		volume = p.Remaining
+	}
+	profit, err := p.Close(ev.Volume, ev.Price)
+	if err != nil {
+		return err, nil
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE positions SET remaining = ?, realized = ? WHERE id = ?`,
+		p.Remaining, p.Realized, p.ID,
+	); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := appendEvent(tx, EventPositionClosed, p.Account, PositionChange{
+		Position: p.ID,
+		Account:  p.Account,
+		Symbol:   p.Symbol,
+		Side:     p.Side,
+		Volume:   volume,
+		Price:    ev.Price,
+		Profit:   profit,
+	}, now); err != nil {
+		return nil, err
+	}
+	return nil, addStats(tx, p.Account, profit, now)
+}
+
+func GetPosition(db *sql.DB, id string) (Position, error) {
+	return getPosition(db, id)
+}
+
+func getPosition(q querier, id string) (Position, error) {
+	var p Position
+	err := q.QueryRow(
+		`SELECT id, account, symbol, side, volume, remaining, open, realized FROM positions WHERE id = ?`,
+		id,
+	).Scan(&p.ID, &p.Account, &p.Symbol, &p.Side, &p.Volume, &p.Remaining, &p.Open, &p.Realized)
+	if err == sql.ErrNoRows {
+		return p, ErrPositionNotFound
+	}
+	return p, err
+}
+
+// PositionAccount returns the account a position belongs to, including
+// positions whose open event hasn't been applied yet. EnqueuePositionEvent
+// keeps an id to one position or pending open, so the answer is unambiguous.
+func PositionAccount(db *sql.DB, id string) (string, error) {
+	var account string
+	err := db.QueryRow(
+		`SELECT account FROM (
+			SELECT account, 0 AS pending, 0 AS seq FROM positions WHERE id = ?
+			UNION ALL
+			SELECT account, 1, id FROM position_q WHERE position = ? AND kind = ? AND processed = ?
+		) ORDER BY pending, seq LIMIT 1`,
+		id, id, PositionOpen, StatePending,
+	).Scan(&account)
+	if err == sql.ErrNoRows {
+		return "", ErrPositionNotFound
+	}
+	return account, err
+}
+
+func ListPositions(db *sql.DB, account string) ([]Position, error) {
+	rows, err := db.Query(
+		`SELECT id, account, symbol, side, volume, remaining, open, realized FROM positions WHERE account = ? ORDER BY id`,
+		account,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []Position
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.ID, &p.Account, &p.Symbol, &p.Side, &p.Volume, &p.Remaining, &p.Open, &p.Realized); err != nil {
+			return nil, err
+		}
+		positions = append(positions, p)
+	}
+	return positions, rows.Err()
+}
+
+func GetPositionSummary(db *sql.DB, account string) (PositionSummary, error) {
+	s := PositionSummary{Account: account}
+	err := db.QueryRow(
+		`SELECT COUNT(CASE WHEN remaining > 0 THEN 1 END), COALESCE(SUM(remaining), 0), COALESCE(SUM(realized), 0)
+		FROM positions WHERE account = ?`,
+		account,
+	).Scan(&s.Open, &s.Volume, &s.Realized)
+	return s, err
+}
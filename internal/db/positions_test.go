@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+func TestPositionLifecycle(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	events := []PositionEvent{
+		{Position: "p1", Kind: PositionOpen, Account: "acc1", Symbol: "EURUSD", Side: "buy", Volume: 2, Price: 1.0},
+		{Position: "p1", Kind: PositionClose, Volume: 0.5, Price: 1.1},
+		{Position: "p1", Kind: PositionClose, Volume: 5, Price: 1.1}, // exceeds remaining
+		{Position: "p2", Kind: PositionClose, Volume: 1, Price: 1.1}, // unknown position
+	}
+	for _, ev := range events {
+		if err := EnqueuePositionEvent(conn, ev); err != nil {
+			t.Fatalf("EnqueuePositionEvent failed: %v", err)
+		}
+	}
+	// ids are global: another open of p1, from any account, is refused
+	// while the first is queued and after it is applied
+	dup := PositionEvent{Position: "p1", Kind: PositionOpen, Account: "acc2", Symbol: "EURUSD", Side: "buy", Volume: 1, Price: 1.0}
+	if err := EnqueuePositionEvent(conn, dup); !errors.Is(err, ErrPositionExists) {
+		t.Errorf("duplicate pending open: expected ErrPositionExists, got %v", err)
+	}
+	if acc, err := PositionAccount(conn, "p1"); err != nil || acc != "acc1" {
+		t.Errorf("PositionAccount(p1) = %q, %v", acc, err)
+	}
+
+	pending, err := FetchPendingPositionEvents(conn)
+	if err != nil || len(pending) != len(events) {
+		t.Fatalf("FetchPendingPositionEvents: %v, %d events", err, len(pending))
+	}
+
+	wantRejected := []bool{false, false, true, true}
+	for i, ev := range pending {
+		err := ApplyPositionEvent(conn, ev)
+		if wantRejected[i] != errors.Is(err, trade.ErrInvalid) {
+			t.Errorf("event %d: unexpected result %v", i, err)
+		}
+	}
+	if err := ApplyPositionEvent(conn, pending[0]); !errors.Is(err, ErrNotPending) {
+		t.Errorf("reapplying event: expected ErrNotPending, got %v", err)
+	}
+	if left, _ := FetchPendingPositionEvents(conn); len(left) != 0 {
+		t.Errorf("expected no pending events, got %d", len(left))
+	}
+
+	if err := EnqueuePositionEvent(conn, dup); !errors.Is(err, ErrPositionExists) {
+		t.Errorf("open of an existing position: expected ErrPositionExists, got %v", err)
+	}
+	if acc, err := PositionAccount(conn, "p1"); err != nil || acc != "acc1" {
+		t.Errorf("PositionAccount(p1) after apply = %q, %v", acc, err)
+	}
+
+	p, err := GetPosition(conn, "p1")
+	if err != nil {
+		t.Fatalf("GetPosition failed: %v", err)
+	}
+	if p.Remaining != 1.5 || p.Volume != 2 || p.Realized < 4999.99 || p.Realized > 5000.01 {
+		t.Errorf("unexpected position: %+v", p)
+	}
+	if _, err := GetPosition(conn, "p2"); !errors.Is(err, ErrPositionNotFound) {
+		t.Errorf("expected ErrPositionNotFound, got %v", err)
+	}
+
+	// close the rest in full
+	if err := EnqueuePositionEvent(conn, PositionEvent{Position: "p1", Kind: PositionClose, Price: 0.9}); err != nil {
+		t.Fatalf("EnqueuePositionEvent failed: %v", err)
+	}
+	pending, _ = FetchPendingPositionEvents(conn)
+	if err := ApplyPositionEvent(conn, pending[0]); err != nil {
+		t.Fatalf("full close failed: %v", err)
+	}
+
+	summary, err := GetPositionSummary(conn, "acc1")
+	if err != nil {
+		t.Fatalf("GetPositionSummary failed: %v", err)
+	}
+	if summary.Open != 0 || summary.Volume != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	s, _ := GetStats(conn, "acc1")
+	if s.Trades != 2 || s.Profit > -9999.99 || s.Profit < -10000.01 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	list, err := ListPositions(conn, "acc1")
+	if err != nil || len(list) != 1 {
+		t.Errorf("ListPositions: %v, %+v", err, list)
+	}
+
+	var reason string
+	conn.QueryRow("SELECT reason FROM position_q WHERE id = 4").Scan(&reason)
+	if reason == "" {
+		t.Error("expected a recorded reason for the rejected close")
+	}
+
+	// rebuilt stats include realized position profit
+	_, rebuilt, err := RebuildStats(conn, func(Trade) float64 { return 0 }, false)
+	if err != nil {
+		t.Fatalf("RebuildStats failed: %v", err)
+	}
+	if len(rebuilt) != 1 || rebuilt[0].Trades != 2 || rebuilt[0].Profit != s.Profit {
+		t.Errorf("rebuilt stats = %+v, stored %+v", rebuilt, s)
+	}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrNotRejected = errors.New("trade is not rejected")
+
+// QueueStats counts the rows of trades_q by state. Rejected trades are the
+// queue's dead letters: the worker never retries them on its own.
+type QueueStats struct {
+	Pending   int
+	Processed int
+	Rejected  int
+	// OldestPending is when the oldest pending trade was queued, zero when
+	// none is pending or none of them has a recorded time.
+	OldestPending time.Time
+	// PendingPositionEvents counts position_q rows waiting for the worker.
+	PendingPositionEvents int
+}
+
+func GetQueueStats(db *sql.DB) (QueueStats, error) {
+	var (
+		s      QueueStats
+		oldest int64
+	)
+	err := db.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM trades_q WHERE processed = ?),
+			(SELECT COUNT(*) FROM trades_q WHERE processed = ?),
+			(SELECT COUNT(*) FROM trades_q WHERE processed = ?),
+			(SELECT COALESCE(MIN(created_at), 0) FROM trades_q WHERE processed = ?),
+			(SELECT COUNT(*) FROM position_q WHERE processed = 0)`,
+		StatePending, StateProcessed, StateRejected, StatePending,
+	).Scan(&s.Pending, &s.Processed, &s.Rejected, &oldest, &s.PendingPositionEvents)
+	if oldest != 0 {
+		s.OldestPending = time.Unix(0, oldest).UTC()
+	}
+	return s, err
+}
+
+// RequeueTrade returns a rejected trade to pending so the worker processes
+// it again. It returns ErrTradeNotFound or ErrNotRejected otherwise.
+func RequeueTrade(db *sql.DB, id int) error {
+	res, err := db.Exec(
+		`UPDATE trades_q SET processed = ?, reason = NULL, processed_at = NULL WHERE id = ? AND processed = ?`,
+		StatePending, id, StateRejected,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if _, err := GetTrade(db, id); err != nil {
+			return err
+		}
+		return ErrNotRejected
+	}
+	return nil
+}
+
+// PurgeTrades deletes processed and rejected trades the worker finished
+// before the given time and returns how many it deleted. Trades finished
+// before the time was recorded are kept. The count and profit, by profit, of
+// the processed ones are kept in purged_trades so RebuildStats still
+// accounts for them. Idempotency keys and import tickets are kept in their
+// own tables, so trades sent again after a purge are still not queued twice.
+func PurgeTrades(db *sql.DB, before time.Time, profit func(Trade) float64) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id, account, symbol, volume, open, close, side FROM trades_q WHERE processed = ? AND processed_at < ?`,
+		StateProcessed, before.UnixNano(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	purged := map[string]*Stats{}
+	for rows.Next() {
+		var t Trade
+		if err := rows.Scan(&t.ID, &t.Account, &t.Symbol, &t.Volume, &t.Open, &t.Close, &t.Side); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		s, ok := purged[t.Account]
+		if !ok {
+			s = &Stats{Account: t.Account}
+			purged[t.Account] = s
+		}
+		s.Trades++
+		s.Profit += profit(t)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	for _, s := range purged {
+		if _, err := tx.Exec(
+			`INSERT INTO purged_trades (account, trades, profit) VALUES (?, ?, ?)
+			ON CONFLICT(account) DO UPDATE SET trades = trades + excluded.trades, profit = profit + excluded.profit`,
+			s.Account, s.Trades, s.Profit,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(
+		`DELETE FROM trades_q WHERE processed IN (?, ?) AND processed_at < ?`,
+		StateProcessed, StateRejected, before.UnixNano(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
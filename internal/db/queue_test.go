@@ -0,0 +1,143 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestQueueMaintenance(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	if s, err := GetQueueStats(db); err != nil || s != (QueueStats{}) {
+		t.Fatalf("empty queue stats = %+v, %v", s, err)
+	}
+
+	start := time.Now()
+	ids, _, err := EnqueueTrades(db, []Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc1", Symbol: "EURUSD", Volume: 2, Open: 1.1, Close: 1.0, Side: "buy"},
+		{Account: "acc1", Symbol: "GBPUSD", Volume: 1, Open: 1.3, Close: 1.2, Side: "sell"},
+		{Account: "acc2", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[:2] {
+		tr, _ := GetTrade(db, id)
+		if err := ApplyTrade(db, tr.Trade, tr.Profit()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RejectTrade(db, ids[2], "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := GetQueueStats(db)
+	if err != nil || s.Pending != 1 || s.Processed != 2 || s.Rejected != 1 || s.OldestPending.Before(start.Add(-time.Second)) {
+		t.Fatalf("queue stats = %+v, %v", s, err)
+	}
+
+	if err := RequeueTrade(db, ids[2]); err != nil {
+		t.Fatalf("RequeueTrade: %v", err)
+	}
+	if tr, _ := GetTrade(db, ids[2]); tr.State != StatePending || tr.Reason != "" || !tr.ProcessedAt.IsZero() {
+		t.Errorf("requeued trade = %+v", tr)
+	}
+	if err := RequeueTrade(db, ids[0]); !errors.Is(err, ErrNotRejected) {
+		t.Errorf("requeue of a processed trade: err = %v", err)
+	}
+	if err := RequeueTrade(db, 99); !errors.Is(err, ErrTradeNotFound) {
+		t.Errorf("requeue of a missing trade: err = %v", err)
+	}
+
+	// Purging keeps the processed trades' totals, so a rebuild matches.
+	if n, err := PurgeTrades(db, start.Add(-time.Hour), Trade.Profit); err != nil || n != 0 {
+		t.Fatalf("purge of nothing = %d, %v", n, err)
+	}
+	if n, err := PurgeTrades(db, time.Now().Add(time.Second), Trade.Profit); err != nil || n != 2 {
+		t.Fatalf("PurgeTrades = %d, %v", n, err)
+	}
+	if _, err := GetTrade(db, ids[0]); !errors.Is(err, ErrTradeNotFound) {
+		t.Errorf("purged trade still queued: %v", err)
+	}
+	stored, rebuilt, err := RebuildStats(db, Trade.Profit, false)
+	if err != nil || len(rebuilt) != 1 || rebuilt[0].Trades != 2 || rebuilt[0].Profit != stored[0].Profit {
+		t.Errorf("rebuilt after purge = %+v, stored %+v, %v", rebuilt, stored, err)
+	}
+
+	if cleared, err := ResetStats(db, "acc1"); err != nil || cleared.Trades != 2 {
+		t.Fatalf("ResetStats = %+v, %v", cleared, err)
+	}
+	if st, _ := GetStats(db, "acc1"); st.Trades != 0 || st.Profit != 0 {
+		t.Errorf("stats after reset = %+v", st)
+	}
+	if cleared, err := ResetStats(db, "acc9"); err != nil || cleared.Trades != 0 {
+		t.Errorf("reset of an account without stats = %+v, %v", cleared, err)
+	}
+}
+
+func TestPurgeKeepsDedupKeys(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	keyed := []Trade{{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}}
+	ids, _, err := EnqueueTrades(db, keyed, "", "order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp, err := CreateImport(db, "acc1", "csv", "history.csv", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := []ImportedTrade{{Ticket: "1001", Trade: Trade{Account: "acc1", Symbol: "GBPUSD", Volume: 1, Open: 1.3, Close: 1.2, Side: "sell"}}}
+	if queued, _, err := RecordImportBatch(db, imp.ID, imported, nil, 0); err != nil || queued != 1 {
+		t.Fatalf("RecordImportBatch = %d queued, %v", queued, err)
+	}
+	if err := FinishImport(db, imp.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := ListTrades(db, TradeFilter{States: []int{StatePending}}, 0, 10)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("pending trades = %+v, %v", pending, err)
+	}
+	for _, tr := range pending {
+		if err := ApplyTrade(db, tr.Trade, tr.Profit()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := PurgeTrades(db, time.Now().Add(time.Second), Trade.Profit); err != nil || n != 2 {
+		t.Fatalf("PurgeTrades = %d, %v", n, err)
+	}
+
+	// Sending the key again replays the purged trade, and importing the
+	// statement again finds its ticket.
+	if again, replayed, err := EnqueueTrades(db, keyed, "", "order-1"); err != nil || !replayed || again[0] != ids[0] {
+		t.Errorf("key sent after a purge = %v, %v, %v; want replay of %v", again, replayed, err, ids)
+	}
+	reimport, _ := CreateImport(db, "acc1", "csv", "history.csv", 0)
+	if queued, dups, err := RecordImportBatch(db, reimport.ID, imported, nil, 0); err != nil || queued != 0 || dups != 1 {
+		t.Errorf("import after a purge = %d queued, %d duplicates, %v", queued, dups, err)
+	}
+	if n, _ := CountPending(db); n != 0 {
+		t.Errorf("%d trades queued again after a purge", n)
+	}
+}
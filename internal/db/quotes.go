@@ -0,0 +1,36 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+var ErrNoQuote = errors.New("no quote for symbol")
+
+type Quote = trade.Quote
+
+// UpsertQuote stores q as the latest quote for its symbol unless a newer one
+// is already stored, so replaying an older feed can't overwrite live prices.
+func UpsertQuote(db *sql.DB, q Quote) error {
+	_, err := db.Exec(
+		`INSERT INTO quotes (symbol, bid, ask, ts) VALUES (?, ?, ?, ?)
+		ON CONFLICT(symbol) DO UPDATE SET bid = excluded.bid, ask = excluded.ask, ts = excluded.ts
+		WHERE excluded.ts >= quotes.ts`,
+		q.Symbol, q.Bid, q.Ask, q.Time.UnixNano(),
+	)
+	return err
+}
+
+func GetQuote(db *sql.DB, symbol string) (Quote, error) {
+	q := Quote{Symbol: symbol}
+	var ts int64
+	err := db.QueryRow(`SELECT bid, ask, ts FROM quotes WHERE symbol = ?`, symbol).Scan(&q.Bid, &q.Ask, &ts)
+	if err == sql.ErrNoRows {
+		return q, ErrNoQuote
+	}
+	q.Time = time.Unix(0, ts).UTC()
+	return q, err
+}
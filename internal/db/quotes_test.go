@@ -0,0 +1,49 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestUpsertGetQuote(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	if _, err := GetQuote(conn, "EURUSD"); !errors.Is(err, ErrNoQuote) {
+		t.Errorf("expected ErrNoQuote, got %v", err)
+	}
+
+	now := time.Now().UTC()
+	if err := UpsertQuote(conn, Quote{Symbol: "EURUSD", Bid: 1.1, Ask: 1.2, Time: now}); err != nil {
+		t.Fatalf("UpsertQuote failed: %v", err)
+	}
+	// older quote from a replay is ignored
+	if err := UpsertQuote(conn, Quote{Symbol: "EURUSD", Bid: 1.0, Ask: 1.05, Time: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("UpsertQuote failed: %v", err)
+	}
+	q, err := GetQuote(conn, "EURUSD")
+	if err != nil {
+		t.Fatalf("GetQuote failed: %v", err)
+	}
+	if q.Bid != 1.1 || q.Ask != 1.2 || !q.Time.Equal(now) {
+		t.Errorf("unexpected quote: %+v", q)
+	}
+
+	if err := UpsertQuote(conn, Quote{Symbol: "EURUSD", Bid: 1.3, Ask: 1.4, Time: now.Add(time.Second)}); err != nil {
+		t.Fatalf("UpsertQuote failed: %v", err)
+	}
+	q, _ = GetQuote(conn, "EURUSD")
+	if q.Bid != 1.3 {
+		t.Errorf("newer quote not stored: %+v", q)
+	}
+}
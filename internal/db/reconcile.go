@@ -0,0 +1,219 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// RebuildStats recomputes account_stats from processed trades using profit and
+// returns the stored rows alongside the recomputed ones, both sorted by
+// account. With apply set, account_stats is replaced by the recomputed rows.
+// Everything runs in one transaction so the worker can't apply a trade between
+// the read and the rewrite.
+//
+// Closed position volume counts one trade per processed close event, with the
+// realized profit the worker stored on the position. Trades removed by
+// PurgeTrades count through the totals it kept for them.
+func RebuildStats(db *sql.DB, profit func(Trade) float64, apply bool) (stored, rebuilt []Stats, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	byAccount := map[string]*Stats{}
+	account := func(name string) *Stats {
+		s, ok := byAccount[name]
+		if !ok {
+			s = &Stats{Account: name}
+			byAccount[name] = s
+		}
+		return s
+	}
+
+	rows, err := tx.Query(`SELECT account, trades, profit FROM purged_trades`)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var purged Stats
+		if err := rows.Scan(&purged.Account, &purged.Trades, &purged.Profit); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		s := account(purged.Account)
+		s.Trades += purged.Trades
+		s.Profit += purged.Profit
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query(
+		`SELECT id, account, symbol, volume, open, close, side FROM trades_q WHERE processed = 1 ORDER BY id`,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var t Trade
+		if err := rows.Scan(&t.ID, &t.Account, &t.Symbol, &t.Volume, &t.Open, &t.Close, &t.Side); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		s := account(t.Account)
+		s.Trades++
+		s.Profit += profit(t)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query(
+		`SELECT p.account, p.realized, COUNT(q.id) FROM positions p
+		JOIN position_q q ON q.position = p.id AND q.kind = ? AND q.processed = 1
+		GROUP BY p.id`,
+		PositionClose,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var (
+			name     string
+			realized float64
+			closes   int
+		)
+		if err := rows.Scan(&name, &realized, &closes); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		s := account(name)
+		s.Trades += closes
+		s.Profit += realized
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range byAccount {
+		rebuilt = append(rebuilt, *s)
+	}
+	sort.Slice(rebuilt, func(i, j int) bool { return rebuilt[i].Account < rebuilt[j].Account })
+
+	stored, err = listStats(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !apply {
+		return stored, rebuilt, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM account_stats`); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range rebuilt {
+		if _, err := tx.Exec(
+			`INSERT INTO account_stats (account, trades, profit) VALUES (?, ?, ?)`,
+			s.Account, s.Trades, s.Profit,
+		); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Every account whose row changed gets a stats.rebuilt event with its
+	// new totals, zero for accounts left without processed trades.
+	now := time.Now()
+	changed := map[string]StatsChange{}
+	for _, s := range stored {
+		changed[s.Account] = StatsChange{Account: s.Account}
+	}
+	for _, s := range rebuilt {
+		changed[s.Account] = StatsChange{Account: s.Account, Trades: s.Trades, Profit: s.Profit}
+	}
+	for _, s := range stored {
+		if ch := changed[s.Account]; ch.Trades == s.Trades && ch.Profit == s.Profit {
+			delete(changed, s.Account)
+		}
+	}
+	accounts := make([]string, 0, len(changed))
+	for acc := range changed {
+		accounts = append(accounts, acc)
+	}
+	sort.Strings(accounts)
+	for _, acc := range accounts {
+		if err := appendEvent(tx, EventStatsRebuilt, acc, changed[acc], now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return stored, rebuilt, tx.Commit()
+}
+
+func ListStats(db *sql.DB) ([]Stats, error) {
+	return listStats(db)
+}
+
+func listStats(q querier) ([]Stats, error) {
+	rows, err := q.Query(`SELECT account, trades, profit FROM account_stats ORDER BY account`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []Stats
+	for rows.Next() {
+		var s Stats
+		if err := rows.Scan(&s.Account, &s.Trades, &s.Profit); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// StatsFilter selects account totals: those of Account unless it is empty,
+// as they stood at At, or now if At is zero.
+type StatsFilter struct {
+	Account string
+	At      time.Time
+}
+
+// ListStatsAt returns up to limit accounts' totals matching f, in account
+// order after the account named after. Past totals come from the stats
+// events, so accounts with no change recorded by At are left out.
+func ListStatsAt(db *sql.DB, f StatsFilter, after string, limit int) ([]Stats, error) {
+	var (
+		query string
+		args  []any
+	)
+	if f.At.IsZero() {
+		query = `SELECT account, trades, profit FROM account_stats WHERE account > ?`
+		args = []any{after}
+	} else {
+		query = `SELECT account, json_extract(payload, '$.trades'), json_extract(payload, '$.profit') FROM events
+			WHERE id IN (SELECT MAX(id) FROM events WHERE type IN (?, ?) AND created_at <= ? GROUP BY account) AND account > ?`
+		args = []any{EventStatsUpdated, EventStatsRebuilt, f.At.UnixNano(), after}
+	}
+	if f.Account != "" {
+		query += ` AND account = ?`
+		args = append(args, f.Account)
+	}
+	query += ` ORDER BY account LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []Stats
+	for rows.Next() {
+		var s Stats
+		if err := rows.Scan(&s.Account, &s.Trades, &s.Profit); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testProfit(t Trade) float64 {
+	return (t.Close - t.Open) * t.Volume
+}
+
+func TestRebuildStats(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	for _, tr := range []Trade{
+		{Account: "acc1", Symbol: "ABCDEF", Volume: 1, Open: 1, Close: 3, Side: "buy"},
+		{Account: "acc1", Symbol: "ABCDEF", Volume: 2, Open: 1, Close: 2, Side: "buy"},
+		{Account: "acc2", Symbol: "ABCDEF", Volume: 1, Open: 1, Close: 2, Side: "buy"},
+	} {
+		if err := EnqueueTrade(conn, tr); err != nil {
+			t.Fatalf("EnqueueTrade failed: %v", err)
+		}
+	}
+	// only the first two are processed; acc2 is still pending
+	if _, err := conn.Exec(`UPDATE trades_q SET processed = 1 WHERE id IN (1, 2)`); err != nil {
+		t.Fatalf("mark processed failed: %v", err)
+	}
+	// drifted row for acc1 and a stale row for acc3
+	if err := UpdateStats(conn, "acc1", 1); err != nil {
+		t.Fatalf("UpdateStats failed: %v", err)
+	}
+	if err := UpdateStats(conn, "acc3", 5); err != nil {
+		t.Fatalf("UpdateStats failed: %v", err)
+	}
+
+	stored, rebuilt, err := RebuildStats(conn, testProfit, false)
+	if err != nil {
+		t.Fatalf("RebuildStats failed: %v", err)
+	}
+	if len(stored) != 2 || stored[0].Account != "acc1" || stored[1].Account != "acc3" {
+		t.Errorf("unexpected stored stats: %+v", stored)
+	}
+	if len(rebuilt) != 1 || rebuilt[0] != (Stats{Account: "acc1", Trades: 2, Profit: 4}) {
+		t.Errorf("unexpected rebuilt stats: %+v", rebuilt)
+	}
+
+	// dry run must not touch account_stats
+	s, _ := GetStats(conn, "acc3")
+	if s.Trades != 1 {
+		t.Errorf("dry run modified account_stats: %+v", s)
+	}
+
+	if _, _, err := RebuildStats(conn, testProfit, true); err != nil {
+		t.Fatalf("RebuildStats apply failed: %v", err)
+	}
+	all, err := ListStats(conn)
+	if err != nil {
+		t.Fatalf("ListStats failed: %v", err)
+	}
+	if len(all) != 1 || all[0] != (Stats{Account: "acc1", Trades: 2, Profit: 4}) {
+		t.Errorf("unexpected stats after apply: %+v", all)
+	}
+}
+
+func TestListStatsAt(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed open db: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	if err := InitDB(conn); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+
+	UpdateStats(conn, "acc1", 10)
+	UpdateStats(conn, "acc2", 5)
+	cut := time.Now()
+	time.Sleep(time.Millisecond)
+	UpdateStats(conn, "acc1", 2.5)
+	UpdateStats(conn, "acc3", 1)
+
+	now, err := ListStatsAt(conn, StatsFilter{}, "", 10)
+	if err != nil || len(now) != 3 || now[0] != (Stats{"acc1", 2, 12.5}) {
+		t.Fatalf("current stats = %+v, %v", now, err)
+	}
+	past, err := ListStatsAt(conn, StatsFilter{At: cut}, "", 10)
+	if err != nil || len(past) != 2 || past[0] != (Stats{"acc1", 1, 10}) || past[1] != (Stats{"acc2", 1, 5}) {
+		t.Fatalf("stats at cut = %+v, %v", past, err)
+	}
+	if page, _ := ListStatsAt(conn, StatsFilter{}, "acc1", 1); len(page) != 1 || page[0].Account != "acc2" {
+		t.Errorf("page after acc1 = %+v", page)
+	}
+	if one, _ := ListStatsAt(conn, StatsFilter{Account: "acc2", At: cut}, "", 10); len(one) != 1 || one[0].Account != "acc2" {
+		t.Errorf("acc2 at cut = %+v", one)
+	}
+}
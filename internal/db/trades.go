@@ -0,0 +1,232 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTradeNotFound       = errors.New("trade not found")
+	ErrIdempotencyConflict = errors.New("idempotency key was used for different trades")
+)
+
+// QueuedTrade is a row of trades_q with its processing outcome. CreatedAt
+// and ProcessedAt are zero when they are not known, for trades queued
+// before they were recorded.
+type QueuedTrade struct {
+	Trade
+	State       int
+	Reason      string
+	CreatedAt   time.Time
+	ProcessedAt time.Time
+}
+
+// TradeFilter selects rows of trades_q; zero fields match every trade. From
+// and To bound the time a trade was queued, To exclusive: for an imported
+// trade, the time of its import. Trades queued before queue times were
+// recorded count as queued when they were processed, or at the epoch while
+// that is unknown too, so they match To but not From.
+type TradeFilter struct {
+	Account string
+	Symbol  string
+	States  []int
+	From    time.Time
+	To      time.Time
+}
+
+const queuedTradeColumns = `id, account, symbol, volume, open, close, side, processed, COALESCE(reason, ''), COALESCE(request_id, ''), COALESCE(created_at, 0), COALESCE(processed_at, 0)`
+
+func scanQueuedTrade(row interface{ Scan(...any) error }) (QueuedTrade, error) {
+	var (
+		t                  QueuedTrade
+		created, processed int64
+	)
+	err := row.Scan(&t.ID, &t.Account, &t.Symbol, &t.Volume, &t.Open, &t.Close, &t.Side, &t.State, &t.Reason, &t.RequestID, &created, &processed)
+	if created != 0 {
+		t.CreatedAt = time.Unix(0, created).UTC()
+	}
+	if processed != 0 {
+		t.ProcessedAt = time.Unix(0, processed).UTC()
+	}
+	return t, err
+}
+
+// EnqueueTrades queues ts in one transaction and returns their ids in order.
+// A non-empty key makes the call idempotent within scope: when trades were
+// already queued under (scope, key), their ids are returned with replayed set
+// instead of queueing ts again, or ErrIdempotencyConflict if they differ from
+// ts. An empty scope stands for AccountScope(ts).
+func EnqueueTrades(db *sql.DB, ts []Trade, scope, key string) (ids []int, replayed bool, err error) {
+	if key != "" && scope == "" {
+		scope = AccountScope(ts)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if key != "" {
+		ids, err := replayTrades(tx, ts, scope, key)
+		if err != nil || ids != nil {
+			return ids, ids != nil, err
+		}
+	}
+
+	now := time.Now().UnixNano()
+	keyed := make([]keyedTrade, 0, len(ts))
+	for _, t := range ts {
+		res, err := tx.Exec(
+			`INSERT INTO trades_q (account, symbol, volume, open, close, side, request_id, trace_parent, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.Account, t.Symbol, t.Volume, t.Open, t.Close, t.Side, t.RequestID, t.TraceParent, now,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, false, err
+		}
+		ids = append(ids, int(id))
+		keyed = append(keyed, keyedTrade{int(id), t.Account, t.Symbol, t.Volume, t.Open, t.Close, t.Side})
+	}
+
+	if key != "" {
+		b, err := json.Marshal(keyed)
+		if err != nil {
+			return nil, false, err
+		}
+		res, err := tx.Exec(
+			`INSERT INTO idempotency_keys (scope, key, trades, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (scope, key) DO NOTHING`,
+			scope, key, string(b), now,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, false, err
+		} else if n == 0 {
+			// another connection committed the key first
+			tx.Rollback()
+			ids, err := replayTrades(db, ts, scope, key)
+			return ids, ids != nil, err
+		}
+	}
+	return ids, false, tx.Commit()
+}
+
+// AccountScope is the idempotency scope of callers without an identity: the
+// account of the first trade, so keys sent for different accounts never meet.
+func AccountScope(ts []Trade) string {
+	if len(ts) == 0 {
+		return ""
+	}
+	return "account:" + ts[0].Account
+}
+
+// keyedTrade is a trade as recorded in idempotency_keys, enough to tell a
+// retry from different trades sent under the same key.
+type keyedTrade struct {
+	ID      int     `json:"id"`
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Volume  float64 `json:"volume"`
+	Open    float64 `json:"open"`
+	Close   float64 `json:"close"`
+	Side    string  `json:"side"`
+}
+
+// replayTrades returns the ids of the trades queued under (scope, key), or
+// nil if there are none.
+func replayTrades(q querier, ts []Trade, scope, key string) ([]int, error) {
+	var b string
+	err := q.QueryRow(`SELECT trades FROM idempotency_keys WHERE scope = ? AND key = ?`, scope, key).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keyed []keyedTrade
+	if err := json.Unmarshal([]byte(b), &keyed); err != nil {
+		return nil, fmt.Errorf("invalid trades for idempotency key %q: %v", key, err)
+	}
+
+	if len(keyed) != len(ts) {
+		return nil, ErrIdempotencyConflict
+	}
+	ids := make([]int, len(keyed))
+	for i, k := range keyed {
+		t := Trade{Account: k.Account, Symbol: k.Symbol, Volume: k.Volume, Open: k.Open, Close: k.Close, Side: k.Side}
+		if !sameTrade(t, ts[i]) {
+			return nil, ErrIdempotencyConflict
+		}
+		ids[i] = k.ID
+	}
+	return ids, nil
+}
+
+func sameTrade(a, b Trade) bool {
+	return a.Account == b.Account && a.Symbol == b.Symbol && a.Volume == b.Volume &&
+		a.Open == b.Open && a.Close == b.Close && a.Side == b.Side
+}
+
+func GetTrade(db *sql.DB, id int) (QueuedTrade, error) {
+	t, err := scanQueuedTrade(db.QueryRow(`SELECT `+queuedTradeColumns+` FROM trades_q WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrTradeNotFound
+	}
+	return t, err
+}
+
+// ListTrades returns up to limit trades matching f with IDs above after, in
+// ID order; pass the last ID returned as after to read the next page.
+func ListTrades(db *sql.DB, f TradeFilter, after, limit int) ([]QueuedTrade, error) {
+	query := `SELECT ` + queuedTradeColumns + ` FROM trades_q WHERE id > ?`
+	args := []any{after}
+	if f.Account != "" {
+		query += ` AND account = ?`
+		args = append(args, f.Account)
+	}
+	if f.Symbol != "" {
+		query += ` AND symbol = ?`
+		args = append(args, f.Symbol)
+	}
+	if len(f.States) > 0 {
+		query += ` AND processed IN (?` + strings.Repeat(`, ?`, len(f.States)-1) + `)`
+		for _, s := range f.States {
+			args = append(args, s)
+		}
+	}
+	if !f.From.IsZero() {
+		query += ` AND COALESCE(created_at, 0) >= ?`
+		args = append(args, f.From.UnixNano())
+	}
+	if !f.To.IsZero() {
+		query += ` AND COALESCE(created_at, 0) < ?`
+		args = append(args, f.To.UnixNano())
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trades []QueuedTrade
+	for rows.Next() {
+		t, err := scanQueuedTrade(rows)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
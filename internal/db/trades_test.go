@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestEnqueueTradesIdempotent(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	batch := []Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc2", Symbol: "GBPUSD", Volume: 2, Open: 1.3, Close: 1.2, Side: "sell"},
+	}
+	ids, replayed, err := EnqueueTrades(db, batch, "", "key-1")
+	if err != nil || replayed || len(ids) != 2 {
+		t.Fatalf("EnqueueTrades = %v, %v, %v", ids, replayed, err)
+	}
+
+	again, replayed, err := EnqueueTrades(db, batch, "", "key-1")
+	if err != nil || !replayed || again[0] != ids[0] || again[1] != ids[1] {
+		t.Fatalf("replay = %v, %v, %v; want %v", again, replayed, err, ids)
+	}
+
+	changed := []Trade{batch[0], batch[0]}
+	if _, _, err := EnqueueTrades(db, changed, "", "key-1"); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("different trades under the same key: err = %v", err)
+	}
+	if _, _, err := EnqueueTrades(db, batch[:1], "", "key-1"); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("fewer trades under the same key: err = %v", err)
+	}
+
+	// keys are scoped: another caller, or another account when the caller
+	// has no identity, may use the same key
+	if other, replayed, err := EnqueueTrades(db, batch, "key:other", "key-1"); err != nil || replayed || other[0] == ids[0] {
+		t.Errorf("same key in another scope = %v, %v, %v", other, replayed, err)
+	}
+	if other, replayed, err := EnqueueTrades(db, batch[1:], "", "key-1"); err != nil || replayed || other[0] == ids[1] {
+		t.Errorf("same key for another account = %v, %v, %v", other, replayed, err)
+	}
+
+	// without a key every call queues
+	if ids, _, _ := EnqueueTrades(db, batch[:1], "", ""); len(ids) != 1 || ids[0] <= again[1] {
+		t.Errorf("unkeyed ids = %v", ids)
+	}
+	if n, _ := CountPending(db); n != 6 {
+		t.Errorf("pending = %d, want 6", n)
+	}
+
+	if err := RejectTrade(db, ids[1], "invalid trade payload: test"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetTrade(db, ids[1])
+	if err != nil {
+		t.Fatalf("GetTrade: %v", err)
+	}
+	if got.Account != "acc2" || got.State != StateRejected || got.Reason != "invalid trade payload: test" {
+		t.Errorf("GetTrade = %+v", got)
+	}
+	if _, err := GetTrade(db, 999); !errors.Is(err, ErrTradeNotFound) {
+		t.Errorf("missing trade: err = %v", err)
+	}
+}
+
+func TestListTrades(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	start := time.Now()
+	ids, _, err := EnqueueTrades(db, []Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc1", Symbol: "GBPUSD", Volume: 1, Open: 1.3, Close: 1.2, Side: "sell"},
+		{Account: "acc2", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc1", Symbol: "EURUSD", Volume: 2, Open: 1.1, Close: 1.0, Side: "buy"},
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTrade(db, Trade{ID: ids[0], Account: "acc1"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := RejectTrade(db, ids[3], "no price"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ListTrades(db, TradeFilter{Account: "acc1", Symbol: "EURUSD"}, 0, 10)
+	if err != nil || len(got) != 2 || got[0].ID != ids[0] || got[1].ID != ids[3] {
+		t.Fatalf("ListTrades(acc1, EURUSD) = %+v, %v", got, err)
+	}
+	if got[0].State != StateProcessed || got[0].CreatedAt.Before(start.Add(-time.Second)) || got[0].ProcessedAt.IsZero() {
+		t.Errorf("processed trade = %+v", got[0])
+	}
+	if got[1].State != StateRejected || got[1].Reason != "no price" {
+		t.Errorf("rejected trade = %+v", got[1])
+	}
+
+	pending, _ := ListTrades(db, TradeFilter{States: []int{StatePending}}, 0, 10)
+	if len(pending) != 2 || !pending[0].ProcessedAt.IsZero() {
+		t.Errorf("pending trades = %+v", pending)
+	}
+	page, _ := ListTrades(db, TradeFilter{}, ids[0], 2)
+	if len(page) != 2 || page[0].ID != ids[1] {
+		t.Errorf("second page = %+v", page)
+	}
+	if later, _ := ListTrades(db, TradeFilter{From: time.Now().Add(time.Hour)}, 0, 10); len(later) != 0 {
+		t.Errorf("trades queued in an hour = %+v", later)
+	}
+	if earlier, _ := ListTrades(db, TradeFilter{To: start.Add(-time.Second)}, 0, 10); len(earlier) != 0 {
+		t.Errorf("trades queued before the test = %+v", earlier)
+	}
+
+	if state, err := ParseState("rejected"); err != nil || state != StateRejected || StateName(state) != "rejected" {
+		t.Errorf("ParseState(rejected) = %d, %v", state, err)
+	}
+	if _, err := ParseState("dead"); err == nil {
+		t.Error("ParseState(dead) succeeded")
+	}
+}
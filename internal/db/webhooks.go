@@ -0,0 +1,308 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// States of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrLeaseLost       = errors.New("delivery was claimed again after its lease expired")
+)
+
+// DefaultWebhookEvents are the event types a webhook receives when none are
+// given.
+var DefaultWebhookEvents = []string{EventTradeProcessed, EventTradeRejected}
+
+// Webhook is a subscription to events of some types and accounts. Secret
+// signs the payloads so the receiver can check where they came from.
+type Webhook struct {
+	ID         int
+	URL        string
+	Secret     string
+	Events     []string
+	Accounts   []string
+	CreatedAt  time.Time
+	DisabledAt *time.Time
+}
+
+// Delivery is an event queued for a webhook. It is written in the same
+// transaction as the event, so no change is ever committed without its
+// deliveries, and updated after every attempt.
+type Delivery struct {
+	ID          int64
+	WebhookID   int
+	EventID     int64
+	Type        string
+	Account     string
+	Payload     json.RawMessage
+	CreatedAt   time.Time
+	State       string
+	Attempts    int
+	NextAttempt time.Time
+	StatusCode  int
+	Error       string
+	DeliveredAt *time.Time
+}
+
+// DeliveryResult is the outcome of one attempt. A failed attempt with a zero
+// Retry marks the delivery failed for good.
+type DeliveryResult struct {
+	Delivered  bool
+	StatusCode int
+	Error      string
+	Retry      time.Time
+}
+
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// CreateWebhook subscribes url to events of the given types on the given
+// accounts, or AllAccounts, with a new secret.
+func CreateWebhook(db *sql.DB, url string, events, accounts []string) (Webhook, error) {
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(events) == 0 {
+		events = DefaultWebhookEvents
+	}
+	if len(accounts) == 0 {
+		accounts = []string{AllAccounts}
+	}
+	w := Webhook{
+		URL:       url,
+		Secret:    secret,
+		Events:    events,
+		Accounts:  accounts,
+		CreatedAt: time.Now().UTC(),
+	}
+	res, err := db.Exec(
+		`INSERT INTO webhooks (url, secret, events, accounts, created_at) VALUES (?, ?, ?, ?, ?)`,
+		w.URL, w.Secret, strings.Join(events, ","), strings.Join(accounts, ","), w.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	w.ID = int(id)
+	return w, nil
+}
+
+// DisableWebhook stops new deliveries to a webhook and any still pending.
+func DisableWebhook(db *sql.DB, id int) error {
+	res, err := db.Exec(
+		`UPDATE webhooks SET disabled_at = ? WHERE id = ? AND disabled_at IS NULL`,
+		time.Now().UTC().Unix(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func GetWebhook(db *sql.DB, id int) (Webhook, error) {
+	w, err := scanWebhook(db.QueryRow(
+		`SELECT id, url, secret, events, accounts, created_at, disabled_at FROM webhooks WHERE id = ?`, id,
+	))
+	if err == sql.ErrNoRows {
+		return w, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+func ListWebhooks(db *sql.DB) ([]Webhook, error) {
+	rows, err := db.Query(`SELECT id, url, secret, events, accounts, created_at, disabled_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (Webhook, error) {
+	var (
+		w                Webhook
+		events, accounts string
+		created          int64
+		disabled         sql.NullInt64
+	)
+	if err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &accounts, &created, &disabled); err != nil {
+		return w, err
+	}
+	w.Events = splitList(events)
+	w.Accounts = splitList(accounts)
+	w.CreatedAt = time.Unix(created, 0).UTC()
+	if disabled.Valid {
+		t := time.Unix(disabled.Int64, 0).UTC()
+		w.DisabledAt = &t
+	}
+	return w, nil
+}
+
+// enqueueDeliveries queues an event for every active webhook subscribed to
+// its type and account.
+func enqueueDeliveries(q querier, eventID int64, typ, account string, at time.Time) error {
+	_, err := q.Exec(
+		`INSERT INTO webhook_deliveries (webhook_id, event_id, state, attempts, next_attempt_at, created_at)
+		SELECT id, ?, ?, 0, ?, ? FROM webhooks
+		WHERE disabled_at IS NULL
+		AND instr(',' || events || ',', ',' || ? || ',') > 0
+		AND (instr(',' || accounts || ',', ',*,') > 0 OR instr(',' || accounts || ',', ',' || ? || ',') > 0)`,
+		eventID, DeliveryPending, at.UnixNano(), at.UnixNano(), typ, account,
+	)
+	return err
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event_id, e.type, e.account, e.payload, d.created_at,
+	d.state, d.attempts, d.next_attempt_at, d.status_code, d.error, d.delivered_at`
+
+// ClaimDeliveries returns up to limit pending deliveries that are due at
+// now, for webhooks that are still active, and pushes their next attempt
+// back so that concurrent dispatchers don't send them too. lease is the time
+// one attempt may take; as the claimed deliveries are sent one after
+// another, they are held for lease times their number, until the
+// NextAttempt they are returned with. A dispatcher that dies mid-attempt
+// leaves them to be retried once that has passed.
+func ClaimDeliveries(db *sql.DB, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT `+deliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN events e ON e.id = d.event_id
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.state = ? AND d.next_attempt_at <= ? AND w.disabled_at IS NULL
+		ORDER BY d.next_attempt_at, d.id LIMIT ?`,
+		DeliveryPending, now.UnixNano(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	until := now.Add(lease * time.Duration(len(ds)))
+	for i := range ds {
+		if _, err := tx.Exec(
+			`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?`,
+			until.UnixNano(), ds[i].ID,
+		); err != nil {
+			return nil, err
+		}
+		ds[i].NextAttempt = until
+	}
+	return ds, tx.Commit()
+}
+
+// RecordDelivery stores the outcome of an attempt at delivery id, claimed
+// until lease. It returns ErrLeaseLost, and records nothing, if the delivery
+// has been claimed again since, so only the latest claim's attempt counts.
+func RecordDelivery(db *sql.DB, id int64, lease time.Time, r DeliveryResult, at time.Time) error {
+	state, next := DeliveryPending, r.Retry.UnixNano()
+	var delivered sql.NullInt64
+	switch {
+	case r.Delivered:
+		state, next = DeliveryDelivered, 0
+		delivered = sql.NullInt64{Int64: at.UnixNano(), Valid: true}
+	case r.Retry.IsZero():
+		state, next = DeliveryFailed, 0
+	}
+	res, err := db.Exec(
+		`UPDATE webhook_deliveries SET state = ?, attempts = attempts + 1, next_attempt_at = ?,
+		status_code = ?, error = ?, delivered_at = ? WHERE id = ? AND state = ? AND next_attempt_at = ?`,
+		state, next, r.StatusCode, r.Error, delivered, id, DeliveryPending, lease.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries to a webhook, newest first.
+func ListDeliveries(db *sql.DB, webhookID, limit int) ([]Delivery, error) {
+	rows, err := db.Query(
+		`SELECT `+deliveryColumns+`
+		FROM webhook_deliveries d JOIN events e ON e.id = d.event_id
+		WHERE d.webhook_id = ? ORDER BY d.id DESC LIMIT ?`,
+		webhookID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+func scanDeliveries(rows *sql.Rows) ([]Delivery, error) {
+	defer rows.Close()
+
+	var ds []Delivery
+	for rows.Next() {
+		var (
+			d             Delivery
+			payload       string
+			created, next int64
+			delivered     sql.NullInt64
+		)
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Type, &d.Account, &payload, &created,
+			&d.State, &d.Attempts, &next, &d.StatusCode, &d.Error, &delivered); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		d.CreatedAt = time.Unix(0, created).UTC()
+		if next != 0 {
+			d.NextAttempt = time.Unix(0, next).UTC()
+		}
+		if delivered.Valid {
+			t := time.Unix(0, delivered.Int64).UTC()
+			d.DeliveredAt = &t
+		}
+		ds = append(ds, d)
+	}
+	return ds, rows.Err()
+}
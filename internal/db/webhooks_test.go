@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestWebhookOutbox(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	all, err := CreateWebhook(db, "http://crm.example/hook", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if len(all.Events) != 2 || all.Accounts[0] != AllAccounts || len(all.Secret) < 32 {
+		t.Fatalf("defaults = %+v", all)
+	}
+	risk, err := CreateWebhook(db, "http://risk.example/hook", []string{EventTradeRejected}, []string{"acc2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := CreateWebhook(db, "http://gone.example/hook", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DisableWebhook(db, gone.ID); err != nil {
+		t.Fatalf("DisableWebhook: %v", err)
+	}
+	if err := DisableWebhook(db, gone.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("second DisableWebhook: err = %v", err)
+	}
+
+	ids, _, err := EnqueueTrades(db, []Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc2", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 0, Side: "buy"},
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 0, Side: "buy"},
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTrade(db, Trade{ID: ids[0], Account: "acc1"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[1:] {
+		if err := RejectTrade(db, id, "close must be > 0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not a subscribed event type
+	if err := UpdateStats(db, "acc1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := ListDeliveries(db, all.ID, 10)
+	if err != nil {
+		t.Fatalf("ListDeliveries: %v", err)
+	}
+	if len(ds) != 3 || ds[0].Type != EventTradeRejected || ds[2].Type != EventTradeProcessed || ds[0].State != DeliveryPending {
+		t.Fatalf("deliveries to the catch-all webhook = %+v", ds)
+	}
+	ds, err = ListDeliveries(db, risk.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].Account != "acc2" || ds[0].Type != EventTradeRejected {
+		t.Fatalf("deliveries to the filtered webhook = %+v", ds)
+	}
+	if ds, _ := ListDeliveries(db, gone.ID, 10); len(ds) != 0 {
+		t.Errorf("disabled webhook got %d deliveries", len(ds))
+	}
+
+	now := time.Now()
+	claimed, err := ClaimDeliveries(db, now, time.Minute, 2)
+	if err != nil {
+		t.Fatalf("ClaimDeliveries: %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].EventID >= claimed[1].EventID {
+		t.Fatalf("claimed = %+v", claimed)
+	}
+	// sent one after another, both are held for a lease each
+	if until := now.Add(2 * time.Minute); !claimed[0].NextAttempt.Equal(until) || !claimed[1].NextAttempt.Equal(until) {
+		t.Errorf("claimed until %v and %v, want %v", claimed[0].NextAttempt, claimed[1].NextAttempt, until)
+	}
+	rest, err := ClaimDeliveries(db, now, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 {
+		t.Fatalf("second claim got %d deliveries, want the 2 unclaimed", len(rest))
+	}
+	if again, _ := ClaimDeliveries(db, now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("leased deliveries claimed again: %+v", again)
+	}
+
+	if err := RecordDelivery(db, claimed[0].ID, claimed[0].NextAttempt, DeliveryResult{Delivered: true, StatusCode: 204}, now); err != nil {
+		t.Fatalf("RecordDelivery: %v", err)
+	}
+	if err := RecordDelivery(db, claimed[1].ID, claimed[1].NextAttempt, DeliveryResult{StatusCode: 500, Error: "500 Internal Server Error", Retry: now.Add(time.Second)}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordDelivery(db, rest[0].ID, rest[0].NextAttempt, DeliveryResult{Error: "connection refused"}, now); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := ClaimDeliveries(db, now.Add(2*time.Second), time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retried) != 1 || retried[0].ID != claimed[1].ID || retried[0].Attempts != 1 || retried[0].StatusCode != 500 {
+		t.Fatalf("due retries = %+v", retried)
+	}
+
+	// Once its lease expires a delivery is claimed again, and only the new
+	// claim may record an attempt.
+	reclaimed, err := ClaimDeliveries(db, retried[0].NextAttempt, time.Minute, 10)
+	if err != nil || len(reclaimed) != 1 {
+		t.Fatalf("claim after the lease expired = %+v, %v", reclaimed, err)
+	}
+	if err := RecordDelivery(db, retried[0].ID, retried[0].NextAttempt, DeliveryResult{Delivered: true}, now); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("RecordDelivery under an expired lease = %v, want ErrLeaseLost", err)
+	}
+	if err := RecordDelivery(db, reclaimed[0].ID, reclaimed[0].NextAttempt, DeliveryResult{StatusCode: 500, Retry: now.Add(time.Hour)}, now); err != nil {
+		t.Errorf("RecordDelivery under the new lease: %v", err)
+	}
+
+	byID := map[int64]Delivery{}
+	for _, hook := range []int{all.ID, risk.ID} {
+		ds, _ := ListDeliveries(db, hook, 10)
+		for _, d := range ds {
+			byID[d.ID] = d
+		}
+	}
+	if d := byID[claimed[0].ID]; d.State != DeliveryDelivered || d.DeliveredAt == nil || d.Attempts != 1 {
+		t.Errorf("delivered = %+v", d)
+	}
+	if d := byID[rest[0].ID]; d.State != DeliveryFailed || d.Error != "connection refused" {
+		t.Errorf("failed = %+v", d)
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 )
 
 func ProcessPending(db *sql.DB) error {
@@ -10,14 +11,12 @@ func ProcessPending(db *sql.DB) error {
 		return err
 	}
 	for _, t := range trades {
-		profit := (t.Close - t.Open) * t.Volume * 100000.0
-		if t.Side == "sell" {
-			profit = -profit
+		if verr := t.Validate(); verr != nil {
+			err = RejectTrade(db, t.ID, verr.Error())
+		} else {
+			err = ApplyTrade(db, t, t.Profit())
 		}
-		if err := UpdateStats(db, t.Account, profit); err != nil {
-			return err
-		}
-		if err := MarkProcessed(db, t.ID); err != nil {
+		if err != nil && !errors.Is(err, ErrNotPending) {
 			return err
 		}
 	}
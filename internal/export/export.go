@@ -0,0 +1,285 @@
+// Package export writes queued trades and account stats as CSV, NDJSON or
+// Parquet. Rows are read from the database a page at a time and written as
+// they are read, so an export never holds a whole table in memory.
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+// Formats an export can be written in.
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+const (
+	pageSize = 1000
+	// rowGroupSize bounds the rows a Parquet file buffers before writing
+	// them out as a row group.
+	rowGroupSize = 50000
+)
+
+var ErrFormat = errors.New("export format must be csv, ndjson or parquet")
+
+// ContentType returns the media type of format, or "" if it is unknown.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return ""
+}
+
+// TradeRow is a row of a trades export. Times are zero for trades queued
+// before they were recorded.
+type TradeRow struct {
+	ID          int       `json:"id"`
+	Account     string    `json:"account"`
+	Symbol      string    `json:"symbol"`
+	Side        string    `json:"side"`
+	Volume      float64   `json:"volume"`
+	Open        float64   `json:"open"`
+	Close       float64   `json:"close"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+	ProcessedAt time.Time `json:"processed_at,omitzero"`
+}
+
+var tradeHeader = []string{"id", "account", "symbol", "side", "volume", "open", "close", "status", "reason", "created_at", "processed_at"}
+
+func (t TradeRow) csvRecord() []string {
+	return []string{
+		strconv.Itoa(t.ID), t.Account, t.Symbol, t.Side,
+		formatFloat(t.Volume), formatFloat(t.Open), formatFloat(t.Close),
+		t.Status, t.Reason, formatTime(t.CreatedAt), formatTime(t.ProcessedAt),
+	}
+}
+
+type parquetTrade struct {
+	ID          int64   `parquet:"id"`
+	Account     string  `parquet:"account,dict"`
+	Symbol      string  `parquet:"symbol,dict"`
+	Side        string  `parquet:"side,dict"`
+	Volume      float64 `parquet:"volume"`
+	Open        float64 `parquet:"open"`
+	Close       float64 `parquet:"close"`
+	Status      string  `parquet:"status,dict"`
+	Reason      string  `parquet:"reason,optional"`
+	CreatedAt   int64   `parquet:"created_at,optional,timestamp(millisecond)"`
+	ProcessedAt int64   `parquet:"processed_at,optional,timestamp(millisecond)"`
+}
+
+func (t TradeRow) parquetRow() parquetTrade {
+	return parquetTrade{
+		ID: int64(t.ID), Account: t.Account, Symbol: t.Symbol, Side: t.Side,
+		Volume: t.Volume, Open: t.Open, Close: t.Close, Status: t.Status, Reason: t.Reason,
+		CreatedAt: unixMilli(t.CreatedAt), ProcessedAt: unixMilli(t.ProcessedAt),
+	}
+}
+
+// StatsRow is a row of a stats export: an account's totals as of a time.
+type StatsRow struct {
+	Account string    `json:"account"`
+	Trades  int       `json:"trades"`
+	Profit  float64   `json:"profit"`
+	AsOf    time.Time `json:"as_of"`
+}
+
+var statsHeader = []string{"account", "trades", "profit", "as_of"}
+
+func (s StatsRow) csvRecord() []string {
+	return []string{s.Account, strconv.Itoa(s.Trades), formatFloat(s.Profit), formatTime(s.AsOf)}
+}
+
+type parquetStats struct {
+	Account string  `parquet:"account"`
+	Trades  int64   `parquet:"trades"`
+	Profit  float64 `parquet:"profit"`
+	AsOf    int64   `parquet:"as_of,timestamp(millisecond)"`
+}
+
+func (s StatsRow) parquetRow() parquetStats {
+	return parquetStats{Account: s.Account, Trades: int64(s.Trades), Profit: s.Profit, AsOf: unixMilli(s.AsOf)}
+}
+
+// Trades writes the trades matching f to w in format and returns how many
+// were written.
+func Trades(w io.Writer, format string, db *sql.DB, f dbm.TradeFilter) (int, error) {
+	after := 0
+	return write(w, format, tradeHeader, func() ([]TradeRow, error) {
+		page, err := dbm.ListTrades(db, f, after, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list trades: %v", err)
+		}
+		rows := make([]TradeRow, len(page))
+		for i, t := range page {
+			rows[i] = TradeRow{
+				ID: t.ID, Account: t.Account, Symbol: t.Symbol, Side: t.Side,
+				Volume: t.Volume, Open: t.Open, Close: t.Close,
+				Status: dbm.StateName(t.State), Reason: t.Reason,
+				CreatedAt: t.CreatedAt, ProcessedAt: t.ProcessedAt,
+			}
+			after = t.ID
+		}
+		return rows, nil
+	})
+}
+
+// Stats writes the account totals matching f to w in format and returns how
+// many were written.
+func Stats(w io.Writer, format string, db *sql.DB, f dbm.StatsFilter) (int, error) {
+	asOf := f.At
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+	after := ""
+	return write(w, format, statsHeader, func() ([]StatsRow, error) {
+		page, err := dbm.ListStatsAt(db, f, after, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stats: %v", err)
+		}
+		rows := make([]StatsRow, len(page))
+		for i, s := range page {
+			rows[i] = StatsRow{Account: s.Account, Trades: s.Trades, Profit: s.Profit, AsOf: asOf.UTC()}
+			after = s.Account
+		}
+		return rows, nil
+	})
+}
+
+type row[P any] interface {
+	csvRecord() []string
+	parquetRow() P
+}
+
+// write writes the pages returned by next until it returns an empty one.
+func write[T row[P], P any](w io.Writer, format string, header []string, next func() ([]T, error)) (int, error) {
+	n := 0
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for {
+			rows, err := next()
+			if err != nil {
+				return n, err
+			}
+			if len(rows) == 0 {
+				cw.Flush()
+				return n, cw.Error()
+			}
+			for _, r := range rows {
+				cw.Write(r.csvRecord())
+			}
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return n, err
+			}
+			n += len(rows)
+		}
+
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for {
+			rows, err := next()
+			if err != nil || len(rows) == 0 {
+				return n, err
+			}
+			for _, r := range rows {
+				if err := enc.Encode(r); err != nil {
+					return n, err
+				}
+				n++
+			}
+		}
+
+	case FormatParquet:
+		pw := parquet.NewGenericWriter[P](w,
+			parquet.Compression(&parquet.Zstd),
+			parquet.MaxRowsPerRowGroup(rowGroupSize),
+			parquet.CreatedBy("go-broker-test", "", ""),
+		)
+		buf := make([]P, 0, pageSize)
+		for {
+			rows, err := next()
+			if err != nil {
+				return n, err
+			}
+			if len(rows) == 0 {
+				return n, pw.Close()
+			}
+			buf = buf[:0]
+			for _, r := range rows {
+				buf = append(buf, r.parquetRow())
+			}
+			if _, err := pw.Write(buf); err != nil {
+				return n, err
+			}
+			n += len(rows)
+		}
+	}
+	return 0, ErrFormat
+}
+
+// ParseStatuses reads a comma-separated list of trade statuses, like
+// "processed,rejected".
+func ParseStatuses(s string) ([]int, error) {
+	var states []int
+	for _, name := range strings.Split(s, ",") {
+		state, err := dbm.ParseState(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// ParseTime reads a time bound given as an RFC 3339 time or a date, which
+// stands for its midnight UTC.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/parquet-go/parquet-go"
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func setupDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open memory db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err := dbm.InitDB(db); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	// more than a page, so exports read several
+	trades := make([]dbm.Trade, pageSize+200)
+	for i := range trades {
+		trades[i] = dbm.Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}
+		if i%2 == 1 {
+			trades[i].Account, trades[i].Symbol = "acc2", "GBPUSD"
+		}
+	}
+	ids, _, err := dbm.EnqueueTrades(db, trades, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbm.RejectTrade(db, ids[1], "no price"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestTrades(t *testing.T) {
+	db := setupDB(t)
+	all := dbm.TradeFilter{}
+
+	var buf bytes.Buffer
+	n, err := Trades(&buf, FormatCSV, db, all)
+	if err != nil || n != pageSize+200 {
+		t.Fatalf("CSV export = %d, %v", n, err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(records) != n+1 {
+		t.Fatalf("CSV has %d records, %v", len(records), err)
+	}
+	if got := records[1]; got[0] != "1" || got[1] != "acc1" || got[4] != "1" || got[7] != "processed" || got[9] == "" || got[10] == "" {
+		t.Errorf("first CSV record = %q", got)
+	}
+	if got := records[2]; got[7] != "rejected" || got[8] != "no price" {
+		t.Errorf("second CSV record = %q", got)
+	}
+
+	buf.Reset()
+	rejected := dbm.TradeFilter{Account: "acc2", Symbol: "GBPUSD", States: []int{dbm.StateRejected}}
+	if n, err := Trades(&buf, FormatNDJSON, db, rejected); err != nil || n != 1 {
+		t.Fatalf("NDJSON export = %d, %v", n, err)
+	}
+	var row map[string]any
+	if err := json.NewDecoder(&buf).Decode(&row); err != nil || row["id"] != 2.0 || row["status"] != "rejected" || row["processed_at"] == nil {
+		t.Errorf("NDJSON row = %v, %v", row, err)
+	}
+
+	buf.Reset()
+	if n, err := Trades(&buf, FormatParquet, db, dbm.TradeFilter{Account: "acc1"}); err != nil || n != pageSize/2+100 {
+		t.Fatalf("Parquet export = %d, %v", n, err)
+	}
+	rows, err := parquet.Read[parquetTrade](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(rows) != pageSize/2+100 {
+		t.Fatalf("Parquet file has %d rows, %v", len(rows), err)
+	}
+	if rows[0].ID != 1 || rows[0].Status != "processed" || rows[0].ProcessedAt == 0 || rows[1].ProcessedAt != 0 || rows[1].Account != "acc1" {
+		t.Errorf("Parquet rows = %+v, %+v", rows[0], rows[1])
+	}
+
+	if _, err := Trades(&buf, "xlsx", db, all); !errors.Is(err, ErrFormat) {
+		t.Errorf("unknown format: err = %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db := setupDB(t)
+	dbm.UpdateStats(db, "acc2", 5)
+
+	var buf bytes.Buffer
+	if n, err := Stats(&buf, FormatNDJSON, db, dbm.StatsFilter{}); err != nil || n != 2 {
+		t.Fatalf("NDJSON export = %d, %v", n, err)
+	}
+	sc := bufio.NewScanner(&buf)
+	sc.Scan()
+	var row StatsRow
+	if err := json.Unmarshal(sc.Bytes(), &row); err != nil || row.Account != "acc1" || row.Trades != 1 || row.Profit != 10 || row.AsOf.IsZero() {
+		t.Errorf("first stats row = %+v, %v", row, err)
+	}
+
+	buf.Reset()
+	past := time.Now().Add(-time.Hour)
+	if n, err := Stats(&buf, FormatCSV, db, dbm.StatsFilter{At: past}); err != nil || n != 0 {
+		t.Fatalf("stats an hour ago = %d, %v", n, err)
+	}
+	if buf.String() != "account,trades,profit,as_of\n" {
+		t.Errorf("empty CSV = %q", buf.String())
+	}
+
+	buf.Reset()
+	if n, err := Stats(&buf, FormatParquet, db, dbm.StatsFilter{Account: "acc2"}); err != nil || n != 1 {
+		t.Fatalf("Parquet export = %d, %v", n, err)
+	}
+	rows, err := parquet.Read[parquetStats](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(rows) != 1 || rows[0].Account != "acc2" || rows[0].Profit != 5 {
+		t.Errorf("Parquet rows = %+v, %v", rows, err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	if got, err := ParseTime("2026-09-01"); err != nil || !got.Equal(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ParseTime(date) = %v, %v", got, err)
+	}
+	if got, err := ParseTime("2026-09-01T12:30:00+02:00"); err != nil || !got.Equal(time.Date(2026, 9, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("ParseTime(RFC 3339) = %v, %v", got, err)
+	}
+	if _, err := ParseTime("01/09/2026"); err == nil {
+		t.Error("ParseTime(01/09/2026) succeeded")
+	}
+}
@@ -0,0 +1,309 @@
+// Package fix reads and writes FIX 4.4 tag=value messages. It handles the
+// framing only (BeginString, BodyLength and CheckSum); sessions are up to
+// the caller.
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	BeginString = "FIX.4.4"
+	SOH         = '\x01'
+
+	// maxBodyLength bounds what a peer can make us buffer for one message.
+	maxBodyLength = 1 << 20
+)
+
+// Tags used by the session layer and execution reports.
+const (
+	TagAccount              = 1
+	TagAvgPx                = 6
+	TagBeginSeqNo           = 7
+	TagBeginString          = 8
+	TagBodyLength           = 9
+	TagCheckSum             = 10
+	TagClOrdID              = 11
+	TagCumQty               = 14
+	TagEndSeqNo             = 16
+	TagExecID               = 17
+	TagLastPx               = 31
+	TagLastQty              = 32
+	TagMsgSeqNum            = 34
+	TagMsgType              = 35
+	TagNewSeqNo             = 36
+	TagOrderID              = 37
+	TagOrdStatus            = 39
+	TagPossDupFlag          = 43
+	TagRefSeqNum            = 45
+	TagSenderCompID         = 49
+	TagSendingTime          = 52
+	TagSide                 = 54
+	TagSymbol               = 55
+	TagTargetCompID         = 56
+	TagText                 = 58
+	TagEncryptMethod        = 98
+	TagHeartBtInt           = 108
+	TagTestReqID            = 112
+	TagOrigSendingTime      = 122
+	TagGapFillFlag          = 123
+	TagResetSeqNumFlag      = 141
+	TagExecType             = 150
+	TagLeavesQty            = 151
+	TagRefTagID             = 371
+	TagRefMsgType           = 372
+	TagSessionRejectReason  = 373
+	TagBusinessRejectRefID  = 379
+	TagBusinessRejectReason = 380
+	TagUsername             = 553
+	TagPassword             = 554
+)
+
+// Message types.
+const (
+	MsgHeartbeat             = "0"
+	MsgTestRequest           = "1"
+	MsgResendRequest         = "2"
+	MsgReject                = "3"
+	MsgSequenceReset         = "4"
+	MsgLogout                = "5"
+	MsgExecutionReport       = "8"
+	MsgLogon                 = "A"
+	MsgBusinessMessageReject = "j"
+)
+
+// TimeFormat is the UTCTimestamp format of SendingTime.
+const TimeFormat = "20060102-15:04:05.000"
+
+var (
+	// ErrFraming means the stream is not FIX; nothing after it can be read.
+	ErrFraming = errors.New("malformed FIX message")
+	// ErrGarbled is a well-framed message whose checksum or fields are
+	// wrong. FIX ignores these, and the stream can be read on.
+	ErrGarbled = errors.New("garbled FIX message")
+)
+
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is the fields of a message other than BeginString, BodyLength and
+// CheckSum, in the order they are sent.
+type Message []Field
+
+func New(msgType string) Message {
+	return Message{{TagMsgType, msgType}}
+}
+
+func (m Message) Type() string {
+	return m.Get(TagMsgType)
+}
+
+// Get returns the first value of tag, or "" if the message has none.
+func (m Message) Get(tag int) string {
+	v, _ := m.Lookup(tag)
+	return v
+}
+
+func (m Message) Lookup(tag int) (string, bool) {
+	for _, f := range m {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the value of tag as an integer.
+func (m Message) Int(tag int) (int, error) {
+	v, ok := m.Lookup(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d is not an integer: %q", tag, v)
+	}
+	return n, nil
+}
+
+// Float returns the value of tag as a decimal.
+func (m Message) Float(tag int) (float64, error) {
+	v, ok := m.Lookup(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d is not a number: %q", tag, v)
+	}
+	return f, nil
+}
+
+// Bool reports whether tag is set to Y.
+func (m Message) Bool(tag int) bool {
+	return m.Get(tag) == "Y"
+}
+
+// Set replaces the value of tag, or appends it.
+func (m *Message) Set(tag int, value string) {
+	for i, f := range *m {
+		if f.Tag == tag {
+			(*m)[i].Value = value
+			return
+		}
+	}
+	*m = append(*m, Field{tag, value})
+}
+
+func (m *Message) SetInt(tag, value int) {
+	m.Set(tag, strconv.Itoa(value))
+}
+
+func (m *Message) SetTime(tag int, t time.Time) {
+	m.Set(tag, t.UTC().Format(TimeFormat))
+}
+
+// Bytes encodes m with BeginString, BodyLength and CheckSum. MsgType is
+// written first, as FIX requires; the other fields keep their order.
+func (m Message) Bytes() []byte {
+	var body bytes.Buffer
+	writeField(&body, TagMsgType, m.Type())
+	for _, f := range m {
+		if f.Tag != TagMsgType {
+			writeField(&body, f.Tag, f.Value)
+		}
+	}
+
+	var out bytes.Buffer
+	writeField(&out, TagBeginString, BeginString)
+	writeField(&out, TagBodyLength, strconv.Itoa(body.Len()))
+	out.Write(body.Bytes())
+	writeField(&out, TagCheckSum, fmt.Sprintf("%03d", checksum(out.Bytes())))
+	return out.Bytes()
+}
+
+// String shows m with | for SOH, for logs.
+func (m Message) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{SOH}, []byte{'|'}))
+}
+
+func writeField(b *bytes.Buffer, tag int, value string) {
+	b.WriteString(strconv.Itoa(tag))
+	b.WriteByte('=')
+	b.WriteString(value)
+	b.WriteByte(SOH)
+}
+
+func checksum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// Reader reads messages from a stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next message. Errors wrapping ErrGarbled leave the
+// stream at the start of the next message; any other error ends it.
+func (r *Reader) Read() (Message, error) {
+	var raw bytes.Buffer
+	begin, err := r.field(&raw, TagBeginString)
+	if err != nil {
+		return nil, err
+	}
+	if begin != BeginString {
+		return nil, fmt.Errorf("%w: unsupported BeginString %q", ErrFraming, begin)
+	}
+	length, err := r.field(&raw, TagBodyLength)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil || n <= 0 || n > maxBodyLength {
+		return nil, fmt.Errorf("%w: invalid BodyLength %q", ErrFraming, length)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return nil, err
+	}
+	raw.Write(body)
+	sum := checksum(raw.Bytes())
+	trailer, err := r.field(nil, TagCheckSum)
+	if err != nil {
+		return nil, err
+	}
+
+	if want := fmt.Sprintf("%03d", sum); trailer != want {
+		return nil, fmt.Errorf("%w: checksum %s, want %s", ErrGarbled, trailer, want)
+	}
+	m, err := parseFields(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrGarbled, err)
+	}
+	if m.Type() == "" || m[0].Tag != TagMsgType {
+		return nil, fmt.Errorf("%w: MsgType must follow BodyLength", ErrGarbled)
+	}
+	return m, nil
+}
+
+// field reads one tag=value field, which must be tag, copying it to raw.
+func (r *Reader) field(raw *bytes.Buffer, tag int) (string, error) {
+	s, err := r.r.ReadString(SOH)
+	if err != nil {
+		if err == io.EOF && s != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if raw != nil {
+		raw.WriteString(s)
+	}
+	f, err := parseField(s[:len(s)-1])
+	if err != nil || f.Tag != tag {
+		return "", fmt.Errorf("%w: expected tag %d, got %q", ErrFraming, tag, s[:len(s)-1])
+	}
+	return f.Value, nil
+}
+
+func parseFields(body []byte) (Message, error) {
+	if len(body) == 0 || body[len(body)-1] != SOH {
+		return nil, errors.New("body does not end with SOH")
+	}
+	var m Message
+	for _, s := range bytes.Split(body[:len(body)-1], []byte{SOH}) {
+		f, err := parseField(string(s))
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, f)
+	}
+	return m, nil
+}
+
+func parseField(s string) (Field, error) {
+	i := bytes.IndexByte([]byte(s), '=')
+	if i <= 0 {
+		return Field{}, fmt.Errorf("invalid field %q", s)
+	}
+	tag, err := strconv.Atoi(s[:i])
+	if err != nil || tag <= 0 {
+		return Field{}, fmt.Errorf("invalid tag in %q", s)
+	}
+	return Field{tag, s[i+1:]}, nil
+}
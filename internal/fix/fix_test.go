@@ -0,0 +1,61 @@
+package fix
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMessageBytes(t *testing.T) {
+	m := Message{{TagSenderCompID, "LP1"}}
+	m.Set(TagMsgType, MsgHeartbeat)
+	m.SetInt(TagMsgSeqNum, 2)
+	got := strings.ReplaceAll(string(m.Bytes()), "\x01", "|")
+	want := "8=FIX.4.4|9=17|35=0|49=LP1|34=2|10=037|"
+	if got != want {
+		t.Errorf("Bytes = %s, want %s", got, want)
+	}
+}
+
+func TestReader(t *testing.T) {
+	logon := New(MsgLogon)
+	logon.SetInt(TagMsgSeqNum, 1)
+	logon.SetInt(TagHeartBtInt, 30)
+	hb := New(MsgHeartbeat).Bytes()
+	garbled := append(hb[:len(hb)-4:len(hb)-4], "xyz\x01"...)
+	fill := New(MsgExecutionReport)
+	fill.Set(TagSymbol, "EUR/USD")
+	fill.Set(TagText, "a=b")
+
+	var stream bytes.Buffer
+	stream.Write(logon.Bytes())
+	stream.Write(garbled)
+	stream.Write(fill.Bytes())
+	r := NewReader(&stream)
+
+	m, err := r.Read()
+	if err != nil || m.Type() != MsgLogon {
+		t.Fatalf("Read = %v, %v; want the logon", m, err)
+	}
+	if hb, err := m.Int(TagHeartBtInt); err != nil || hb != 30 {
+		t.Errorf("HeartBtInt = %d, %v", hb, err)
+	}
+	if _, err := r.Read(); !errors.Is(err, ErrGarbled) {
+		t.Fatalf("bad checksum: err = %v, want ErrGarbled", err)
+	}
+	m, err = r.Read()
+	if err != nil || m.Get(TagSymbol) != "EUR/USD" || m.Get(TagText) != "a=b" {
+		t.Fatalf("message after a garbled one = %v, %v", m, err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("end of stream: err = %v, want EOF", err)
+	}
+
+	for _, in := range []string{"GET / HTTP/1.1\r\n\x01", "8=FIX.4.2\x019=5\x01", "8=FIX.4.4\x019=x\x01"} {
+		if _, err := NewReader(strings.NewReader(in)).Read(); !errors.Is(err, ErrFraming) {
+			t.Errorf("Read(%q): err = %v, want ErrFraming", in, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Trade fields a CSV column can be mapped to.
+const (
+	FieldTicket = "ticket"
+	FieldSymbol = "symbol"
+	FieldSide   = "side"
+	FieldVolume = "volume"
+	FieldOpen   = "open"
+	FieldClose  = "close"
+)
+
+var fields = []string{FieldTicket, FieldSymbol, FieldSide, FieldVolume, FieldOpen, FieldClose}
+
+// Mapping names the CSV column holding each trade field. Column names are
+// matched without regard to case.
+type Mapping map[string]string
+
+// DefaultMapping reads each field from the column of the same name.
+func DefaultMapping() Mapping {
+	m := Mapping{}
+	for _, f := range fields {
+		m[f] = f
+	}
+	return m
+}
+
+// ParseMapping reads "field=Column,..." pairs over DefaultMapping, e.g.
+// "ticket=Order,symbol=Instrument".
+func ParseMapping(s string) (Mapping, error) {
+	m := DefaultMapping()
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, want field=Column", pair)
+		}
+		if _, known := m[field]; !known {
+			return nil, fmt.Errorf("unknown field %q in column mapping, want one of %s", field, strings.Join(fields, ", "))
+		}
+		m[field] = column
+	}
+	return m, nil
+}
+
+// CSVReader reads trades from a CSV file with a header row. The delimiter
+// may be a comma, semicolon or tab.
+type CSVReader struct {
+	r       *csv.Reader
+	account string
+	columns map[string]int
+}
+
+// NewCSVReader reads the header of r and finds the mapped columns in it.
+func NewCSVReader(r io.Reader, m Mapping, account string) (*CSVReader, error) {
+	br := bufio.NewReader(r)
+	cr := csv.NewReader(br)
+	cr.Comma = sniffComma(peekLine(br))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty file", ErrFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	columns := make(map[string]int, len(fields))
+	for _, f := range slices.Sorted(maps.Keys(m)) {
+		i, ok := index[strings.ToLower(m[f])]
+		if !ok {
+			return nil, fmt.Errorf("%w: no %q column for %s", ErrFormat, m[f], f)
+		}
+		columns[f] = i
+	}
+	return &CSVReader{r: cr, account: account, columns: columns}, nil
+}
+
+func (c *CSVReader) Read() (Record, error) {
+	for {
+		row, err := c.r.Read()
+		if err != nil {
+			return Record{}, err
+		}
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		line, _ := c.r.FieldPos(0)
+		return c.record(line, row), nil
+	}
+}
+
+func (c *CSVReader) record(line int, row []string) Record {
+	rec := Record{Line: line}
+	rec.Trade.Account = c.account
+	get := func(f string) string {
+		if i := c.columns[f]; i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	rec.Ticket = get(FieldTicket)
+	rec.Trade.Symbol = strings.ToUpper(get(FieldSymbol))
+	if rec.Trade.Side, rec.Err = parseSide(get(FieldSide)); rec.Err != nil {
+		return rec
+	}
+	if rec.Trade.Volume, rec.Err = parseNumber(FieldVolume, get(FieldVolume)); rec.Err != nil {
+		return rec
+	}
+	if rec.Trade.Open, rec.Err = parseNumber(FieldOpen, get(FieldOpen)); rec.Err != nil {
+		return rec
+	}
+	rec.Trade.Close, rec.Err = parseNumber(FieldClose, get(FieldClose))
+	return rec
+}
@@ -0,0 +1,197 @@
+// Package importer reads historical trades from CSV files and MetaTrader
+// statements and queues them through internal/db.
+package importer
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+	"gitlab.com/digineat/go-broker-test/internal/trade"
+)
+
+// Formats of an import file.
+const (
+	FormatCSV        = "csv"
+	FormatMetaTrader = "metatrader"
+)
+
+// batchSize is how many rows are queued per transaction, and so how often
+// an import's progress moves.
+const batchSize = 500
+
+// pendingPoll is how often Run checks the queue again while it is waiting
+// for it to drain.
+var pendingPoll = time.Second
+
+var ErrFormat = errors.New("unsupported import file")
+
+// Record is a row of an import file. Err is set when the row could not be
+// read as a trade; Trade has not been validated yet.
+type Record struct {
+	Line   int
+	Ticket string
+	Trade  trade.Trade
+	Err    error
+}
+
+// Reader reads the records of an import file in order; Read returns io.EOF
+// after the last one.
+type Reader interface {
+	Read() (Record, error)
+}
+
+// NewReader reads r as a file of the given format for account. The mapping
+// only applies to CSV files.
+func NewReader(r io.Reader, format string, m Mapping, account string) (Reader, error) {
+	// Statements saved from MetaTrader 5 are UTF-16 with a byte order mark.
+	r = transform.NewReader(r, unicode.BOMOverride(unicode.UTF8.NewDecoder()))
+	switch format {
+	case FormatCSV:
+		return NewCSVReader(r, m, account)
+	case FormatMetaTrader:
+		return NewMetaTraderReader(r, account)
+	}
+	return nil, fmt.Errorf("%w: unknown format %q", ErrFormat, format)
+}
+
+// CountingReader counts the bytes read through it, for progress.
+type CountingReader struct {
+	R io.Reader
+	N int64
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// Run queues the records of r for import id in batches, validated with the
+// rules of POST /trades, and marks the import done or failed. read reports
+// how many bytes of the file have been read so far. When maxPending is
+// positive, each batch waits until no more than maxPending trades and
+// position events are pending, as new submissions are shed with 503.
+func Run(db *sql.DB, id int, r Reader, read func() int64, maxPending int) error {
+	var (
+		trades   []dbm.ImportedTrade
+		rejected []dbm.ImportError
+	)
+	flush := func() error {
+		if len(trades) == 0 && len(rejected) == 0 {
+			return nil
+		}
+		if err := waitForQueue(db, maxPending); err != nil {
+			return err
+		}
+		_, _, err := dbm.RecordImportBatch(db, id, trades, rejected, read())
+		trades, rejected = trades[:0], rejected[:0]
+		return err
+	}
+
+	var err error
+	for {
+		var rec Record
+		if rec, err = r.Read(); err != nil {
+			break
+		}
+		if rec.Err == nil && rec.Ticket == "" {
+			rec.Err = errors.New("ticket must not be empty")
+		}
+		if rec.Err == nil {
+			rec.Err = rec.Trade.Validate()
+		}
+		if rec.Err != nil {
+			rejected = append(rejected, dbm.ImportError{Line: rec.Line, Ticket: rec.Ticket, Error: rec.Err.Error()})
+		} else {
+			trades = append(trades, dbm.ImportedTrade{Ticket: rec.Ticket, Trade: rec.Trade})
+		}
+		if len(trades)+len(rejected) >= batchSize {
+			if err = flush(); err != nil {
+				break
+			}
+		}
+	}
+	if err == io.EOF {
+		err = flush()
+	}
+
+	failure := ""
+	if err != nil {
+		failure = err.Error()
+	}
+	if ferr := dbm.FinishImport(db, id, failure); ferr != nil && err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// waitForQueue returns once no more than max rows are pending.
+func waitForQueue(db *sql.DB, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	for {
+		n, err := dbm.CountPending(db)
+		if err != nil {
+			return fmt.Errorf("failed to count pending trades: %v", err)
+		}
+		if n <= max {
+			return nil
+		}
+		time.Sleep(pendingPoll)
+	}
+}
+
+// sniffComma picks the CSV delimiter used in line among comma, semicolon
+// and tab.
+func sniffComma(line string) rune {
+	comma, most := ',', strings.Count(line, ",")
+	for _, c := range []rune{';', '\t'} {
+		if n := strings.Count(line, string(c)); n > most {
+			comma, most = c, n
+		}
+	}
+	return comma
+}
+
+// peekLine returns the first line of br without consuming it.
+func peekLine(br *bufio.Reader) string {
+	for n := 512; ; n *= 2 {
+		b, err := br.Peek(n)
+		if i := strings.IndexByte(string(b), '\n'); i >= 0 {
+			return string(b[:i])
+		}
+		if err != nil {
+			return string(b)
+		}
+	}
+}
+
+// parseNumber reads a price or volume, allowing spaces as thousands
+// separators.
+func parseNumber(field, s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), " ", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a number", field, s)
+	}
+	return v, nil
+}
+
+// parseSide reads buy or sell in any case.
+func parseSide(s string) (string, error) {
+	switch side := strings.ToLower(strings.TrimSpace(s)); side {
+	case trade.Buy, trade.Sell:
+		return side, nil
+	}
+	return "", fmt.Errorf(`side %q is not "buy" or "sell"`, s)
+}
@@ -0,0 +1,249 @@
+package importer
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/text/encoding/unicode"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err := dbm.InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return db
+}
+
+func readAll(t *testing.T, r Reader) []Record {
+	t.Helper()
+	var recs []Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// summary renders records as "line ticket account side volume symbol open close".
+func summary(recs []Record) string {
+	var b strings.Builder
+	for _, r := range recs {
+		if r.Err != nil {
+			fmt.Fprintf(&b, "%d %s error: %v\n", r.Line, r.Ticket, r.Err)
+			continue
+		}
+		t := r.Trade
+		fmt.Fprintf(&b, "%d %s %s %s %g %s %g %g\n", r.Line, r.Ticket, t.Account, t.Side, t.Volume, t.Symbol, t.Open, t.Close)
+	}
+	return b.String()
+}
+
+func TestCSVReader(t *testing.T) {
+	m, err := ParseMapping("ticket=Order, symbol=Instrument,open=Entry,close=Exit,volume=Lots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := "\ufeffOrder;Instrument;Side;Lots;Entry;Exit;Comment\n" +
+		"1001;eurusd;BUY;1;1.1;1.2;first\n" +
+		"\n" +
+		"1002;GBPUSD;sell;0,5;1.3;1.2;\n" +
+		"1003;GBPUSD;hold;1;1.3;1.2;\n" +
+		"1004;\"USDJPY\";sell;2;150.5;149.5;\"a; b\"\n"
+	r, err := NewReader(strings.NewReader(in), FormatCSV, m, "acc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2 1001 acc1 buy 1 EURUSD 1.1 1.2
+4 1002 error: volume "0,5" is not a number
+5 1003 error: side "hold" is not "buy" or "sell"
+6 1004 acc1 sell 2 USDJPY 150.5 149.5
+`
+	if got := summary(readAll(t, r)); got != want {
+		t.Errorf("records:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := NewReader(strings.NewReader("ticket,symbol\n"), FormatCSV, DefaultMapping(), "acc1"); !errors.Is(err, ErrFormat) {
+		t.Errorf("missing columns: err = %v, want ErrFormat", err)
+	}
+	if _, err := ParseMapping("price=Entry"); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+const mt4Statement = `<html><head><title>Statement: 123456</title></head><body>
+<table>
+<tr align=left><td colspan=2><b>Account: 123456</b></td><td colspan=5><b>Name: Trader</b></td></tr>
+<tr align=left><td colspan=13><b>Closed Transactions:</b></td></tr>
+<tr align=center bgcolor="#C0C0C0"><td>Ticket</td><td nowrap>Open Time</td><td>Type</td><td>Size</td><td>Item</td><td>Price</td><td>S&nbsp;/&nbsp;L</td><td>T&nbsp;/&nbsp;P</td><td nowrap>Close Time</td><td>Price</td><td>Commission</td><td>Taxes</td><td>Swap</td><td>Profit</td></tr>
+<tr align=right><td>5001</td><td class=msdate nowrap>2024.01.02 10:00:00</td><td>buy</td><td class=mspt>1.00</td><td>eurusd.m</td><td>1.10000</td><td>0.00000</td><td>0.00000</td><td class=msdate nowrap>2024.01.02 12:00:00</td><td>1.10500</td><td>0.00</td><td>0.00</td><td>0.00</td><td>500.00</td></tr>
+<tr bgcolor=#E0E0E0 align=right><td>5002</td><td class=msdate>2024.01.03 10:00:00</td><td>sell</td><td class=mspt>0.50</td><td>gbpusd</td><td>1.27000</td><td>0.00000</td><td>0.00000</td><td class=msdate>2024.01.03 11:00:00</td><td>1.26000</td><td>0.00</td><td>0.00</td><td>-1.20</td><td>500.00</td></tr>
+<tr align=right><td>5003</td><td class=msdate>2024.01.03 10:00:00</td><td>buy limit</td><td class=mspt>1.00</td><td>eurusd</td><td>1.09000</td><td>0.00000</td><td>0.00000</td><td class=msdate>2024.01.03 11:00:00</td><td>1.09500</td><td colspan=4 align=center>cancelled</td></tr>
+<tr align=right><td>5004</td><td class=msdate>2024.01.01 09:00:00</td><td>balance</td><td colspan=10 align=left>Deposit</td><td class=mspt>10 000.00</td></tr>
+<tr align=right><td colspan=10>&nbsp;</td><td class=mspt>0.00</td><td class=mspt>0.00</td><td class=mspt>-1.20</td><td class=mspt>1 000.00</td></tr>
+<tr align=left><td colspan=13><b>Open Trades:</b></td></tr>
+<tr align=center bgcolor="#C0C0C0"><td>Ticket</td><td nowrap>Open Time</td><td>Type</td><td>Size</td><td>Item</td><td>Price</td><td>S&nbsp;/&nbsp;L</td><td>T&nbsp;/&nbsp;P</td><td nowrap>&nbsp;</td><td>Price</td><td>Commission</td><td>Taxes</td><td>Swap</td><td>Profit</td></tr>
+<tr align=right><td>5005</td><td class=msdate>2024.01.04 10:00:00</td><td>buy</td><td class=mspt>1.00</td><td>eurusd</td><td>1.10000</td><td>0.00000</td><td>0.00000</td><td>&nbsp;</td><td>1.10100</td><td>0.00</td><td>0.00</td><td>0.00</td><td>100.00</td></tr>
+</table></body></html>`
+
+// mt5Report is the Positions section of an MT5 report followed by its
+// Orders, which are not trades.
+const mt5Report = `<!DOCTYPE html><html><body><div><table>
+<tr align="center"><th colspan="14"><div><b>Trade History Report</b></div></th></tr>
+<tr align="center"><th colspan="14"><div><b>Positions</b></div></th></tr>
+<tr align="center" bgcolor="#E5F0FC"><td nowrap><b>Time</b></td><td><b>Position</b></td><td><b>Symbol</b></td><td><b>Type</b></td><td class="hidden" colspan="8"></td><td><b>Volume</b></td><td><b>Price</b></td><td><b>S / L</b></td><td><b>T / P</b></td><td><b>Time</b></td><td><b>Price</b></td><td><b>Commission</b></td><td><b>Swap</b></td><td><b>Profit</b></td></tr>
+<tr bgcolor="#FFFFFF" align="right"><td>2024.02.01 10:00:00</td><td>7001</td><td>USDJPY</td><td>sell</td><td class="hidden" colspan="8"></td><td>2</td><td>150.500</td><td></td><td></td><td>2024.02.01 14:00:00</td><td>149.500</td><td>0.00</td><td>0.00</td><td>1 338.00</td></tr>
+<tr align="center"><th colspan="14"><div><b>Orders</b></div></th></tr>
+<tr align="center" bgcolor="#E5F0FC"><td><b>Open Time</b></td><td><b>Order</b></td><td><b>Symbol</b></td><td><b>Type</b></td><td><b>Volume</b></td><td><b>Price</b></td><td><b>S / L</b></td><td><b>T / P</b></td><td><b>Time</b></td><td><b>State</b></td></tr>
+<tr bgcolor="#FFFFFF" align="right"><td>2024.02.01 10:00:00</td><td>9001</td><td>USDJPY</td><td>sell</td><td>2 / 2</td><td>150.500</td><td></td><td></td><td>2024.02.01 10:00:00</td><td>filled</td></tr>
+</table></div></body></html>`
+
+func TestMetaTraderReader(t *testing.T) {
+	r, err := NewReader(strings.NewReader(mt4Statement), FormatMetaTrader, nil, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `4 5001 123456 buy 1 EURUSD 1.1 1.105
+5 5002 123456 sell 0.5 GBPUSD 1.27 1.26
+`
+	if got := summary(readAll(t, r)); got != want {
+		t.Errorf("MT4 statement:\n%s\nwant:\n%s", got, want)
+	}
+
+	// MT5 saves reports as UTF-16 with a byte order mark.
+	utf16, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(mt5Report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewReader(strings.NewReader(utf16), FormatMetaTrader, nil, "acc5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summary(readAll(t, r)), "4 7001 acc5 sell 2 USDJPY 150.5 149.5\n"; got != want {
+		t.Errorf("MT5 report:\n%s\nwant:\n%s", got, want)
+	}
+
+	csv := "Ticket\tOpen Time\tType\tSize\tItem\tPrice\tS/L\tT/P\tClose Time\tPrice\tProfit\n" +
+		"6001\t2024.01.02 10:00\tBuy\t1.00\tEURUSD\t1.10000\t0\t0\t2024.01.02 12:00\t1.10500\t500.00\n" +
+		"6002\t2024.01.02 10:00\tbalance\t\t\t\t\t\t\t\t1000.00\n" +
+		"6003\t2024.01.02 10:00\tsell\tx\tEURUSD\t1.10000\t0\t0\t2024.01.02 12:00\t1.10500\t500.00\n"
+	r, err = NewReader(strings.NewReader(csv), FormatMetaTrader, nil, "acc6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `2 6001 acc6 buy 1 EURUSD 1.1 1.105
+4 6003 error: volume "x" is not a number
+`
+	if got := summary(readAll(t, r)); got != want {
+		t.Errorf("MetaTrader CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := setupTestDB(t)
+
+	var in bytes.Buffer
+	in.WriteString("ticket,symbol,side,volume,open,close\n")
+	for i := range batchSize + 10 {
+		fmt.Fprintf(&in, "%d,EURUSD,buy,1,1.1,1.2\n", i+1)
+	}
+	in.WriteString("1,EURUSD,buy,1,1.1,1.2\n")     // duplicate ticket
+	in.WriteString(",EURUSD,buy,1,1.1,1.2\n")      // no ticket
+	in.WriteString("9999,EURUSD,buy,0,1.1,1.2\n")  // invalid volume
+	in.WriteString("9998,EURUSD,buy,1,1.1,oops\n") // unreadable price
+	size := int64(in.Len())
+
+	imp, err := dbm.CreateImport(db, "acc1", FormatCSV, "history.csv", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &CountingReader{R: &in}
+	r, err := NewReader(src, FormatCSV, DefaultMapping(), "acc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(db, imp.ID, r, func() int64 { return src.N }, 0); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	got, err := dbm.GetImport(db, imp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != dbm.ImportDone || got.Rows != batchSize+14 || got.Queued != batchSize+10 || got.Duplicates != 1 || got.Rejected != 3 || got.BytesRead != size {
+		t.Errorf("import = %+v", got)
+	}
+	if len(got.Errors) != 3 || got.Errors[0].Error != "ticket must not be empty" || !strings.Contains(got.Errors[1].Error, "volume must be > 0") {
+		t.Errorf("errors = %+v", got.Errors)
+	}
+	if q, err := dbm.GetTrade(db, 1); err != nil || q.Account != "acc1" || q.State != dbm.StatePending {
+		t.Errorf("first queued trade = %+v, %v", q, err)
+	}
+}
+
+func TestRunWaitsForQueue(t *testing.T) {
+	db := setupTestDB(t)
+	pendingPoll = 10 * time.Millisecond
+	t.Cleanup(func() { pendingPoll = time.Second })
+
+	ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+		{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"},
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp, err := dbm.CreateImport(db, "acc1", FormatCSV, "history.csv", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(strings.NewReader("ticket,symbol,side,volume,open,close\n1,EURUSD,buy,1,1.1,1.2\n"), FormatCSV, DefaultMapping(), "acc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- Run(db, imp.ID, r, func() int64 { return 0 }, 1) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Run finished over the pending limit: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n, _ := dbm.CountPending(db); n != 2 {
+		t.Errorf("pending while waiting = %d, want 2", n)
+	}
+
+	if err := dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run still waiting after the queue drained")
+	}
+	if got, err := dbm.GetImport(db, imp.ID); err != nil || got.State != dbm.ImportDone || got.Queued != 1 {
+		t.Errorf("import = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,249 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxColspan bounds the cells a single HTML cell can stand for.
+const maxColspan = 64
+
+// MetaTraderReader reads the closed trades of a MetaTrader statement: the
+// "Closed Transactions" of an MT4 statement or the "Positions" of an MT5
+// report, saved as HTML or exported as CSV. Rows of other sections, and
+// balance, pending order and cancelled rows, are skipped.
+type MetaTraderReader struct {
+	rows    func() (int, []string, error)
+	account string
+	section string
+	columns *statementColumns
+}
+
+// statementColumns are the positions of a trade's fields in a statement
+// table: the first Price column is the open and the second the close.
+type statementColumns struct {
+	ticket, typ, volume, symbol, open, close int
+}
+
+// NewMetaTraderReader reads r as an HTML statement if it starts with a tag
+// and as a CSV export otherwise.
+func NewMetaTraderReader(r io.Reader, account string) (*MetaTraderReader, error) {
+	br := bufio.NewReader(r)
+	if strings.HasPrefix(strings.TrimSpace(peekLine(br)), "<") {
+		return &MetaTraderReader{rows: htmlRows(br), account: account}, nil
+	}
+	cr := csv.NewReader(br)
+	cr.Comma = sniffComma(peekLine(br))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	rows := func() (int, []string, error) {
+		row, err := cr.Read()
+		if err != nil {
+			return 0, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		return line, row, nil
+	}
+	return &MetaTraderReader{rows: rows, account: account}, nil
+}
+
+func (m *MetaTraderReader) Read() (Record, error) {
+	for {
+		line, row, err := m.rows()
+		if err != nil {
+			return Record{}, err
+		}
+		for i := range row {
+			row[i] = strings.Join(strings.Fields(row[i]), " ")
+		}
+
+		if cols := headerColumns(row); cols != nil {
+			m.columns = nil
+			if closedSection(m.section) {
+				m.columns = cols
+			}
+			continue
+		}
+		if title, ok := sectionTitle(row); ok {
+			m.section, m.columns = title, nil
+			continue
+		}
+		if m.columns == nil {
+			continue
+		}
+		ticket := cell(row, m.columns.ticket)
+		if _, err := strconv.ParseUint(ticket, 10, 64); err != nil {
+			// totals and notes
+			continue
+		}
+		side := strings.ToLower(cell(row, m.columns.typ))
+		if side != "buy" && side != "sell" {
+			continue
+		}
+		return m.record(line, ticket, side, row), nil
+	}
+}
+
+func (m *MetaTraderReader) record(line int, ticket, side string, row []string) Record {
+	rec := Record{Line: line, Ticket: ticket}
+	rec.Trade.Account = m.account
+	rec.Trade.Side = side
+	rec.Trade.Symbol = statementSymbol(cell(row, m.columns.symbol))
+	// MT5 writes the volume of a position as "filled / requested".
+	volume, _, _ := strings.Cut(cell(row, m.columns.volume), "/")
+	if rec.Trade.Volume, rec.Err = parseNumber("volume", volume); rec.Err != nil {
+		return rec
+	}
+	if rec.Trade.Open, rec.Err = parseNumber("open price", cell(row, m.columns.open)); rec.Err != nil {
+		return rec
+	}
+	rec.Trade.Close, rec.Err = parseNumber("close price", cell(row, m.columns.close))
+	return rec
+}
+
+// headerColumns recognises the header of a table of trades.
+func headerColumns(row []string) *statementColumns {
+	c := statementColumns{-1, -1, -1, -1, -1, -1}
+	for i, name := range row {
+		switch strings.ToLower(name) {
+		case "ticket", "position":
+			c.ticket = i
+		case "type":
+			c.typ = i
+		case "size", "volume", "lots":
+			c.volume = i
+		case "item", "symbol":
+			c.symbol = i
+		case "price":
+			if c.open < 0 {
+				c.open = i
+			} else if c.close < 0 {
+				c.close = i
+			}
+		}
+	}
+	if c.ticket < 0 || c.typ < 0 || c.volume < 0 || c.symbol < 0 || c.open < 0 || c.close < 0 {
+		return nil
+	}
+	return &c
+}
+
+// sectionTitle recognises a row holding only a title, like "Closed
+// Transactions:".
+func sectionTitle(row []string) (string, bool) {
+	title := ""
+	for _, c := range row {
+		if c == "" {
+			continue
+		}
+		if title != "" {
+			return "", false
+		}
+		title = c
+	}
+	if _, err := strconv.ParseFloat(title, 64); title == "" || err == nil {
+		return "", false
+	}
+	return strings.ToLower(strings.TrimSuffix(title, ":")), true
+}
+
+// closedSection reports whether a section lists closed trades. A CSV export
+// has no titles and holds nothing else.
+func closedSection(title string) bool {
+	switch title {
+	case "", "closed transactions", "closed trades", "positions", "closed positions":
+		return true
+	}
+	return false
+}
+
+// statementSymbol drops the suffix brokers add to symbols, as in EURUSD.m.
+func statementSymbol(s string) string {
+	s = strings.ToUpper(s)
+	if i := strings.IndexFunc(s, func(r rune) bool { return r < 'A' || r > 'Z' }); i > 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func cell(row []string, i int) string {
+	if i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// htmlRows returns the rows of every table in an HTML document, with a cell
+// spanning several columns followed by empty cells for the rest.
+func htmlRows(r io.Reader) func() (int, []string, error) {
+	z := html.NewTokenizer(r)
+	n := 0
+	inRow := false
+	return func() (int, []string, error) {
+		var row []string
+		// text is the cell text goes to, or -1 outside cells
+		text := -1
+		for {
+			switch z.Next() {
+			case html.ErrorToken:
+				if row != nil {
+					n++
+					return n, row, nil
+				}
+				return 0, nil, z.Err()
+			case html.StartTagToken, html.SelfClosingTagToken:
+				name, hasAttr := z.TagName()
+				switch string(name) {
+				case "tr":
+					inRow, text = true, -1
+					if row != nil {
+						// an unclosed row ends where the next one starts
+						n++
+						return n, row, nil
+					}
+				case "td", "th":
+					if !inRow {
+						continue
+					}
+					span := 1
+					for hasAttr {
+						var key, val []byte
+						key, val, hasAttr = z.TagAttr()
+						if string(key) == "colspan" {
+							if s, err := strconv.Atoi(string(val)); err == nil && s > 1 {
+								span = min(s, maxColspan)
+							}
+						}
+					}
+					text = len(row)
+					row = append(row, make([]string, span)...)
+				case "br":
+					if text >= 0 {
+						row[text] += " "
+					}
+				}
+			case html.EndTagToken:
+				name, _ := z.TagName()
+				switch string(name) {
+				case "td", "th":
+					text = -1
+				case "tr", "table":
+					inRow, text = false, -1
+					if row != nil {
+						n++
+						return n, row, nil
+					}
+				}
+			case html.TextToken:
+				if text >= 0 {
+					row[text] += string(z.Text())
+				}
+			}
+		}
+	}
+}
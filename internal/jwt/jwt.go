@@ -0,0 +1,179 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	HS256 = "HS256"
+)
+
+var (
+	ErrMalformed    = errors.New("malformed token")
+	ErrUnsupported  = errors.New("unsupported algorithm")
+	ErrNoKey        = errors.New("no key for token")
+	ErrSignature    = errors.New("invalid token signature")
+	ErrExpired      = errors.New("token expired")
+	ErrNotYetValid  = errors.New("token not valid yet")
+	ErrClaimInvalid = errors.New("invalid token claim")
+)
+
+type Claims map[string]any
+
+// Strings returns a claim holding either a list of strings or a single
+// string of space or comma separated values.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+	case []any:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Verifier checks compact JWS tokens signed with RS256, ES256 or HS256.
+// Each key only verifies the algorithm matching its type, so an RSA public
+// key can't be used as an HMAC secret. Tokens must carry exp; iss and aud are
+// checked when Issuer and Audience are set.
+type Verifier struct {
+	Keys     []Key
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	Now      func() time.Time
+}
+
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if header.Alg != RS256 && header.Alg != ES256 && header.Alg != HS256 {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, header.Alg)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	found := false
+	verified := false
+	for _, k := range v.Keys {
+		if (header.Kid != "" && k.ID != "" && k.ID != header.Kid) || !k.supports(header.Alg) {
+			continue
+		}
+		found = true
+		if verify(header.Alg, k, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrNoKey
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	return claims, v.validate(claims)
+}
+
+func (v *Verifier) validate(c Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	exp, ok := numericDate(c, "exp")
+	if !ok {
+		return fmt.Errorf("%w: exp is required", ErrClaimInvalid)
+	}
+	if !now.Before(exp.Add(v.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := numericDate(c, "nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if v.Issuer != "" && c.String("iss") != v.Issuer {
+		return fmt.Errorf("%w: iss", ErrClaimInvalid)
+	}
+	if v.Audience != "" && !slices.Contains(c.Strings("aud"), v.Audience) {
+		return fmt.Errorf("%w: aud", ErrClaimInvalid)
+	}
+	return nil
+}
+
+func numericDate(c Claims, name string) (time.Time, bool) {
+	f, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func verify(alg string, k Key, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case RS256:
+		return rsa.VerifyPKCS1v15(k.Public.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case ES256:
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k.Public.(*ecdsa.PublicKey), digest[:], r, s)
+	case HS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
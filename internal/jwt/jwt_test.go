@@ -0,0 +1,172 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sign(t *testing.T, alg, kid string, key any, claims Claims) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("portal-secret")
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa1","n":%q,"e":%q},
+		{"kty":"EC","kid":"ec1","crv":"P-256","x":%q,"y":%q},
+		{"kty":"oct","kid":"hs1","k":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":%q,"e":%q}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()),
+		b64(secret),
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()))
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatalf("ParseJWKS failed: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 signing keys, got %d", len(keys))
+	}
+
+	now := time.Unix(1700000000, 0)
+	v := &Verifier{Keys: keys, Issuer: "portal", Audience: "broker", Now: func() time.Time { return now }}
+	claims := Claims{"sub": "u1", "iss": "portal", "aud": "broker", "exp": float64(now.Add(time.Hour).Unix()), "accounts": []any{"acc1"}}
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      any
+	}{
+		{RS256, "rsa1", rsaKey},
+		{ES256, "ec1", ecKey},
+		{HS256, "hs1", secret},
+		{RS256, "", rsaKey},
+	} {
+		got, err := v.Verify(sign(t, tc.alg, tc.kid, tc.key, claims))
+		if err != nil {
+			t.Errorf("%s/%s: unexpected error %v", tc.alg, tc.kid, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Strings("accounts"), []string{"acc1"}) {
+			t.Errorf("%s: accounts claim = %v", tc.alg, got.Strings("accounts"))
+		}
+	}
+
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	expired := Claims{"iss": "portal", "aud": "broker", "exp": float64(now.Add(-time.Minute).Unix())}
+	future := Claims{"iss": "portal", "aud": "broker", "exp": float64(now.Add(time.Hour).Unix()), "nbf": float64(now.Add(time.Minute).Unix())}
+	noExp := Claims{"iss": "portal", "aud": "broker"}
+	wrongAud := Claims{"iss": "portal", "aud": []any{"other"}, "exp": float64(now.Add(time.Hour).Unix())}
+
+	// HS256 signed with the RSA modulus as secret must not verify
+	confused := sign(t, HS256, "rsa1", rsaKey.N.Bytes(), claims)
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"malformed", "abc", ErrMalformed},
+		{"wrong rsa key", sign(t, RS256, "rsa1", otherRSA, claims), ErrSignature},
+		{"unknown kid", sign(t, RS256, "nope", rsaKey, claims), ErrNoKey},
+		{"alg confusion", confused, ErrNoKey},
+		{"none alg", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{}`)) + ".", ErrUnsupported},
+		{"expired", sign(t, HS256, "hs1", secret, expired), ErrExpired},
+		{"not yet valid", sign(t, HS256, "hs1", secret, future), ErrNotYetValid},
+		{"missing exp", sign(t, HS256, "hs1", secret, noExp), ErrClaimInvalid},
+		{"wrong audience", sign(t, HS256, "hs1", secret, wrongAud), ErrClaimInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := v.Verify(tc.token); !errors.Is(err, tc.want) {
+				t.Errorf("Verify() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadPEM(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	k, err := LoadPEM(path, "ec1")
+	if err != nil {
+		t.Fatalf("LoadPEM failed: %v", err)
+	}
+	if !k.supports(ES256) || k.supports(RS256) {
+		t.Errorf("unexpected key support for %+v", k)
+	}
+
+	now := time.Now()
+	v := &Verifier{Keys: []Key{k}}
+	if _, err := v.Verify(sign(t, ES256, "", ecKey, Claims{"exp": float64(now.Add(time.Hour).Unix())})); err != nil {
+		t.Errorf("token signed by PEM key rejected: %v", err)
+	}
+
+	os.WriteFile(path, []byte("garbage"), 0o600)
+	if _, err := LoadPEM(path, ""); err == nil {
+		t.Error("expected error for non-PEM file")
+	}
+}
+
+func TestClaimsStrings(t *testing.T) {
+	c := Claims{"a": "x y,z", "b": []any{"x", 1.0, "y"}, "c": 3.0}
+	if got := c.Strings("a"); !reflect.DeepEqual(got, []string{"x", "y", "z"}) {
+		t.Errorf("Strings(a) = %v", got)
+	}
+	if got := c.Strings("b"); !reflect.DeepEqual(got, []string{"x", "y"}) {
+		t.Errorf("Strings(b) = %v", got)
+	}
+	if got := c.Strings("c"); got != nil {
+		t.Errorf("Strings(c) = %v", got)
+	}
+}
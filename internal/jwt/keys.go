@@ -0,0 +1,169 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Key is a verification key. Public holds an *rsa.PublicKey or
+// *ecdsa.PublicKey; Secret holds the shared secret of an HMAC key.
+type Key struct {
+	ID     string
+	Public any
+	Secret []byte
+}
+
+func (k Key) supports(alg string) bool {
+	switch alg {
+	case RS256:
+		_, ok := k.Public.(*rsa.PublicKey)
+		return ok
+	case ES256:
+		pub, ok := k.Public.(*ecdsa.PublicKey)
+		return ok && pub.Curve == elliptic.P256()
+	case HS256:
+		return len(k.Secret) > 0
+	}
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS reads the RSA, P-256 and symmetric keys of a JWKS file. Keys
+// marked for a use other than signatures are skipped.
+func LoadJWKS(path string) ([]Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(b)
+}
+
+func ParseJWKS(b []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	var keys []Key
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %d: %v", i, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signing keys")
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (Key, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return Key{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return Key{}, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return Key{}, errors.New("RSA exponent too large")
+		}
+		return Key{ID: k.Kid, Public: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return Key{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return Key{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return Key{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return Key{}, errors.New("EC point is not on P-256")
+		}
+		return Key{ID: k.Kid, Public: pub}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return Key{}, errors.New("invalid symmetric key")
+		}
+		return Key{ID: k.Kid, Secret: secret}, nil
+	}
+	return Key{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// LoadPEM reads an RSA or ECDSA public key, or a certificate carrying one.
+func LoadPEM(path, kid string) (Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return Key{}, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var pub any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		pub = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return Key{ID: kid, Public: pub}, nil
+	}
+	return Key{}, fmt.Errorf("%s: unsupported public key type %T", path, pub)
+}
@@ -0,0 +1,51 @@
+// Package logging configures the log/slog loggers of the broker binaries.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Flags are the logging command line flags shared by the binaries.
+type Flags struct {
+	Format string
+	Level  string
+}
+
+// Register adds -log-format and -log-level to fs.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Format, "log-format", "text", "log output format: text or json")
+	fs.StringVar(&f.Level, "log-level", "info", "minimum log level: debug, info, warn or error")
+}
+
+// New returns a logger writing to w in format ("text" or "json") that drops
+// records below level.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// Setup builds the logger described by f and makes it the default, which
+// also routes the standard log package through it.
+func (f *Flags) Setup(w io.Writer) error {
+	logger, err := New(w, f.Format, f.Level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
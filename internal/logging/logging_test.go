@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "warn")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept", "request_id", "abc")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1: %q", len(lines), buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("not JSON: %v", err)
+	}
+	if rec["msg"] != "kept" || rec["request_id"] != "abc" || rec["level"] != "WARN" {
+		t.Errorf("record = %v", rec)
+	}
+
+	buf.Reset()
+	logger, err = New(&buf, "text", "DEBUG")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Debug("hello", "n", 1)
+	if !strings.Contains(buf.String(), "msg=hello n=1") {
+		t.Errorf("text output = %q", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", "info"); err == nil {
+		t.Error("accepted unknown format")
+	}
+	if _, err := New(&buf, "text", "loud"); err == nil {
+		t.Error("accepted unknown level")
+	}
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInjectExtract(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	if got := Inject(context.Background()); got != "" {
+		t.Errorf("Inject without span = %q", got)
+	}
+
+	ctx, parent := Start(context.Background(), "enqueue")
+	traceparent := Inject(ctx)
+	parent.End()
+	if traceparent == "" {
+		t.Fatal("Inject returned nothing")
+	}
+
+	ctx = Extract(context.Background(), traceparent)
+	err := DB(ctx, "ApplyTrade", func() error { return errors.New("boom") })
+	if err == nil {
+		t.Fatal("DB swallowed the error")
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child := spans[1]
+	if child.Name != "db.ApplyTrade" || child.Status.Code != codes.Error {
+		t.Errorf("child span = %s %v", child.Name, child.Status)
+	}
+	if child.SpanContext.TraceID() != spans[0].SpanContext.TraceID() || child.Parent.SpanID() != spans[0].SpanContext.SpanID() {
+		t.Error("extracted span context did not continue the trace")
+	}
+}
+
+func TestSetup(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+
+	if _, err := Setup(context.Background(), "zipkin", "test", nil); err == nil {
+		t.Error("accepted unknown exporter")
+	}
+	shutdown, err := Setup(context.Background(), "none", "test", nil)
+	if err != nil {
+		t.Fatalf("Setup(none): %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
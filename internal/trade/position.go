@@ -0,0 +1,61 @@
+package trade
+
+import "fmt"
+
+// volumeEpsilon absorbs float drift from repeated partial closes.
+const volumeEpsilon = 1e-9
+
+// Position is an open event followed by any number of partial closes.
+// Volume is the opened volume and Remaining the part not closed yet.
+type Position struct {
+	ID        string
+	Account   string
+	Symbol    string
+	Side      string
+	Volume    float64
+	Remaining float64
+	Open      float64
+	Realized  float64
+}
+
+func (p Position) Validate() error {
+	if p.ID == "" {
+		return invalid("position id must not be empty")
+	}
+	if err := validateOpen(p.Account, p.Symbol, p.Volume, p.Open); err != nil {
+		return err
+	}
+	return validateSide(p.Side)
+}
+
+func (p Position) IsOpen() bool {
+	return p.Remaining > volumeEpsilon
+}
+
+// Close realizes volume at price and returns the profit of the closed part.
+// A zero volume closes whatever remains.
+func (p *Position) Close(volume, price float64) (float64, error) {
+	if !p.IsOpen() {
+		return 0, invalid(fmt.Sprintf("position %s is already closed", p.ID))
+	}
+	if !(price > 0) {
+		return 0, invalid("close must be > 0")
+	}
+	if volume == 0 {
+		volume = p.Remaining
+	}
+	if !(volume > 0) {
+		return 0, invalid("volume must be > 0")
+	}
+	if volume > p.Remaining+volumeEpsilon {
+		return 0, invalid(fmt.Sprintf("volume %g exceeds remaining %g", volume, p.Remaining))
+	}
+
+	profit := Profit(p.Open, price, volume, p.Side)
+	p.Remaining -= volume
+	if p.Remaining < volumeEpsilon {
+		p.Remaining = 0
+	}
+	p.Realized += profit
+	return profit, nil
+}
@@ -0,0 +1,56 @@
+package trade
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPositionValidate(t *testing.T) {
+	p := Position{ID: "p1", Account: "acc1", Symbol: "EURUSD", Side: Buy, Volume: 1, Remaining: 1, Open: 1.1}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("valid position rejected: %v", err)
+	}
+	p.ID = ""
+	if err := p.Validate(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for empty id, got %v", err)
+	}
+	p.ID = "p1"
+	p.Side = "long"
+	if err := p.Validate(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for bad side, got %v", err)
+	}
+}
+
+func TestPositionClose(t *testing.T) {
+	p := Position{ID: "p1", Account: "acc1", Symbol: "EURUSD", Side: Sell, Volume: 1, Remaining: 1, Open: 2}
+
+	profit, err := p.Close(0.3, 1)
+	if err != nil {
+		t.Fatalf("partial close failed: %v", err)
+	}
+	if profit != 30000 || !p.IsOpen() {
+		t.Errorf("after partial close: profit=%v position=%+v", profit, p)
+	}
+
+	if _, err := p.Close(0.8, 1); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid closing more than remaining, got %v", err)
+	}
+	if _, err := p.Close(0.1, 0); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for zero price, got %v", err)
+	}
+
+	profit, err = p.Close(0, 3)
+	if err != nil {
+		t.Fatalf("full close failed: %v", err)
+	}
+	if profit != -70000 || p.IsOpen() || p.Remaining != 0 {
+		t.Errorf("after full close: profit=%v position=%+v", profit, p)
+	}
+	if p.Realized != -40000 {
+		t.Errorf("realized = %v, want -40000", p.Realized)
+	}
+
+	if _, err := p.Close(0, 3); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid closing a closed position, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package trade
+
+import "time"
+
+// Quote is the latest bid/ask for a symbol.
+type Quote struct {
+	Symbol string
+	Bid    float64
+	Ask    float64
+	Time   time.Time
+}
+
+func (q Quote) Validate() error {
+	switch {
+	case !symbolRe.MatchString(q.Symbol):
+		return invalid("symbol must match ^[A-Z]{6}$")
+	case !(q.Bid > 0):
+		return invalid("bid must be > 0")
+	case !(q.Ask >= q.Bid):
+		return invalid("ask must be >= bid")
+	case q.Time.IsZero():
+		return invalid("timestamp must be set")
+	}
+	return nil
+}
+
+// Mark is the price the remaining volume of a position would close at: buys
+// are sold at the bid and sells are bought back at the ask.
+func (q Quote) Mark(side string) float64 {
+	if side == Sell {
+		return q.Ask
+	}
+	return q.Bid
+}
+
+// Unrealized is the profit p would realize by closing its remaining volume at q.
+func (p Position) Unrealized(q Quote) float64 {
+	return Profit(p.Open, q.Mark(p.Side), p.Remaining, p.Side)
+}
@@ -0,0 +1,36 @@
+package trade
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuoteValidate(t *testing.T) {
+	q := Quote{Symbol: "EURUSD", Bid: 1.1, Ask: 1.1002, Time: time.Unix(1700000000, 0)}
+	if err := q.Validate(); err != nil {
+		t.Fatalf("valid quote rejected: %v", err)
+	}
+	crossed := q
+	crossed.Ask = 1.0
+	if err := crossed.Validate(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for crossed quote, got %v", err)
+	}
+	untimed := q
+	untimed.Time = time.Time{}
+	if err := untimed.Validate(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for missing timestamp, got %v", err)
+	}
+}
+
+func TestUnrealized(t *testing.T) {
+	q := Quote{Symbol: "EURUSD", Bid: 1.5, Ask: 2.5}
+	buy := Position{Side: Buy, Open: 1, Remaining: 2}
+	sell := Position{Side: Sell, Open: 3, Remaining: 1}
+	if got := buy.Unrealized(q); got != 100000 {
+		t.Errorf("buy unrealized = %v, want 100000", got)
+	}
+	if got := sell.Unrealized(q); got != 50000 {
+		t.Errorf("sell unrealized = %v, want 50000", got)
+	}
+}
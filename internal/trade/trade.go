@@ -0,0 +1,83 @@
+package trade
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const Lot = 100000.0
+
+const (
+	Buy  = "buy"
+	Sell = "sell"
+)
+
+var symbolRe = regexp.MustCompile(`^[A-Z]{6}$`)
+
+var ErrInvalid = errors.New("invalid trade payload")
+
+type Trade struct {
+	ID      int
+	Account string
+	Symbol  string
+	Volume  float64
+	Open    float64
+	Close   float64
+	Side    string
+	// RequestID is the X-Request-ID of the submission, kept so the
+	// worker's logs can be correlated with the server's.
+	RequestID string
+	// TraceParent is the W3C trace context of the submission, which the
+	// worker continues when processing the trade.
+	TraceParent string
+}
+
+// Validate checks t against the submission rules. The returned error wraps
+// ErrInvalid and names the first rule that failed.
+func (t Trade) Validate() error {
+	if err := validateOpen(t.Account, t.Symbol, t.Volume, t.Open); err != nil {
+		return err
+	}
+	if !(t.Close > 0) {
+		return invalid("close must be > 0")
+	}
+	return validateSide(t.Side)
+}
+
+func (t Trade) Profit() float64 {
+	return Profit(t.Open, t.Close, t.Volume, t.Side)
+}
+
+func Profit(open, close, volume float64, side string) float64 {
+	profit := (close - open) * volume * Lot
+	if side == Sell {
+		profit = -profit
+	}
+	return profit
+}
+
+func validateOpen(account, symbol string, volume, open float64) error {
+	switch {
+	case account == "":
+		return invalid("account must not be empty")
+	case !symbolRe.MatchString(symbol):
+		return invalid("symbol must match ^[A-Z]{6}$")
+	case !(volume > 0):
+		return invalid("volume must be > 0")
+	case !(open > 0):
+		return invalid("open must be > 0")
+	}
+	return nil
+}
+
+func validateSide(side string) error {
+	if side != Buy && side != Sell {
+		return invalid(`side must be "buy" or "sell"`)
+	}
+	return nil
+}
+
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, reason)
+}
@@ -0,0 +1,59 @@
+package trade
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: Buy}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid trade rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Trade)
+	}{
+		{"empty account", func(t *Trade) { t.Account = "" }},
+		{"lowercase symbol", func(t *Trade) { t.Symbol = "eurusd" }},
+		{"short symbol", func(t *Trade) { t.Symbol = "EUR" }},
+		{"zero volume", func(t *Trade) { t.Volume = 0 }},
+		{"negative volume", func(t *Trade) { t.Volume = -1 }},
+		{"NaN volume", func(t *Trade) { t.Volume = math.NaN() }},
+		{"zero open", func(t *Trade) { t.Open = 0 }},
+		{"zero close", func(t *Trade) { t.Close = 0 }},
+		{"invalid side", func(t *Trade) { t.Side = "hold" }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := valid
+			tc.modify(&tr)
+			err := tr.Validate()
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("error %v does not wrap ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestProfit(t *testing.T) {
+	tests := []struct {
+		trade    Trade
+		expected float64
+	}{
+		{Trade{Volume: 1, Open: 1, Close: 2, Side: Buy}, 100000},
+		{Trade{Volume: 2, Open: 1, Close: 2, Side: Buy}, 200000},
+		{Trade{Volume: 1, Open: 1, Close: 2, Side: Sell}, -100000},
+		{Trade{Volume: 1, Open: 2, Close: 1, Side: Sell}, 100000},
+	}
+	for _, tc := range tests {
+		if got := tc.trade.Profit(); got != tc.expected {
+			t.Errorf("%+v.Profit() = %v, want %v", tc.trade, got, tc.expected)
+		}
+	}
+}
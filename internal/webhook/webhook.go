@@ -0,0 +1,215 @@
+// Package webhook delivers the events queued for webhooks in the
+// webhook_deliveries outbox.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload is the JSON body posted to a webhook. Offset is the event's
+// position in the events table; ID stays the same across retries, so
+// receivers can drop deliveries they have already handled.
+type Payload struct {
+	ID        int64           `json:"id"`
+	Offset    int64           `json:"offset"`
+	Type      string          `json:"type"`
+	Account   string          `json:"account"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the hex HMAC-SHA256 sent in X-Webhook-Signature: the unix
+// timestamp from X-Webhook-Timestamp and the body, joined by a dot.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is valid for the body and timestamp.
+func Verify(secret []byte, timestamp int64, body []byte, signature string) bool {
+	want := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+// Dispatcher posts due deliveries to their webhooks. A delivery succeeds on
+// any 2xx response; anything else is retried with exponential backoff until
+// MaxAttempts attempts have failed.
+type Dispatcher struct {
+	DB          *sql.DB
+	Client      *http.Client
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles on every
+	// further attempt up to MaxBackoff, with up to 20% jitter.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout limits each attempt; claimed deliveries are not handed to
+	// other dispatchers for twice as long per delivery claimed.
+	Timeout time.Duration
+	Batch   int
+
+	now func() time.Time
+}
+
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      &http.Client{},
+		MaxAttempts: 10,
+		Backoff:     5 * time.Second,
+		MaxBackoff:  time.Hour,
+		Timeout:     10 * time.Second,
+		Batch:       100,
+		now:         time.Now,
+	}
+}
+
+// DeliverDue attempts every delivery that is due and returns how many
+// succeeded.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	ds, err := dbm.ClaimDeliveries(d.DB, d.now(), 2*d.Timeout, d.Batch)
+	if err != nil {
+		return 0, fmt.Errorf("error claiming deliveries: %v", err)
+	}
+
+	hooks := map[int]dbm.Webhook{}
+	delivered := 0
+	for _, del := range ds {
+		hook, ok := hooks[del.WebhookID]
+		if !ok {
+			hook, err = dbm.GetWebhook(d.DB, del.WebhookID)
+			if err != nil {
+				return delivered, fmt.Errorf("error loading webhook %d: %v", del.WebhookID, err)
+			}
+			hooks[del.WebhookID] = hook
+		}
+
+		if !d.now().Before(del.NextAttempt) {
+			// The lease ran out; another dispatcher may have the rest.
+			return delivered, nil
+		}
+		res := d.attempt(ctx, hook, del)
+		if ctx.Err() != nil {
+			// Shutting down; the lease expires and the delivery is retried.
+			return delivered, nil
+		}
+		err = dbm.RecordDelivery(d.DB, del.ID, del.NextAttempt, res, d.now())
+		if errors.Is(err, dbm.ErrLeaseLost) {
+			slog.Warn("webhook delivery was claimed again while it was sent", "webhook", hook.ID, "delivery", del.ID)
+			continue
+		}
+		if err != nil {
+			return delivered, fmt.Errorf("error recording delivery %d: %v", del.ID, err)
+		}
+		switch {
+		case res.Delivered:
+			delivered++
+			slog.Debug("delivered webhook", "webhook", hook.ID, "delivery", del.ID, "event", del.Type)
+		case res.Retry.IsZero():
+			slog.Error("webhook delivery failed", "webhook", hook.ID, "delivery", del.ID, "attempts", del.Attempts+1, "status", res.StatusCode, "err", res.Error)
+		default:
+			slog.Warn("webhook delivery will be retried", "webhook", hook.ID, "delivery", del.ID, "attempts", del.Attempts+1, "status", res.StatusCode, "err", res.Error, "retry", res.Retry)
+		}
+	}
+	return delivered, nil
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, hook dbm.Webhook, del dbm.Delivery) dbm.DeliveryResult {
+	body, err := json.Marshal(Payload{
+		ID:        del.ID,
+		Offset:    del.EventID,
+		Type:      del.Type,
+		Account:   del.Account,
+		CreatedAt: del.CreatedAt,
+		Data:      del.Payload,
+	})
+	if err != nil {
+		return dbm.DeliveryResult{Error: err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return dbm.DeliveryResult{Error: err.Error()}
+	}
+	ts := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(del.ID, 10))
+	req.Header.Set(HeaderEvent, del.Type)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign([]byte(hook.Secret), ts, body))
+
+	res := dbm.DeliveryResult{}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		res.StatusCode = resp.StatusCode
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			res.Delivered = true
+			return res
+		}
+		res.Error = resp.Status
+	}
+
+	if attempts := del.Attempts + 1; attempts < d.MaxAttempts {
+		res.Retry = d.now().Add(d.backoff(attempts))
+	}
+	return res
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.MaxBackoff)
+	return delay + time.Duration(rand.Int64N(int64(delay)/5+1))
+}
+
+// Run delivers due webhooks every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := d.DeliverDue(ctx)
+			if err != nil {
+				slog.Error("delivering webhooks", "err", err)
+			} else if n > 0 {
+				slog.Info("delivered webhooks", "count", n)
+			}
+		}
+	}
+}
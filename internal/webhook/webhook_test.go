@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	dbm "gitlab.com/digineat/go-broker-test/internal/db"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err := dbm.InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return db
+}
+
+// receiver checks signatures and records the payloads it accepts, after
+// answering the first failures requests with 503.
+type receiver struct {
+	t        *testing.T
+	secret   []byte
+	mu       sync.Mutex
+	failures int
+	got      []Payload
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	ts, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if !Verify(rc.secret, ts, body, r.Header.Get(HeaderSignature)) {
+		rc.t.Errorf("bad signature on %s", body)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		rc.t.Errorf("bad payload %s: %v", body, err)
+	}
+	if r.Header.Get(HeaderEvent) != p.Type || r.Header.Get(HeaderDelivery) != strconv.FormatInt(p.ID, 10) {
+		rc.t.Errorf("headers %v don't match payload %+v", r.Header, p)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.failures > 0 {
+		rc.failures--
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return
+	}
+	rc.got = append(rc.got, p)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestDispatcher(t *testing.T) {
+	db := setupTestDB(t)
+	rc := &receiver{t: t, failures: 2}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	hook, err := dbm.CreateWebhook(db, srv.URL, nil, []string{"acc1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.secret = []byte(hook.Secret)
+
+	ids, _, err := dbm.EnqueueTrades(db, []dbm.Trade{{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbm.ApplyTrade(db, dbm.Trade{ID: ids[0], Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"}, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	d := NewDispatcher(db)
+	d.Backoff = time.Second
+	d.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	deliver := func(want int) {
+		t.Helper()
+		n, err := d.DeliverDue(ctx)
+		if err != nil {
+			t.Fatalf("DeliverDue: %v", err)
+		}
+		if n != want {
+			t.Fatalf("delivered %d, want %d", n, want)
+		}
+	}
+
+	deliver(0) // 503
+	if deliver(0); len(rc.got) != 0 {
+		t.Fatalf("retried before the backoff elapsed")
+	}
+	now = now.Add(1300 * time.Millisecond)
+	deliver(0) // 503 again; the next delay is doubled
+	now = now.Add(1300 * time.Millisecond)
+	if deliver(0); len(rc.got) != 0 {
+		t.Fatalf("second retry came before the doubled backoff")
+	}
+	now = now.Add(2 * time.Second)
+	deliver(1)
+
+	if len(rc.got) != 1 {
+		t.Fatalf("receiver got %d payloads", len(rc.got))
+	}
+	p := rc.got[0]
+	var trade dbm.TradeEvent
+	if err := json.Unmarshal(p.Data, &trade); err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != dbm.EventTradeProcessed || p.Account != "acc1" || p.Offset == 0 || trade.ID != ids[0] || trade.Profit != 10000 {
+		t.Errorf("payload = %+v, trade %+v", p, trade)
+	}
+
+	ds, err := dbm.ListDeliveries(db, hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].State != dbm.DeliveryDelivered || ds[0].Attempts != 3 || ds[0].StatusCode != http.StatusNoContent {
+		t.Errorf("delivery log = %+v", ds)
+	}
+	deliver(0)
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	db := setupTestDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	t.Cleanup(srv.Close)
+
+	hook, err := dbm.CreateWebhook(db, srv.URL, []string{dbm.EventStatsUpdated}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbm.UpdateStats(db, "acc1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	d := NewDispatcher(db)
+	d.MaxAttempts = 2
+	d.now = func() time.Time { return now }
+	for range 3 {
+		if _, err := d.DeliverDue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(2 * d.MaxBackoff)
+	}
+
+	ds, err := dbm.ListDeliveries(db, hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].State != dbm.DeliveryFailed || ds[0].Attempts != 2 || ds[0].StatusCode != http.StatusGone {
+		t.Errorf("delivery log = %+v", ds)
+	}
+}
+
+func TestDispatcherStopsWhenLeaseRunsOut(t *testing.T) {
+	db := setupTestDB(t)
+	d := NewDispatcher(db)
+	var (
+		mu    sync.Mutex
+		now   time.Time
+		posts int
+	)
+	d.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	// Each post takes the whole lease of a batch of two.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posts++
+		now = now.Add(4 * d.Timeout)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	hook, err := dbm.CreateWebhook(db, srv.URL, []string{dbm.EventStatsUpdated}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := dbm.UpdateStats(db, "acc1", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = time.Now()
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 1 || posts != 1 {
+		t.Fatalf("DeliverDue = %d, %v after %d posts; want the first only", n, err, posts)
+	}
+	ds, err := dbm.ListDeliveries(db, hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 2 || ds[0].State != dbm.DeliveryPending || ds[0].Attempts != 0 || ds[1].State != dbm.DeliveryDelivered {
+		t.Errorf("delivery log = %+v", ds)
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	sig := Sign([]byte("s3cret"), 1700000000, body)
+	if !Verify([]byte("s3cret"), 1700000000, body, sig) {
+		t.Error("signature does not verify")
+	}
+	if Verify([]byte("s3cret"), 1700000001, body, sig) || Verify([]byte("other"), 1700000000, body, sig) {
+		t.Error("signature verifies with another timestamp or secret")
+	}
+}
@@ -0,0 +1,341 @@
+// Package client is a Go client for the broker HTTP API.
+//
+// Submissions carry an Idempotency-Key that stays the same across retries,
+// so a request that timed out can be sent again without queueing the trade
+// twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trade statuses reported by GetTrade.
+const (
+	StatusPending   = "pending"
+	StatusProcessed = "processed"
+	StatusRejected  = "rejected"
+)
+
+// Trade is a trade submission. A zero Close opens a position instead of
+// queueing a closed trade.
+type Trade struct {
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Volume  float64 `json:"volume"`
+	Open    float64 `json:"open"`
+	Close   float64 `json:"close,omitempty"`
+	Side    string  `json:"side"`
+}
+
+// Submission is the server's answer to SubmitTrade: the id of the queued
+// trade, or of the position it opens when Close was zero.
+type Submission struct {
+	ID       int    `json:"id"`
+	Position string `json:"position"`
+	// Replayed is set when the idempotency key had been used before and
+	// the trade was not queued again.
+	Replayed bool `json:"-"`
+}
+
+// TradeStatus is a queued trade and what the worker did with it.
+type TradeStatus struct {
+	ID      int     `json:"id"`
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Volume  float64 `json:"volume"`
+	Open    float64 `json:"open"`
+	Close   float64 `json:"close"`
+	Side    string  `json:"side"`
+	Status  string  `json:"status"`
+	Reason  string  `json:"reason"`
+}
+
+type Stats struct {
+	Account           string  `json:"account"`
+	Trades            int     `json:"trades"`
+	Profit            float64 `json:"profit"`
+	OpenPositions     int     `json:"open_positions"`
+	UnpricedPositions int     `json:"unpriced_positions"`
+	Unrealized        float64 `json:"unrealized"`
+	Equity            float64 `json:"equity"`
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	auth       string
+	signID     string
+	signSecret []byte
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithAPIKey authenticates with a key created by brokerctl keys create.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.auth = "ApiKey " + key
+	}
+}
+
+// WithBearerToken authenticates with a JWT.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.auth = "Bearer " + token
+	}
+}
+
+// WithSigning signs submissions for servers started with -signing-secrets.
+func WithSigning(clientID string, secret []byte) Option {
+	return func(c *Client) {
+		c.signID, c.signSecret = clientID, secret
+	}
+}
+
+// WithRetries sets how many times a request is retried after a network
+// error, 429 or 502-504 response, and the initial delay between attempts,
+// which doubles up to a few seconds. The default is 3 retries from 100ms.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries, c.backoff = n, backoff
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: 3,
+		backoff:    100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey makes the submission made with ctx use key instead of
+// a generated one, so it can be deduplicated across processes or restarts.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	if key, ok := ctx.Value(idempotencyKeyCtx{}).(string); ok && key != "" {
+		return key
+	}
+	return randomHex(16)
+}
+
+func (c *Client) SubmitTrade(ctx context.Context, t Trade) (*Submission, error) {
+	var s Submission
+	h, err := c.do(ctx, call{
+		method: http.MethodPost,
+		path:   "/trades",
+		body:   t,
+		key:    idempotencyKey(ctx),
+		retry:  true,
+	}, &s)
+	if err != nil {
+		return nil, err
+	}
+	s.Replayed = h.Get("Idempotent-Replayed") == "true"
+	return &s, nil
+}
+
+// SubmitBatch queues closed trades in one transaction and returns their ids
+// in order. Either all trades are queued or, on error, none.
+func (c *Client) SubmitBatch(ctx context.Context, trades []Trade) ([]int, error) {
+	var resp struct {
+		IDs []int `json:"ids"`
+	}
+	_, err := c.do(ctx, call{
+		method: http.MethodPost,
+		path:   "/trades/batch",
+		body:   map[string][]Trade{"trades": trades},
+		key:    idempotencyKey(ctx),
+		retry:  true,
+	}, &resp)
+	return resp.IDs, err
+}
+
+func (c *Client) GetTrade(ctx context.Context, id int) (*TradeStatus, error) {
+	var t TradeStatus
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/trades/" + strconv.Itoa(id), retry: true}, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (c *Client) GetStats(ctx context.Context, account string) (*Stats, error) {
+	var s Stats
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/stats/" + url.PathEscape(account), retry: true}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Health returns nil if the server and its database are up. It is not
+// retried.
+func (c *Client) Health(ctx context.Context) error {
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/healthz"}, nil)
+	return err
+}
+
+type call struct {
+	method, path string
+	body         any
+	key          string
+	retry        bool
+}
+
+// do sends the call, retrying temporary failures, and decodes a successful
+// JSON response into out.
+func (c *Client) do(ctx context.Context, cl call, out any) (http.Header, error) {
+	var payload []byte
+	if cl.body != nil {
+		var err error
+		if payload, err = json.Marshal(cl.body); err != nil {
+			return nil, err
+		}
+	}
+
+	attempts := 1
+	if cl.retry {
+		attempts += c.maxRetries
+	}
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait := c.backoff * time.Duration(math.Pow(2, float64(attempt-1)))
+			wait = min(wait, c.maxBackoff)
+			wait = wait/2 + time.Duration(mrand.Int64N(int64(wait/2)+1))
+			var apiErr *APIError
+			if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > wait {
+				wait = min(apiErr.RetryAfter, c.maxBackoff)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		h, err := c.send(ctx, cl, payload, out)
+		if err == nil {
+			return h, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && !apiErr.temporary() {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *Client) send(ctx context.Context, cl call, payload []byte, out any) (http.Header, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, cl.method, c.baseURL+cl.path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.auth != "" {
+		req.Header.Set("Authorization", c.auth)
+	}
+	if cl.key != "" {
+		req.Header.Set("Idempotency-Key", cl.key)
+	}
+	if c.signID != "" && cl.method != http.MethodGet {
+		c.sign(req, payload)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+			RequestID:  resp.Header.Get("X-Request-ID"),
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return nil, apiErr
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("broker: decoding response: %v", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// sign adds the HMAC-SHA256 signature headers over the method, path,
+// canonical query, timestamp, nonce and body. Every attempt gets a fresh
+// nonce since the server rejects reused ones.
+func (c *Client) sign(req *http.Request, body []byte) {
+	ts := time.Now().Unix()
+	nonce := randomHex(16)
+	mac := hmac.New(sha256.New, c.signSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d\n%s\n", req.Method, req.URL.EscapedPath(), req.URL.Query().Encode(), ts, nonce)
+	mac.Write(body)
+
+	req.Header.Set("X-Client-Id", c.signID)
+	req.Header.Set("X-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Nonce", nonce)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
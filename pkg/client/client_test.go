@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubmitTradeRetriesWithSameKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		n := len(keys)
+		mu.Unlock()
+		switch n {
+		case 1:
+			http.Error(w, "queue full", http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]int{"id": 7})
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetries(3, time.Millisecond))
+	s, err := c.SubmitTrade(context.Background(), Trade{Account: "acc1", Symbol: "EURUSD", Volume: 1, Open: 1.1, Close: 1.2, Side: "buy"})
+	if err != nil {
+		t.Fatalf("SubmitTrade failed: %v", err)
+	}
+	if s.ID != 7 || !s.Replayed {
+		t.Errorf("SubmitTrade() = %+v, want id 7 replayed", s)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("server saw %d attempts, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("idempotency keys = %q, want one key reused", keys)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		want    error
+		retried bool
+	}{
+		{http.StatusBadRequest, ErrBadRequest, false},
+		{http.StatusUnauthorized, ErrUnauthorized, false},
+		{http.StatusForbidden, ErrForbidden, false},
+		{http.StatusNotFound, ErrNotFound, false},
+		{http.StatusConflict, ErrConflict, false},
+		{http.StatusInternalServerError, ErrServer, false},
+		{http.StatusTooManyRequests, ErrRateLimited, true},
+		{http.StatusServiceUnavailable, ErrUnavailable, true},
+		{http.StatusBadGateway, ErrServer, true},
+	}
+	for _, tc := range tests {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				http.Error(w, "nope", tc.status)
+			}))
+			defer srv.Close()
+
+			_, err := New(srv.URL, WithRetries(2, time.Millisecond)).GetTrade(context.Background(), 1)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tc.status || apiErr.Message != "nope" {
+				t.Errorf("err = %#v, want *APIError with status %d", err, tc.status)
+			}
+			want := 1
+			if tc.retried {
+				want = 3
+			}
+			if attempts != want {
+				t.Errorf("attempts = %d, want %d", attempts, want)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := New(srv.URL).GetStats(ctx, "acc1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("GetStats took %v after the context expired", time.Since(start))
+	}
+}
+
+func TestHealthIsNotRetried(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "db down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	if err := New(srv.URL).Health(context.Background()); !errors.Is(err, ErrUnavailable) || attempts != 1 {
+		t.Errorf("Health() = %v after %d attempts, want ErrUnavailable after 1", err, attempts)
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Idempotency-Key")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string][]int{"ids": {1, 2}})
+	}))
+	defer srv.Close()
+
+	ctx := WithIdempotencyKey(context.Background(), "import-42")
+	ids, err := New(srv.URL).SubmitBatch(ctx, []Trade{{}, {}})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("SubmitBatch() = %v, %v", ids, err)
+	}
+	if got != "import-42" {
+		t.Errorf("Idempotency-Key = %q, want import-42", got)
+	}
+}
+
+func TestSignCoversQuery(t *testing.T) {
+	c := New("http://broker.test", WithSigning("gw", []byte("s3cret")))
+	body := []byte("ticket,symbol\n")
+	req := httptest.NewRequest("POST", "http://broker.test/imports?format=csv&account=acc1", nil)
+	c.sign(req, body)
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	fmt.Fprintf(mac, "POST\n/imports\naccount=acc1&format=csv\n%s\n%s\n", req.Header.Get("X-Timestamp"), req.Header.Get("X-Nonce"))
+	mac.Write(body)
+	if got, want := req.Header.Get("X-Signature"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("X-Signature = %s, want %s over the sorted query", got, want)
+	}
+}